@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+const (
+	bookTokenTTL           = time.Hour
+	bookTokenPurgeInterval = 15 * time.Minute
+)
+
+// bookTokenResumes caps how often a used token may resume its download.
+var bookTokenResumes = envInt64("BOOK_TOKEN_RESUMES", 2)
+
+// redeemBookToken marks an unused, unrevoked and unexpired token issued to
+// readerId as used and reports true. A token that is already used may still
+// be presented by the same reader until it expires, at most
+// bookTokenResumes times, when resume is set, so an interrupted download
+// can continue with a Range request that resumesDownload accepts; in that case false is returned and
+// the book is not rented a second time.
+// pg.ErrNoRows means the token can't be used by this reader.
+func redeemBookToken(tx orm.DB, bookToken *bookTokens, readerId int64, resume bool) (bool, error) {
+	_, err := tx.QueryOne(bookToken, `UPDATE book_load_tokens SET used_at = CURRENT_TIMESTAMP 
+WHERE token = ? AND reader_id = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP RETURNING *`,
+		bookToken.Token, readerId)
+	if err == nil {
+		return true, nil
+	}
+	if err != pg.ErrNoRows || !resume {
+		return false, err
+	}
+	_, err = tx.QueryOne(bookToken, `UPDATE book_load_tokens SET resumes = resumes + 1
+WHERE token = ? AND reader_id = ? AND used_at IS NOT NULL AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+AND resumes < ? RETURNING *`,
+		bookToken.Token, readerId, bookTokenResumes)
+	return false, err
+}
+
+// findBookToken loads a token issued to readerId that is neither revoked
+// nor expired, whether used or not, to learn its book before redeeming it.
+func findBookToken(bookToken *bookTokens, readerId int64) error {
+	_, err := db.QueryOne(bookToken, `SELECT * FROM book_load_tokens
+WHERE token = ? AND reader_id = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP`, bookToken.Token, readerId)
+	return err
+}
+
+// resumesDownload reports whether a request with header h continues the
+// download of the file with etag: its If-Range names that file and its
+// Range starts past the first byte. Any other request with a used token
+// would fetch the whole book again.
+func resumesDownload(h http.Header, etag string) bool {
+	if etag == "" || h.Get("If-Range") != etag {
+		return false
+	}
+	spec := strings.TrimPrefix(h.Get("Range"), "bytes=")
+	if spec == h.Get("Range") {
+		return false
+	}
+	if i := strings.IndexAny(spec, "-,"); i >= 0 {
+		spec = spec[:i]
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(spec), 10, 64)
+	return err == nil && start > 0
+}
+
+func revokeBookToken(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	bookToken := new(bookTokens)
+	err := bindValid(c, bookToken, "Token")
+	if err == nil {
+		err = revokeToken(bookToken)
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"revoked_at": bookToken.RevokedAt,
+	})
+}
+
+// revokeToken revokes token.Token unless it already is.
+func revokeToken(token *bookTokens) error {
+	_, err := db.QueryOne(token, `UPDATE book_load_tokens SET revoked_at = CURRENT_TIMESTAMP 
+WHERE token = ? AND revoked_at IS NULL RETURNING *`, token.Token)
+	if err == pg.ErrNoRows {
+		err = newAPIError(http.StatusNotFound, codeNotFound, msgBookTokenNotFound)
+	}
+	return err
+}
+
+// purgeBookTokens deletes expired load tokens, and the watermarked copies
+// made for them, every interval. It runs for
+// the lifetime of the process.
+func purgeBookTokens(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		purgeWatermarkCache(bookTokenTTL)
+		res, err := db.Exec(`DELETE FROM book_load_tokens WHERE expires_at <= CURRENT_TIMESTAMP`)
+		if err != nil {
+			log.Println("purge book tokens:", err)
+			continue
+		}
+		if n := res.RowsAffected(); n > 0 {
+			log.Println("purged expired book tokens:", n)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResumesDownload(t *testing.T) {
+	const etag = `"17a-2f"`
+	tests := []struct {
+		rng, ifRange string
+		want         bool
+	}{
+		{"bytes=100-", etag, true},
+		{"bytes=100-199,300-", etag, true},
+		{"bytes=0-", etag, false},
+		{"bytes=-500", etag, false},
+		{"bytes=abc-", etag, false},
+		{"items=100-", etag, false},
+		{"", etag, false},
+		{"bytes=100-", "", false},
+		{"bytes=100-", `"other"`, false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.rng != "" {
+			h.Set("Range", tt.rng)
+		}
+		if tt.ifRange != "" {
+			h.Set("If-Range", tt.ifRange)
+		}
+		if got := resumesDownload(h, etag); got != tt.want {
+			t.Errorf("Range %q If-Range %q: got %v, want %v", tt.rng, tt.ifRange, got, tt.want)
+		}
+	}
+	if resumesDownload(http.Header{"Range": {"bytes=100-"}, "If-Range": {""}}, "") {
+		t.Error("resumed without an ETag")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+)
+
+// serveBookFile streams the file at path to the client as name. http.ServeContent
+// takes care of Range/If-Range (206 and 416), If-None-Match,
+// If-Modified-Since and Last-Modified, so the whole book is never held in
+// memory and interrupted downloads can be resumed.
+func serveBookFile(c *gin.Context, path string, name string, contentType string) {
+	f, err := os.Open(path)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if info.IsDir() {
+		respondError(c, errNotFound)
+		return
+	}
+
+	if contentType == "" {
+		contentType = mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := c.Writer.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", contentDisposition(name))
+	header.Set("ETag", fileETag(info))
+	header.Set("Accept-Ranges", "bytes")
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), f)
+}
+
+// fileETag builds a strong validator from the file size and modification
+// time, which change whenever a librarian replaces the book file.
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// contentDisposition returns an attachment header with an ASCII fallback
+// name and the RFC 5987 encoded original, so Cyrillic titles survive.
+func contentDisposition(name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, url.PathEscape(name))
+}
+
+var errNotAcceptable = newAPIError(http.StatusNotAcceptable, codeNotAcceptable, msgNotAcceptable)
+
+// pickBookFile chooses which of the book's files to send. An explicit
+// ?format= wins, then the Accept header by quality; without a preference
+// the formats are tried in bookFormats order.
+func pickBookFile(c *gin.Context, bookId int64) (BookFile, bookFormat, error) {
+	var files []BookFile
+	_, err := db.Query(&files, `SELECT * FROM book_files WHERE book_id = ?`, bookId)
+	if err != nil {
+		return BookFile{}, bookFormat{}, err
+	}
+	if len(files) == 0 {
+		return BookFile{}, bookFormat{}, pg.ErrNoRows
+	}
+	byFormat := make(map[string]BookFile, len(files))
+	for _, f := range files {
+		byFormat[f.Format] = f
+	}
+
+	var wanted []bookFormat
+	if name := c.Query("format"); name != "" {
+		format, ok := bookFormatByName(name)
+		if !ok {
+			return BookFile{}, bookFormat{}, errNotAcceptable
+		}
+		wanted = []bookFormat{format}
+	} else {
+		wanted = acceptedFormats(c.GetHeader("Accept"))
+	}
+	for _, format := range wanted {
+		if f, ok := byFormat[format.Name]; ok {
+			return f, format, nil
+		}
+	}
+	return BookFile{}, bookFormat{}, errNotAcceptable
+}
+
+// acceptedFormats orders bookFormats by the client's Accept header,
+// dropping the ones it refuses. Wildcards accept every format.
+func acceptedFormats(accept string) []bookFormat {
+	if strings.TrimSpace(accept) == "" {
+		return bookFormats
+	}
+	quality := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if mediaType == "*/*" || mediaType == "application/*" {
+			wildcard = q
+			continue
+		}
+		quality[mediaType] = q
+	}
+
+	var formats []bookFormat
+	for _, f := range bookFormats {
+		q, ok := quality[f.ContentType]
+		if !ok {
+			q = wildcard
+		}
+		if q > 0 {
+			quality[f.ContentType] = q
+			formats = append(formats, f)
+		}
+	}
+	sort.SliceStable(formats, func(i, j int) bool {
+		return quality[formats[i].ContentType] > quality[formats[j].ContentType]
+	})
+	return formats
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAcceptedFormats(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   []string
+	}{
+		{"", []string{"pdf", "epub", "fb2"}},
+		{"*/*", []string{"pdf", "epub", "fb2"}},
+		{"application/epub+zip", []string{"epub"}},
+		{"application/epub+zip, application/pdf;q=0.5", []string{"epub", "pdf"}},
+		{"application/pdf;q=0.2, application/x-fictionbook+xml", []string{"fb2", "pdf"}},
+		{"application/*;q=0.1, application/epub+zip", []string{"epub", "pdf", "fb2"}},
+		{"*/*, application/pdf;q=0", []string{"epub", "fb2"}},
+		{"APPLICATION/PDF ; q=0.9, application/epub+zip;q=bad", []string{"epub", "pdf"}},
+		{"text/html", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, f := range acceptedFormats(tt.accept) {
+			got = append(got, f.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("acceptedFormats(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
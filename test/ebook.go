@@ -0,0 +1,314 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+type bookFormat struct {
+	Name        string
+	ContentType string
+	Ext         string
+}
+
+// bookFormats are the e-book formats accepted by createBook, in the order
+// they are offered when the client has no preference.
+var bookFormats = []bookFormat{
+	{"pdf", "application/pdf", ".pdf"},
+	{"epub", "application/epub+zip", ".epub"},
+	{"fb2", "application/x-fictionbook+xml", ".fb2"},
+}
+
+func bookFormatByName(name string) (bookFormat, bool) {
+	for _, f := range bookFormats {
+		if f.Name == strings.ToLower(name) {
+			return f, true
+		}
+	}
+	return bookFormat{}, false
+}
+
+type BookFile struct {
+	Id       int64  `pg:"id"`
+	BookId   int64  `pg:"book_id"`
+	Format   string `pg:"format" doc:"pdf, epub or fb2"`
+	Filepath string `pg:"filepath"`
+}
+
+// ebookMeta is the metadata found inside an uploaded e-book, used to fill
+// in whatever the librarian left empty.
+type ebookMeta struct {
+	Title       string
+	Authors     []string
+	Language    string
+	ReleaseDate time.Time
+	PageCount   int
+	Cover       image.Image
+}
+
+// detectBookFormat sniffs the uploaded file. EPUB is recognised by the
+// mimetype entry the spec requires to be stored first in the archive, FB2
+// by its FictionBook root element.
+func detectBookFormat(fh *multipart.FileHeader) (bookFormat, error) {
+	if fh.Size > maxBookSize {
+		return bookFormat{}, newAPIError(http.StatusRequestEntityTooLarge, codePayloadTooLarge, msgFileTooLarge, fh.Filename, maxBookSize)
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return bookFormat{}, err
+	}
+	defer f.Close()
+	head := make([]byte, 1024)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return bookFormat{}, err
+	}
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return bookFormats[0], nil
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) && bytes.Contains(head, []byte("mimetypeapplication/epub+zip")):
+		return bookFormats[1], nil
+	case bytes.Contains(head, []byte("<FictionBook")):
+		return bookFormats[2], nil
+	}
+	return bookFormat{}, newAPIError(http.StatusUnsupportedMediaType, codeUnsupportedMedia, msgUnsupportedBook, fh.Filename)
+}
+
+// readEbookMeta extracts metadata from an uploaded book.
+func readEbookMeta(fh *multipart.FileHeader, format bookFormat) (*ebookMeta, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch format.Name {
+	case "epub":
+		return parseEPUB(f, fh.Size)
+	case "fb2":
+		return parseFB2(f)
+	case "pdf":
+		return parsePDF(f)
+	}
+	return nil, nil
+}
+
+func parseEPUB(r io.ReaderAt, size int64) (*ebookMeta, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := decodeZipXML(zr, "META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, errors.New("epub: no rootfile in container.xml")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+	var pkg struct {
+		Titles    []string `xml:"metadata>title"`
+		Creators  []string `xml:"metadata>creator"`
+		Languages []string `xml:"metadata>language"`
+		Dates     []string `xml:"metadata>date"`
+		Metas     []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"metadata>meta"`
+		Items []struct {
+			Id         string `xml:"id,attr"`
+			Href       string `xml:"href,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"manifest>item"`
+	}
+	if err := decodeZipXML(zr, opfPath, &pkg); err != nil {
+		return nil, err
+	}
+
+	meta := &ebookMeta{}
+	if len(pkg.Titles) > 0 {
+		meta.Title = strings.TrimSpace(pkg.Titles[0])
+	}
+	for _, creator := range pkg.Creators {
+		if creator = strings.TrimSpace(creator); creator != "" {
+			meta.Authors = append(meta.Authors, creator)
+		}
+	}
+	if len(pkg.Languages) > 0 {
+		meta.Language = strings.TrimSpace(pkg.Languages[0])
+	}
+	if len(pkg.Dates) > 0 {
+		meta.ReleaseDate = parseLooseDate(pkg.Dates[0])
+	}
+
+	// EPUB 3 marks the cover in the manifest, EPUB 2 with <meta name="cover">.
+	var coverHref string
+	for _, item := range pkg.Items {
+		if strings.Contains(" "+item.Properties+" ", " cover-image ") {
+			coverHref = item.Href
+		}
+	}
+	if coverHref == "" {
+		for _, m := range pkg.Metas {
+			if m.Name != "cover" {
+				continue
+			}
+			for _, item := range pkg.Items {
+				if item.Id == m.Content {
+					coverHref = item.Href
+				}
+			}
+		}
+	}
+	if coverHref != "" {
+		if href, err := url.PathUnescape(coverHref); err == nil {
+			coverHref = href
+		}
+		if rc, err := openZipFile(zr, path.Join(path.Dir(opfPath), coverHref)); err == nil {
+			data, err := ioutil.ReadAll(io.LimitReader(rc, maxImageSize+1))
+			rc.Close()
+			if err == nil && int64(len(data)) <= maxImageSize {
+				meta.Cover, _ = decodeImage(bytes.NewReader(data))
+			}
+		}
+	}
+	return meta, nil
+}
+
+func openZipFile(zr *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, f := range zr.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+	return nil, fmt.Errorf("epub: %s not found", name)
+}
+
+// maxZipXMLSize caps how much of an XML entry of an EPUB is read, the
+// entries are compressed and could expand without bound.
+const maxZipXMLSize = 4 << 20
+
+func decodeZipXML(zr *zip.Reader, name string, v interface{}) error {
+	rc, err := openZipFile(zr, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return newXMLDecoder(io.LimitReader(rc, maxZipXMLSize)).Decode(v)
+}
+
+// newXMLDecoder accepts the legacy encodings (windows-1251, koi8-r) common
+// in Russian FB2 files.
+func newXMLDecoder(r io.Reader) *xml.Decoder {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+	return d
+}
+
+func parseFB2(r io.Reader) (*ebookMeta, error) {
+	var fb struct {
+		TitleInfo struct {
+			BookTitle string `xml:"book-title"`
+			Authors   []struct {
+				FirstName  string `xml:"first-name"`
+				MiddleName string `xml:"middle-name"`
+				LastName   string `xml:"last-name"`
+				Nickname   string `xml:"nickname"`
+			} `xml:"author"`
+			Lang string `xml:"lang"`
+			Date struct {
+				Value string `xml:"value,attr"`
+				Text  string `xml:",chardata"`
+			} `xml:"date"`
+			Cover struct {
+				Image struct {
+					Href string `xml:"href,attr"`
+				} `xml:"image"`
+			} `xml:"coverpage"`
+		} `xml:"description>title-info"`
+		Binaries []struct {
+			Id   string `xml:"id,attr"`
+			Data string `xml:",chardata"`
+		} `xml:"binary"`
+	}
+	if err := newXMLDecoder(r).Decode(&fb); err != nil {
+		return nil, err
+	}
+
+	info := fb.TitleInfo
+	meta := &ebookMeta{
+		Title:    strings.TrimSpace(info.BookTitle),
+		Language: strings.TrimSpace(info.Lang),
+	}
+	for _, a := range info.Authors {
+		name := strings.Join(strings.Fields(a.FirstName+" "+a.MiddleName+" "+a.LastName), " ")
+		if name == "" {
+			name = strings.TrimSpace(a.Nickname)
+		}
+		if name != "" {
+			meta.Authors = append(meta.Authors, name)
+		}
+	}
+	if info.Date.Value != "" {
+		meta.ReleaseDate = parseLooseDate(info.Date.Value)
+	} else {
+		meta.ReleaseDate = parseLooseDate(info.Date.Text)
+	}
+	if id := strings.TrimPrefix(info.Cover.Image.Href, "#"); id != "" {
+		for _, b := range fb.Binaries {
+			if b.Id != id {
+				continue
+			}
+			data, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, strings.NewReader(strings.Join(strings.Fields(b.Data), ""))))
+			if err == nil {
+				meta.Cover, _ = decodeImage(bytes.NewReader(data))
+			}
+		}
+	}
+	return meta, nil
+}
+
+// parseLooseDate understands the partial dates found in e-book metadata
+// ("2004", "2004-05", "2004-05-17T00:00:00Z"). The zero time is returned
+// when nothing matches.
+func parseLooseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	for _, layout := range []string{"2006-01-02", "2006"} {
+		if len(s) > len(layout) {
+			if t, err := time.Parse(layout, s[:len(layout)]); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
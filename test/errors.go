@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/text/language"
+)
+
+// Error codes returned in apiError.Code. Clients should switch on these,
+// the message is meant for people.
+const (
+	codeBadRequest         = "bad_request"
+	codeUnauthorized       = "unauthorized"
+	codeForbidden          = "forbidden"
+	codeNotFound           = "not_found"
+	codeNotAcceptable      = "not_acceptable"
+	codeConflict           = "conflict"
+	codePayloadTooLarge    = "payload_too_large"
+	codeUnsupportedMedia   = "unsupported_media_type"
+	codeValidationFailed   = "validation_failed"
+	codePreconditionFailed = "precondition_failed"
+	codePreconditionNeeded = "precondition_required"
+	codeUnavailable        = "service_unavailable"
+	codeInternal           = "internal_error"
+)
+
+// apiError is the body of every error response:
+//
+//	{"error": {"code": "not_found", "message": "...", "details": ..., "request_id": "..."}}
+//
+// Message is rendered from Key in the language of the request.
+type apiError struct {
+	Status    int           `json:"-"`
+	Key       string        `json:"-"`
+	Args      []interface{} `json:"-"`
+	Code      string        `json:"code"`
+	Message   string        `json:"message"`
+	Details   interface{}   `json:"details,omitempty"`
+	RequestId string        `json:"request_id,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	return printer(language.English).Sprintf(e.Key, e.Args...)
+}
+
+func newAPIError(status int, code string, key string, args ...interface{}) *apiError {
+	return &apiError{Status: status, Code: code, Key: key, Args: args}
+}
+
+// withDetails returns a copy of e carrying details, so the shared errors
+// below are never modified.
+func (e *apiError) withDetails(details interface{}) *apiError {
+	copy := *e
+	copy.Details = details
+	return &copy
+}
+
+var (
+	errUnauthorized = newAPIError(http.StatusUnauthorized, codeUnauthorized, msgUnauthorized)
+	errForbidden    = newAPIError(http.StatusForbidden, codeForbidden, msgForbidden)
+	errNotFound     = newAPIError(http.StatusNotFound, codeNotFound, msgNotFound)
+	// errNoReader answers lending requests of users not linked to a reader
+	errNoReader = newAPIError(http.StatusForbidden, codeForbidden, msgNoReader)
+	// errAccountInactive answers logins of users who have not verified
+	// their email yet
+	errAccountInactive = newAPIError(http.StatusForbidden, codeForbidden, msgAccountInactive)
+	// errVersionChanged answers a write whose If-Match names an old version
+	errVersionChanged  = newAPIError(http.StatusPreconditionFailed, codePreconditionFailed, msgVersionChanged)
+	errIfMatchRequired = newAPIError(http.StatusPreconditionRequired, codePreconditionNeeded, msgIfMatchRequired)
+)
+
+// requestIdHeader carries the id of a request, taken from the client when
+// it sends one so calls can be traced across services.
+const requestIdHeader = "X-Request-ID"
+
+func requestId(c *gin.Context) {
+	id := c.GetHeader(requestIdHeader)
+	if id == "" || len(id) > 64 {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	c.Set("request_id", id)
+	c.Header(requestIdHeader, id)
+	c.Next()
+}
+
+// respondError aborts the request with the envelope for err. Errors that
+// are not apiErrors are translated by toAPIError.
+func respondError(c *gin.Context, err error) {
+	e := localizedError(c, err)
+	c.AbortWithStatusJSON(e.Status, gin.H{"error": e})
+}
+
+// localizedError renders err the way respondError sends it, for handlers
+// that report several errors in one response.
+func localizedError(c *gin.Context, err error) *apiError {
+	e := *toAPIError(err)
+	e.Message = tr(c, e.Key, e.Args...)
+	if fields, ok := e.Details.([]fieldError); ok {
+		e.Details = localizeFieldErrors(printer(requestLanguage(c)), fields)
+	}
+	e.RequestId = c.GetString("request_id")
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("request %s: %v", e.RequestId, err)
+	}
+	return &e
+}
+
+func toAPIError(err error) *apiError {
+	if e, ok := err.(*apiError); ok {
+		return e
+	}
+	if err == pg.ErrNoRows {
+		return errNotFound
+	}
+	if pgErr, ok := err.(pg.Error); ok {
+		return pgAPIError(pgErr)
+	}
+	if errs, ok := err.(validator.ValidationErrors); ok {
+		return validationError(fieldErrors(errs))
+	}
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError, *time.ParseError, *strconv.NumError:
+		return newAPIError(http.StatusBadRequest, codeBadRequest, msgMalformedRequest).withDetails(err.Error())
+	}
+	switch {
+	case err == io.EOF:
+		return newAPIError(http.StatusBadRequest, codeBadRequest, msgEmptyBody)
+	case err == http.ErrMissingFile, err == http.ErrNotMultipart:
+		return newAPIError(http.StatusBadRequest, codeBadRequest, msgMalformedRequest).withDetails(err.Error())
+	case strings.Contains(err.Error(), "request body too large"):
+		return newAPIError(http.StatusRequestEntityTooLarge, codePayloadTooLarge, msgBodyTooLarge)
+	}
+	return newAPIError(http.StatusInternalServerError, codeInternal, msgInternal)
+}
+
+// constraintFields names the request field behind a constraint, so
+// violations come back as field errors like the validator's.
+var constraintFields = map[string]string{
+	"author_author_name_key":          "AuthorName",
+	"genre_genre_key":                 "Genre",
+	"reader_name_key":                 "Name",
+	"book_name_key":                   "Name",
+	"book_isbn_key":                   "Isbn",
+	"book_author_id_fkey":             "AuthorId",
+	"book_genre_id_fkey":              "GenreId",
+	"book_current_reader_fkey":        "CurrentReader",
+	"book_files_book_id_format_key":   "Format",
+	"rental_history_book_id_fkey":     "BookId",
+	"rental_history_reader_id_fkey":   "ReaderId",
+	"users_name_key":                  "Name",
+	"users_email_key":                 "Email",
+	"users_reader_id_key":             "ReaderId",
+	"users_reader_id_fkey":            "ReaderId",
+	"roles_role_key":                  "Role",
+	"holds_book_id_reader_id_key":     "BookId",
+	"holds_book_id_fkey":              "BookId",
+	"book_load_tokens_book_id_fkey":   "BookId",
+	"book_load_tokens_reader_id_fkey": "ReaderId",
+}
+
+// pgAPIError maps PostgreSQL error classes to HTTP: missing references and
+// bad values are the client's fault (422), duplicates and rows still in
+// use are conflicts with the current state (409). The detail of the error
+// quotes the row, it goes to the log and not to the client.
+func pgAPIError(pgErr pg.Error) *apiError {
+	var e *apiError
+	var rule string
+	switch code := pgErr.Field('C'); {
+	case code == "23505":
+		e = newAPIError(http.StatusConflict, codeConflict, msgAlreadyExists)
+		rule = "unique"
+	case code == "23503" && strings.Contains(pgErr.Field('D'), "still referenced"):
+		e = newAPIError(http.StatusConflict, codeConflict, msgStillInUse)
+	case code == "23503":
+		e = newAPIError(http.StatusUnprocessableEntity, codeValidationFailed, msgMissingReference)
+		rule = "exists"
+	case strings.HasPrefix(code, "23"), strings.HasPrefix(code, "22"):
+		e = newAPIError(http.StatusUnprocessableEntity, codeValidationFailed, msgInvalidValue)
+	default:
+		return newAPIError(http.StatusInternalServerError, codeInternal, msgInternal)
+	}
+	constraint := pgErr.Field('n')
+	if detail := pgErr.Field('D'); detail != "" {
+		log.Printf("postgres %s %s: %s", pgErr.Field('C'), constraint, detail)
+	}
+	if field, ok := constraintFields[constraint]; ok && rule != "" {
+		e.Details = []fieldError{{Field: field, Rule: rule}}
+		return e
+	}
+	details := gin.H{}
+	if code := pgErr.Field('C'); strings.HasPrefix(code, "22") || code == "23502" || code == "23514" {
+		details["reason"] = pgErr.Field('M')
+	}
+	if constraint != "" {
+		details["constraint"] = constraint
+	}
+	if len(details) > 0 {
+		e.Details = details
+	}
+	return e
+}
+
+// invalidFile reports an upload that has the right type but can't be
+// processed.
+func invalidFile(key string, err error) *apiError {
+	return newAPIError(http.StatusUnprocessableEntity, codeValidationFailed, key).withDetails(err.Error())
+}
+
+func isLibrarian(c *gin.Context) bool {
+	return c.Keys["role"] == "librarian"
+}
+
+type userKey struct{}
+
+// withUser carries the authenticated user to the gRPC and GraphQL
+// resolvers, which don't see the gin context.
+func withUser(ctx context.Context, user *Users) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// requireLibrarian is isLibrarian for those resolvers.
+func requireLibrarian(ctx context.Context) error {
+	if user, ok := ctx.Value(userKey{}).(*Users); ok && user.Role == "librarian" {
+		return nil
+	}
+	return errForbidden
+}
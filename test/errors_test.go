@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakePGError is a pg.Error with the given fields.
+type fakePGError map[byte]string
+
+func (e fakePGError) Field(k byte) string      { return e[k] }
+func (e fakePGError) IntegrityViolation() bool { return e['C'][:2] == "23" }
+func (e fakePGError) Error() string            { return e['M'] }
+
+func TestPGAPIError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     fakePGError
+		status  int
+		details interface{}
+	}{
+		{"unique", fakePGError{'C': "23505", 'n': "users_email_key", 'D': "Key (email)=(a@b.c) already exists."},
+			http.StatusConflict, []fieldError{{Field: "Email", Rule: "unique"}}},
+		{"missing reference", fakePGError{'C': "23503", 'n': "book_author_id_fkey", 'D': `Key (author_id)=(9) is not present in table "author".`},
+			http.StatusUnprocessableEntity, []fieldError{{Field: "AuthorId", Rule: "exists"}}},
+		{"still referenced", fakePGError{'C': "23503", 'n': "book_author_id_fkey", 'D': `Key (author_id)=(9) is still referenced from table "book".`},
+			http.StatusConflict, gin.H{"constraint": "book_author_id_fkey"}},
+		{"unknown constraint", fakePGError{'C': "23505", 'n': "sessions_pkey", 'D': "Key (id)=(1) already exists."},
+			http.StatusConflict, gin.H{"constraint": "sessions_pkey"}},
+		{"bad value", fakePGError{'C': "22001", 'M': "value too long for type character varying(50)"},
+			http.StatusUnprocessableEntity, gin.H{"reason": "value too long for type character varying(50)"}},
+		{"other", fakePGError{'C': "42P01", 'M': `relation "x" does not exist`},
+			http.StatusInternalServerError, nil},
+	}
+	for _, tt := range tests {
+		e := pgAPIError(tt.err)
+		if e.Status != tt.status || !reflect.DeepEqual(e.Details, tt.details) {
+			t.Errorf("%s: got %d %#v, want %d %#v", tt.name, e.Status, e.Details, tt.status, tt.details)
+		}
+	}
+}
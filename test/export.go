@@ -0,0 +1,444 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+)
+
+// Exports stream the catalogue, the readers and the rental history as CSV,
+// XLSX or JSON Lines. Rows are read in keyset ordered batches and written
+// as they arrive, so the size of an export doesn't depend on memory.
+
+const (
+	csvType   = "text/csv"
+	xlsxType  = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	jsonlType = "application/x-ndjson"
+
+	exportBatchSize = 500
+)
+
+var exportFormats = map[string]string{"csv": csvType, "xlsx": xlsxType, "jsonl": jsonlType}
+
+// exportDate is a DATE column, written without the time of day.
+type exportDate time.Time
+
+// rowWriter writes the rows of one export in its format.
+type rowWriter interface {
+	header(columns []string) error
+	row(values []interface{}) error
+	close() error
+}
+
+// exportBatches returns the next batch of rows, an empty one at the end.
+type exportBatches func() ([][]interface{}, error)
+
+// serveExport streams the rows of next as name.<format>. The format comes
+// from ?format= or the Accept header, CSV by default. Errors after the
+// first batch can't be reported to the client any more and are logged.
+func serveExport(c *gin.Context, name string, columns []string, next exportBatches) {
+	contentType, ok := exportFormats[c.Query("format")]
+	if c.Query("format") == "" {
+		contentType, ok = c.NegotiateFormat(csvType, xlsxType, jsonlType), true
+	}
+	if !ok || contentType == "" {
+		respondError(c, newAPIError(http.StatusNotAcceptable, codeNotAcceptable, msgExportNotAcceptable))
+		return
+	}
+	rows, err := next()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	var w rowWriter
+	switch contentType {
+	case csvType:
+		w = &csvRowWriter{w: csv.NewWriter(c.Writer)}
+		name += ".csv"
+	case xlsxType:
+		w = &xlsxRowWriter{zw: zip.NewWriter(c.Writer), sheet: name}
+		name += ".xlsx"
+	case jsonlType:
+		w = &jsonlRowWriter{w: c.Writer, columns: columns}
+		name += ".jsonl"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", contentDisposition(name))
+	c.Status(http.StatusOK)
+
+	err = w.header(columns)
+	for err == nil && len(rows) > 0 {
+		for _, values := range rows {
+			if err = w.row(values); err != nil {
+				break
+			}
+		}
+		c.Writer.Flush()
+		if err == nil {
+			rows, err = next()
+		}
+	}
+	if err == nil {
+		err = w.close()
+	}
+	if err != nil {
+		log.Printf("request %s: export %s: %v", c.GetString("request_id"), name, err)
+	}
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (w *csvRowWriter) header(columns []string) error {
+	return w.w.Write(columns)
+}
+
+func (w *csvRowWriter) row(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = exportText(v)
+		// keep spreadsheets from evaluating the cell as a formula
+		if _, ok := v.(string); ok && record[i] != "" && strings.ContainsRune("=+-@", rune(record[i][0])) {
+			record[i] = "'" + record[i]
+		}
+	}
+	if err := w.w.Write(record); err != nil {
+		return err
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvRowWriter) close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// exportText renders a value for CSV, null as an empty field.
+func exportText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int:
+		return strconv.Itoa(v)
+	case exportDate:
+		return time.Time(v).Format("2006-01-02")
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// jsonlRowWriter writes an object per row with the keys in column order.
+type jsonlRowWriter struct {
+	w       io.Writer
+	columns []string
+}
+
+func (w *jsonlRowWriter) header(columns []string) error {
+	return nil
+}
+
+func (w *jsonlRowWriter) row(values []interface{}) error {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if d, ok := v.(exportDate); ok {
+			v = exportText(d)
+		}
+		key, _ := json.Marshal(w.columns[i])
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w.w, b.String())
+	return err
+}
+
+func (w *jsonlRowWriter) close() error {
+	return nil
+}
+
+// xlsxRowWriter writes a workbook with a single sheet. The sheet is the
+// last part of the archive and is streamed, its strings are inline so no
+// shared string table has to be built first.
+type xlsxRowWriter struct {
+	zw    *zip.Writer
+	sheet string
+	data  io.Writer
+}
+
+const (
+	xlsxMain = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRels = "http://schemas.openxmlformats.org/package/2006/relationships"
+	xlsxDocs = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+)
+
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<Relationships xmlns="` + xlsxRels + `">` +
+		`<Relationship Id="rId1" Type="` + xlsxDocs + `/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="` + xlsxRels + `">` +
+		`<Relationship Id="rId1" Type="` + xlsxDocs + `/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="` + xlsxDocs + `/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	// styles 1 and 2 are the built-in date and date time formats
+	{"xl/styles.xml", `<styleSheet xmlns="` + xlsxMain + `">` +
+		`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border/></borders>` +
+		`<cellStyleXfs count="1"><xf/></cellStyleXfs>` +
+		`<cellXfs count="3"><xf/><xf numFmtId="14" applyNumberFormat="1"/><xf numFmtId="22" applyNumberFormat="1"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+func (w *xlsxRowWriter) header(columns []string) error {
+	for _, part := range xlsxParts {
+		if err := w.writePart(part.name, part.content); err != nil {
+			return err
+		}
+	}
+	var sheetName strings.Builder
+	xml.EscapeText(&sheetName, []byte(w.sheet))
+	err := w.writePart("xl/workbook.xml", `<workbook xmlns="`+xlsxMain+`" xmlns:r="`+xlsxDocs+`">`+
+		`<sheets><sheet name="`+sheetName.String()+`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+	if err != nil {
+		return err
+	}
+	if w.data, err = w.zw.Create("xl/worksheets/sheet1.xml"); err != nil {
+		return err
+	}
+	if _, err = io.WriteString(w.data, xml.Header+`<worksheet xmlns="`+xlsxMain+`"><sheetData>`); err != nil {
+		return err
+	}
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = column
+	}
+	return w.row(values)
+}
+
+func (w *xlsxRowWriter) writePart(name, content string) error {
+	f, err := w.zw.Create(name)
+	if err == nil {
+		_, err = io.WriteString(f, xml.Header+content)
+	}
+	return err
+}
+
+// xlsxEpoch is day 0 of the serial dates of spreadsheets. Excel counts a
+// 29 February 1900 that didn't exist, so earlier dates are written as text.
+var (
+	xlsxEpoch     = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+	xlsxFirstDate = time.Date(1900, time.March, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func (w *xlsxRowWriter) row(values []interface{}) error {
+	var b strings.Builder
+	b.WriteString("<row>")
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			b.WriteString("<c/>")
+		case int64, int:
+			fmt.Fprintf(&b, "<c><v>%d</v></c>", v)
+		case exportDate:
+			if t := time.Time(v); !t.Before(xlsxFirstDate) {
+				fmt.Fprintf(&b, `<c s="1"><v>%g</v></c>`, t.Sub(xlsxEpoch).Hours()/24)
+				break
+			}
+			w.text(&b, exportText(v))
+		case time.Time:
+			_, offset := v.Zone()
+			if t := v.Add(time.Duration(offset) * time.Second).UTC(); !t.Before(xlsxFirstDate) {
+				fmt.Fprintf(&b, `<c s="2"><v>%.6f</v></c>`, t.Sub(xlsxEpoch).Hours()/24)
+				break
+			}
+			w.text(&b, exportText(v))
+		default:
+			w.text(&b, exportText(v))
+		}
+	}
+	b.WriteString("</row>")
+	_, err := io.WriteString(w.data, b.String())
+	return err
+}
+
+func (w *xlsxRowWriter) text(b *strings.Builder, s string) {
+	b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+	xml.EscapeText(b, []byte(s))
+	b.WriteString("</t></is></c>")
+}
+
+func (w *xlsxRowWriter) close() error {
+	if _, err := io.WriteString(w.data, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+// nullable returns nil for the zero value of a column that can be NULL.
+func nullable(v interface{}) interface{} {
+	switch x := v.(type) {
+	case string:
+		if x == "" {
+			return nil
+		}
+	case int64:
+		if x == 0 {
+			return nil
+		}
+	case int:
+		if x == 0 {
+			return nil
+		}
+	case time.Time:
+		if x.IsZero() {
+			return nil
+		}
+	}
+	return v
+}
+
+var bookExportColumns = []string{"BookId", "Name", "Author", "Genre", "ReleaseDate", "Language", "PageCount", "Isbn", "CurrentReader", "UpdatedAt"}
+
+// exportBooks streams the catalogue with the filters and order of the
+// book list.
+func exportBooks(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	var where []string
+	var args []interface{}
+	switch c.Query("status") {
+	case "rented":
+		where = append(where, "b.current_reader IS NOT NULL")
+	case "free":
+		where = append(where, "b.current_reader IS NULL")
+	}
+	if author := c.Query("author"); author != "" {
+		where = append(where, "a.author_name LIKE '%' || ? || '%'")
+		args = append(args, author)
+	}
+	// keyset on the order column, ties broken by the id
+	sortColumn := map[string]string{"genre": "coalesce(g.genre, '')", "author": "a.author_name"}[c.Query("order")]
+	sortKey := func(b *namedBook) string { return "" }
+	switch c.Query("order") {
+	case "genre":
+		sortKey = func(b *namedBook) string { return b.Genre }
+	case "author":
+		sortKey = func(b *namedBook) string { return b.Author }
+	}
+	order := "b.book_id"
+	if sortColumn != "" {
+		order = sortColumn + ", b.book_id"
+	}
+	where = append(where, "("+order+") > (?)")
+
+	query := `SELECT b.*, a.author_name AS author, g.genre AS genre FROM book b
+JOIN author a ON a.author_id = b.author_id LEFT JOIN genre g ON g.genre_id = b.genre_id
+WHERE ` + strings.Join(where, " AND ") + ` ORDER BY ` + order + ` LIMIT ?`
+	var afterKey string
+	var afterId int64
+	serveExport(c, "books", bookExportColumns, func() ([][]interface{}, error) {
+		var books []namedBook
+		after := []interface{}{afterId}
+		if sortColumn != "" {
+			after = []interface{}{afterKey, afterId}
+		}
+		params := append(append([]interface{}{}, args...), pg.In(after), exportBatchSize)
+		_, err := db.Query(&books, query, params...)
+		rows := make([][]interface{}, len(books))
+		for i := range books {
+			b := &books[i]
+			rows[i] = []interface{}{b.BookId, b.Name, b.Author, nullable(b.Genre), exportDate(b.ReleaseDate),
+				nullable(b.Language), nullable(b.PageCount), nullable(b.Isbn), nullable(b.CurrentReader), b.UpdatedAt}
+			afterKey, afterId = sortKey(b), b.BookId
+		}
+		return rows, err
+	})
+}
+
+var readerExportColumns = []string{"ReaderId", "Name", "BirthDate", "RegistrationDate"}
+
+func exportReaders(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	var afterId int64
+	serveExport(c, "readers", readerExportColumns, func() ([][]interface{}, error) {
+		var readers []Reader
+		_, err := db.Query(&readers, `SELECT * FROM reader WHERE reader_id > ? ORDER BY reader_id LIMIT ?`, afterId, exportBatchSize)
+		rows := make([][]interface{}, len(readers))
+		for i, r := range readers {
+			rows[i] = []interface{}{r.ReaderId, r.Name, exportDate(r.BirthDate), r.RegistrationDate}
+			afterId = r.ReaderId
+		}
+		return rows, err
+	})
+}
+
+var rentalExportColumns = []string{"RentalId", "BookId", "Book", "ReaderId", "Reader", "RentalDate", "ReturnDate"}
+
+// exportRentals streams the rental history with the filters of the rental
+// history list.
+func exportRentals(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	var q rentalQuery
+	if err := bindValid(c, &q); err != nil {
+		respondError(c, err)
+		return
+	}
+	where, args := q.where()
+	query := `SELECT r.*, b.name AS book, rd.name AS reader FROM ` + rentalsFrom + `
+WHERE ` + strings.Join(append(where, "r.rental_id > ?"), " AND ") + ` ORDER BY r.rental_id LIMIT ?`
+	var afterId int64
+	serveExport(c, "rental-history", rentalExportColumns, func() ([][]interface{}, error) {
+		var rentals []namedRental
+		params := append(append([]interface{}{}, args...), afterId, exportBatchSize)
+		_, err := db.Query(&rentals, query, params...)
+		rows := make([][]interface{}, len(rentals))
+		for i, r := range rentals {
+			rows[i] = []interface{}{r.RentalId, r.BookId, r.Book, r.ReaderId, r.Reader, r.RentalDate, nullable(r.ReturnDate)}
+			afterId = r.RentalId
+		}
+		return rows, err
+	})
+}
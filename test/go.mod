@@ -3,21 +3,33 @@ module github.com/my/repo
 go 1.16
 
 require (
-	github.com/gin-gonic/gin v1.7.2 // indirect
-	github.com/go-pg/pg v8.0.7+incompatible // indirect
-	github.com/go-pg/pg/v10 v10.10.2 // indirect
-	github.com/go-playground/validator/v10 v10.7.0 // indirect
-	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
+	github.com/gin-gonic/gin v1.7.2
+	github.com/go-pg/pg v8.0.7+incompatible
+	github.com/go-playground/validator/v10 v10.7.0
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/graph-gophers/dataloader/v6 v6.0.0
+	github.com/graph-gophers/graphql-go v1.3.0
+	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/json-iterator/go v1.1.11 // indirect
 	github.com/leodido/go-urn v1.2.1 // indirect
 	github.com/mattn/go-isatty v0.0.13 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.1 // indirect
+	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
+	github.com/onsi/ginkgo v1.14.2 // indirect
+	github.com/onsi/gomega v1.10.3 // indirect
+	github.com/pdfcpu/pdfcpu v0.3.13
+	github.com/stretchr/testify v1.7.0 // indirect
+	github.com/swaggo/files v0.0.0-20220610200504-28940afbdbfe
 	github.com/ugorji/go v1.2.6 // indirect
-	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97 // indirect
+	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
+	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d
 	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
-	golang.org/x/text v0.3.6 // indirect
-	google.golang.org/protobuf v1.27.1 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	golang.org/x/text v0.3.6
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
+	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
+	mellium.im/sasl v0.2.1 // indirect
 )
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+//go:embed graphql/schema.graphql
+var graphqlSchemaSource string
+
+// graphqlSchema is the read side of the catalogue and lending for clients
+// that want a book with its author, genre and rentals in one request.
+// Writes stay with the REST and gRPC APIs. As there, books, readers and
+// rentals are for librarians only, at the root and nested alike.
+var graphqlSchema = graphql.MustParseSchema(graphqlSchemaSource, &graphqlRoot{},
+	graphql.MaxDepth(8))
+
+// maxPage caps first in every paginated field.
+const maxPage = 100
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// serveGraphQL runs a query for the signed in user. Errors of resolvers
+// carry the code of the REST error in their extensions and a message in
+// the language of the request.
+func serveGraphQL(c *gin.Context) {
+	req := new(graphqlRequest)
+	if err := bindValid(c, req, "Query"); err != nil {
+		respondError(c, err)
+		return
+	}
+	user := &Users{Id: c.GetInt64("id"), Name: c.GetString("username"), Role: c.GetString("role")}
+	ctx := withLoaders(withUser(c.Request.Context(), user))
+	resp := graphqlSchema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	p := printer(requestLanguage(c))
+	for _, qe := range resp.Errors {
+		if qe.ResolverError == nil {
+			continue
+		}
+		e := toAPIError(qe.ResolverError)
+		if e.Status >= http.StatusInternalServerError {
+			log.Printf("graphql: %v", qe.ResolverError)
+		}
+		qe.Message = p.Sprintf(e.Key, e.Args...)
+		qe.Extensions = map[string]interface{}{"code": e.Code}
+		if fields, ok := e.Details.([]fieldError); ok {
+			qe.Extensions["details"] = localizeFieldErrors(p, fields)
+		} else if e.Details != nil {
+			qe.Extensions["details"] = e.Details
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func parseID(id graphql.ID) (int64, error) {
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, newAPIError(http.StatusBadRequest, codeBadRequest, msgInvalidId).withDetails(string(id))
+	}
+	return n, nil
+}
+
+func toID(id int64) graphql.ID {
+	return graphql.ID(strconv.FormatInt(id, 10))
+}
+
+// page clamps the pagination arguments of a field.
+func page(first, offset int32) (limit, skip int) {
+	limit, skip = int(first), int(offset)
+	if limit < 0 {
+		limit = 0
+	}
+	if limit > maxPage {
+		limit = maxPage
+	}
+	if skip < 0 {
+		skip = 0
+	}
+	return limit, skip
+}
+
+// queryPage loads a page of the rows of from matching where into rows and
+// returns how many there are in total.
+func queryPage(rows interface{}, columns, from string, where []string, params []interface{}, order string, first, offset int32) (int32, error) {
+	cond := "TRUE"
+	if len(where) > 0 {
+		cond = strings.Join(where, " AND ")
+	}
+	var total int32
+	_, err := db.QueryOne(pg.Scan(&total), fmt.Sprintf(`SELECT count(*) FROM %s WHERE %s`, from, cond), params...)
+	if err != nil {
+		return 0, err
+	}
+	limit, skip := page(first, offset)
+	params = append(params[:len(params):len(params)], limit, skip)
+	_, err = db.Query(rows, fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT ? OFFSET ?`, columns, from, cond, order), params...)
+	return total, err
+}
+
+type pageArgs struct {
+	First  int32
+	Offset int32
+}
+
+type idArgs struct {
+	Id graphql.ID
+}
+
+type graphqlRoot struct{}
+
+type bookFilter struct {
+	Name     *string
+	AuthorId *graphql.ID
+	GenreId  *graphql.ID
+	Status   *string
+}
+
+// bookOrders are the orders of BookOrder, the id breaks ties.
+var bookOrders = map[string]string{
+	"ID":           "book.book_id",
+	"NAME":         "book.name, book.book_id",
+	"AUTHOR":       "author.author_name, book.book_id",
+	"GENRE":        "genre.genre, book.book_id",
+	"RELEASE_DATE": "book.release_date, book.book_id",
+}
+
+func (graphqlRoot) Books(ctx context.Context, args struct {
+	Filter  *bookFilter
+	OrderBy string
+	pageArgs
+}) (*bookConnection, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	var where []string
+	var params []interface{}
+	if f := args.Filter; f != nil {
+		if f.Name != nil {
+			where = append(where, "book.name ILIKE ?")
+			params = append(params, "%"+*f.Name+"%")
+		}
+		if f.AuthorId != nil {
+			id, err := parseID(*f.AuthorId)
+			if err != nil {
+				return nil, err
+			}
+			where = append(where, "book.author_id = ?")
+			params = append(params, id)
+		}
+		if f.GenreId != nil {
+			id, err := parseID(*f.GenreId)
+			if err != nil {
+				return nil, err
+			}
+			where = append(where, "book.genre_id = ?")
+			params = append(params, id)
+		}
+		if f.Status != nil {
+			if *f.Status == "RENTED" {
+				where = append(where, "book.current_reader IS NOT NULL")
+			} else {
+				where = append(where, "book.current_reader IS NULL")
+			}
+		}
+	}
+	var books []Book
+	total, err := queryPage(&books, "book.*", `book
+LEFT JOIN author ON author.author_id = book.author_id
+LEFT JOIN genre ON genre.genre_id = book.genre_id`, where, params, bookOrders[args.OrderBy], args.First, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	conn := &bookConnection{total: total}
+	for i := range books {
+		loadersFrom(ctx).books.Prime(ctx, idKey(books[i].BookId), &books[i])
+		conn.nodes = append(conn.nodes, &bookResolver{&books[i]})
+	}
+	return conn, nil
+}
+
+func (graphqlRoot) Book(ctx context.Context, args idArgs) (*bookResolver, error) {
+	id, err := parseID(args.Id)
+	if err != nil {
+		return nil, err
+	}
+	return bookByID(ctx, id)
+}
+
+func (graphqlRoot) Authors(ctx context.Context, args struct {
+	Name *string
+	pageArgs
+}) (*authorConnection, error) {
+	var where []string
+	var params []interface{}
+	if args.Name != nil {
+		where = append(where, "author_name ILIKE ?")
+		params = append(params, "%"+*args.Name+"%")
+	}
+	var authors []Author
+	total, err := queryPage(&authors, "*", "author", where, params, "author_id", args.First, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	conn := &authorConnection{total: total}
+	for i := range authors {
+		conn.nodes = append(conn.nodes, &authorResolver{&authors[i]})
+	}
+	return conn, nil
+}
+
+func (graphqlRoot) Author(ctx context.Context, args idArgs) (*authorResolver, error) {
+	id, err := parseID(args.Id)
+	if err != nil {
+		return nil, err
+	}
+	return authorByID(ctx, id)
+}
+
+func (graphqlRoot) Genres() ([]*genreResolver, error) {
+	rows, err := genreResource.all()
+	if err != nil {
+		return nil, err
+	}
+	genres := *rows.(*[]Genre)
+	resolvers := make([]*genreResolver, len(genres))
+	for i := range genres {
+		resolvers[i] = &genreResolver{&genres[i]}
+	}
+	return resolvers, nil
+}
+
+func (graphqlRoot) Genre(ctx context.Context, args idArgs) (*genreResolver, error) {
+	id, err := parseID(args.Id)
+	if err != nil {
+		return nil, err
+	}
+	return genreByID(ctx, id)
+}
+
+func (graphqlRoot) Readers(ctx context.Context, args struct {
+	Name *string
+	pageArgs
+}) (*readerConnection, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	var where []string
+	var params []interface{}
+	if args.Name != nil {
+		where = append(where, "name ILIKE ?")
+		params = append(params, "%"+*args.Name+"%")
+	}
+	var readers []Reader
+	total, err := queryPage(&readers, "*", "reader", where, params, "reader_id", args.First, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	conn := &readerConnection{total: total}
+	for i := range readers {
+		conn.nodes = append(conn.nodes, &readerResolver{&readers[i]})
+	}
+	return conn, nil
+}
+
+func (graphqlRoot) Reader(ctx context.Context, args idArgs) (*readerResolver, error) {
+	id, err := parseID(args.Id)
+	if err != nil {
+		return nil, err
+	}
+	return readerByID(ctx, id)
+}
+
+type rentalFilter struct {
+	BookId   *graphql.ID
+	ReaderId *graphql.ID
+	From     *graphql.Time
+	To       *graphql.Time
+	Open     *bool
+}
+
+func (graphqlRoot) Rentals(ctx context.Context, args struct {
+	Filter *rentalFilter
+	pageArgs
+}) (*rentalConnection, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	var where []string
+	var params []interface{}
+	if f := args.Filter; f != nil {
+		if f.BookId != nil {
+			id, err := parseID(*f.BookId)
+			if err != nil {
+				return nil, err
+			}
+			where = append(where, "book_id = ?")
+			params = append(params, id)
+		}
+		if f.ReaderId != nil {
+			id, err := parseID(*f.ReaderId)
+			if err != nil {
+				return nil, err
+			}
+			where = append(where, "reader_id = ?")
+			params = append(params, id)
+		}
+		if f.From != nil {
+			where = append(where, "rental_date >= ?")
+			params = append(params, f.From.Time)
+		}
+		if f.To != nil {
+			where = append(where, "rental_date < ?")
+			params = append(params, f.To.Time)
+		}
+		if f.Open != nil {
+			if *f.Open {
+				where = append(where, "return_date IS NULL")
+			} else {
+				where = append(where, "return_date IS NOT NULL")
+			}
+		}
+	}
+	var rentals []RentalHistory
+	total, err := queryPage(&rentals, "*", "rental_history", where, params, "rental_date DESC, rental_id DESC", args.First, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	conn := &rentalConnection{total: total}
+	for i := range rentals {
+		conn.nodes = append(conn.nodes, &rentalResolver{&rentals[i]})
+	}
+	return conn, nil
+}
+
+func bookByID(ctx context.Context, id int64) (*bookResolver, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	row, err := load(ctx, loadersFrom(ctx).books, id)
+	if row == nil || err != nil {
+		return nil, err
+	}
+	return &bookResolver{row.(*Book)}, nil
+}
+
+func authorByID(ctx context.Context, id int64) (*authorResolver, error) {
+	row, err := load(ctx, loadersFrom(ctx).authors, id)
+	if row == nil || err != nil {
+		return nil, err
+	}
+	return &authorResolver{row.(*Author)}, nil
+}
+
+func genreByID(ctx context.Context, id int64) (*genreResolver, error) {
+	row, err := load(ctx, loadersFrom(ctx).genres, id)
+	if row == nil || err != nil {
+		return nil, err
+	}
+	return &genreResolver{row.(*Genre)}, nil
+}
+
+func readerByID(ctx context.Context, id int64) (*readerResolver, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	row, err := load(ctx, loadersFrom(ctx).readers, id)
+	if row == nil || err != nil {
+		return nil, err
+	}
+	return &readerResolver{row.(*Reader)}, nil
+}
+
+func booksOf(rows []interface{}) []*bookResolver {
+	books := make([]*bookResolver, len(rows))
+	for i, row := range rows {
+		books[i] = &bookResolver{row.(*Book)}
+	}
+	return books
+}
+
+func rentalsOf(rows []interface{}) []*rentalResolver {
+	rentals := make([]*rentalResolver, len(rows))
+	for i, row := range rows {
+		rentals[i] = &rentalResolver{row.(*RentalHistory)}
+	}
+	return rentals
+}
+
+type bookResolver struct{ b *Book }
+
+func (r *bookResolver) ID() graphql.ID { return toID(r.b.BookId) }
+func (r *bookResolver) Name() string   { return r.b.Name }
+
+func (r *bookResolver) Author(ctx context.Context) (*authorResolver, error) {
+	author, err := authorByID(ctx, r.b.AuthorId)
+	if author == nil && err == nil {
+		err = errNotFound
+	}
+	return author, err
+}
+
+func (r *bookResolver) Genre(ctx context.Context) (*genreResolver, error) {
+	if r.b.GenreId == 0 {
+		return nil, nil
+	}
+	return genreByID(ctx, r.b.GenreId)
+}
+
+func (r *bookResolver) CurrentReader(ctx context.Context) (*readerResolver, error) {
+	if r.b.CurrentReader == 0 {
+		return nil, nil
+	}
+	return readerByID(ctx, r.b.CurrentReader)
+}
+
+func (r *bookResolver) ReleaseDate() graphql.Time { return graphql.Time{Time: r.b.ReleaseDate} }
+func (r *bookResolver) Language() *string         { return optionalString(r.b.Language) }
+func (r *bookResolver) Isbn() *string             { return optionalString(r.b.Isbn) }
+func (r *bookResolver) Version() int32            { return int32(r.b.Version) }
+
+func (r *bookResolver) PageCount() *int32 {
+	if r.b.PageCount == 0 {
+		return nil
+	}
+	n := int32(r.b.PageCount)
+	return &n
+}
+
+func (r *bookResolver) Rentals(ctx context.Context, args pageArgs) ([]*rentalResolver, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := loadPage(ctx, loadersFrom(ctx).rentalsByBook, r.b.BookId, args.First, args.Offset)
+	return rentalsOf(rows), err
+}
+
+type authorResolver struct{ a *Author }
+
+func (r *authorResolver) ID() graphql.ID { return toID(r.a.AuthorId) }
+func (r *authorResolver) Name() string   { return r.a.AuthorName }
+
+func (r *authorResolver) Books(ctx context.Context, args pageArgs) ([]*bookResolver, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := loadPage(ctx, loadersFrom(ctx).booksByAuthor, r.a.AuthorId, args.First, args.Offset)
+	return booksOf(rows), err
+}
+
+type genreResolver struct{ g *Genre }
+
+func (r *genreResolver) ID() graphql.ID { return toID(r.g.GenreId) }
+func (r *genreResolver) Name() string   { return r.g.Genre }
+
+func (r *genreResolver) Books(ctx context.Context, args pageArgs) ([]*bookResolver, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := loadPage(ctx, loadersFrom(ctx).booksByGenre, r.g.GenreId, args.First, args.Offset)
+	return booksOf(rows), err
+}
+
+type readerResolver struct{ r *Reader }
+
+func (r *readerResolver) ID() graphql.ID          { return toID(r.r.ReaderId) }
+func (r *readerResolver) Name() string            { return r.r.Name }
+func (r *readerResolver) BirthDate() graphql.Time { return graphql.Time{Time: r.r.BirthDate} }
+func (r *readerResolver) RegistrationDate() graphql.Time {
+	return graphql.Time{Time: r.r.RegistrationDate}
+}
+
+func (r *readerResolver) CurrentBooks(ctx context.Context) ([]*bookResolver, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := loadPage(ctx, loadersFrom(ctx).booksByReader, r.r.ReaderId, maxPage, 0)
+	return booksOf(rows), err
+}
+
+func (r *readerResolver) Rentals(ctx context.Context, args pageArgs) ([]*rentalResolver, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := loadPage(ctx, loadersFrom(ctx).rentalsByReader, r.r.ReaderId, args.First, args.Offset)
+	return rentalsOf(rows), err
+}
+
+type rentalResolver struct{ h *RentalHistory }
+
+func (r *rentalResolver) ID() graphql.ID           { return toID(r.h.RentalId) }
+func (r *rentalResolver) RentalDate() graphql.Time { return graphql.Time{Time: r.h.RentalDate} }
+func (r *rentalResolver) DueDate() graphql.Time    { return graphql.Time{Time: r.h.DueDate} }
+
+func (r *rentalResolver) Book(ctx context.Context) (*bookResolver, error) {
+	book, err := bookByID(ctx, r.h.BookId)
+	if book == nil && err == nil {
+		err = errNotFound
+	}
+	return book, err
+}
+
+func (r *rentalResolver) Reader(ctx context.Context) (*readerResolver, error) {
+	reader, err := readerByID(ctx, r.h.ReaderId)
+	if reader == nil && err == nil {
+		err = errNotFound
+	}
+	return reader, err
+}
+
+func (r *rentalResolver) ReturnDate() *graphql.Time {
+	if r.h.ReturnDate.IsZero() {
+		return nil
+	}
+	return &graphql.Time{Time: r.h.ReturnDate}
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type bookConnection struct {
+	total int32
+	nodes []*bookResolver
+}
+
+func (c *bookConnection) TotalCount() int32      { return c.total }
+func (c *bookConnection) Nodes() []*bookResolver { return c.nodes }
+
+type authorConnection struct {
+	total int32
+	nodes []*authorResolver
+}
+
+func (c *authorConnection) TotalCount() int32        { return c.total }
+func (c *authorConnection) Nodes() []*authorResolver { return c.nodes }
+
+type readerConnection struct {
+	total int32
+	nodes []*readerResolver
+}
+
+func (c *readerConnection) TotalCount() int32        { return c.total }
+func (c *readerConnection) Nodes() []*readerResolver { return c.nodes }
+
+type rentalConnection struct {
+	total int32
+	nodes []*rentalResolver
+}
+
+func (c *rentalConnection) TotalCount() int32        { return c.total }
+func (c *rentalConnection) Nodes() []*rentalResolver { return c.nodes }
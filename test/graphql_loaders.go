@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/go-pg/pg"
+	"github.com/graph-gophers/dataloader/v6"
+)
+
+// The loaders collect the lookups made while resolving one GraphQL request
+// and run them as one query per kind, so a page of books costs a query for
+// their authors instead of one per book.
+
+type idKey int64
+
+func (k idKey) String() string   { return strconv.FormatInt(int64(k), 10) }
+func (k idKey) Raw() interface{} { return int64(k) }
+
+// pageKey asks for a page of the rows that belong to parent.
+type pageKey struct {
+	parent        int64
+	limit, offset int
+}
+
+func (k pageKey) String() string   { return fmt.Sprintf("%d/%d/%d", k.parent, k.limit, k.offset) }
+func (k pageKey) Raw() interface{} { return k }
+
+type loaders struct {
+	authors, genres, readers, books *dataloader.Loader
+
+	booksByAuthor, booksByGenre, booksByReader *dataloader.Loader
+	rentalsByBook, rentalsByReader             *dataloader.Loader
+}
+
+type loadersKey struct{}
+
+// withLoaders gives a request its own loaders, their cache must not outlive
+// it.
+func withLoaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadersKey{}, &loaders{
+		authors: rowLoader(authorResource),
+		genres:  rowLoader(genreResource),
+		readers: rowLoader(readerResource),
+		books:   rowLoader(bookResource),
+
+		booksByAuthor:   childLoader(Book{}, "book", "author_id", "AuthorId", "book_id"),
+		booksByGenre:    childLoader(Book{}, "book", "genre_id", "GenreId", "book_id"),
+		booksByReader:   childLoader(Book{}, "book", "current_reader", "CurrentReader", "book_id"),
+		rentalsByBook:   childLoader(RentalHistory{}, "rental_history", "book_id", "BookId", "rental_date DESC"),
+		rentalsByReader: childLoader(RentalHistory{}, "rental_history", "reader_id", "ReaderId", "rental_date DESC"),
+	})
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// load returns the row with the id, nil when there is none.
+func load(ctx context.Context, l *dataloader.Loader, id int64) (interface{}, error) {
+	return l.Load(ctx, idKey(id))()
+}
+
+// loadPage returns a page of the rows that belong to parent.
+func loadPage(ctx context.Context, l *dataloader.Loader, parent int64, first, offset int32) ([]interface{}, error) {
+	limit, skip := page(first, offset)
+	rows, err := l.Load(ctx, pageKey{parent, limit, skip})()
+	list, _ := rows.([]interface{})
+	return list, err
+}
+
+// rowLoader looks up rows of res by id.
+func rowLoader(res resource) *dataloader.Loader {
+	return dataloader.NewBatchedLoader(func(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+		ids := make([]int64, len(keys))
+		for i, key := range keys {
+			ids[i] = key.Raw().(int64)
+		}
+		rows := reflect.New(reflect.SliceOf(reflect.TypeOf(res.model)))
+		_, err := db.Query(rows.Interface(), fmt.Sprintf(`SELECT %s FROM %s WHERE %s IN (?)`,
+			res.columns, res.table, res.idColumn), pg.In(ids))
+		byId := map[int64]interface{}{}
+		for i := 0; i < rows.Elem().Len(); i++ {
+			row := rows.Elem().Index(i).Addr().Interface()
+			byId[res.idOf(row)] = row
+		}
+		results := make([]*dataloader.Result, len(keys))
+		for i, id := range ids {
+			results[i] = &dataloader.Result{Data: byId[id], Error: err}
+		}
+		return results
+	})
+}
+
+// childLoader looks up pages of the rows of table whose column, the struct
+// field of model, refers to the parent. Keys asking for the same page size
+// share one query.
+func childLoader(model interface{}, table, column, field, order string) *dataloader.Loader {
+	return dataloader.NewBatchedLoader(func(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+		results := make([]*dataloader.Result, len(keys))
+		pages := map[[2]int][]int{}
+		for i, key := range keys {
+			k := key.Raw().(pageKey)
+			pages[[2]int{k.limit, k.offset}] = append(pages[[2]int{k.limit, k.offset}], i)
+		}
+		for p, indexes := range pages {
+			parents := make([]int64, len(indexes))
+			for j, i := range indexes {
+				parents[j] = keys[i].Raw().(pageKey).parent
+			}
+			rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+			_, err := db.Query(rows.Interface(), fmt.Sprintf(`SELECT c.* FROM unnest(?::bigint[]) AS p (id)
+CROSS JOIN LATERAL (SELECT * FROM %s WHERE %s = p.id ORDER BY %s LIMIT ? OFFSET ?) c`, table, column, order),
+				pg.Array(parents), p[0], p[1])
+			byParent := map[int64][]interface{}{}
+			for i := 0; i < rows.Elem().Len(); i++ {
+				row := rows.Elem().Index(i)
+				parent := row.FieldByName(field).Int()
+				byParent[parent] = append(byParent[parent], row.Addr().Interface())
+			}
+			for j, i := range indexes {
+				results[i] = &dataloader.Result{Data: byParent[parents[j]], Error: err}
+			}
+		}
+		return results
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+//go:generate buf generate proto --path proto/library
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/my/repo/librarypb"
+	"golang.org/x/text/language"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcAddr is where the gRPC API listens, the HTTP API keeps its own port.
+var grpcAddr = envString("GRPC_ADDR", ":9090")
+
+// serveGRPC serves the services of proto/library on addr. They call the
+// same functions as the HTTP handlers.
+func serveGRPC(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer(grpc.UnaryInterceptor(grpcAuth))
+	librarypb.RegisterAuthorServiceServer(s, authorServer{})
+	librarypb.RegisterGenreServiceServer(s, genreServer{})
+	librarypb.RegisterReaderServiceServer(s, readerServer{})
+	librarypb.RegisterBookServiceServer(s, bookServer{})
+	librarypb.RegisterRentalServiceServer(s, rentalServer{})
+	return s.Serve(lis)
+}
+
+// grpcAuth is the gRPC counterpart of verifyAccessToken and localize. The
+// access token comes in the authorization metadata, "Bearer <token>".
+func grpcAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	lang := matchLanguage(firstOf(md.Get("accept-language")))
+	user, err := authenticate(firstOf(md.Get("authorization")))
+	if err == nil && librarianMethod(info.FullMethod) && user.Role != "librarian" {
+		err = errForbidden
+	}
+	if err != nil {
+		return nil, grpcError(lang, err)
+	}
+	resp, err := handler(withUser(ctx, user), req)
+	if err != nil {
+		return nil, grpcError(lang, err)
+	}
+	return resp, nil
+}
+
+// librarianMethod tells the methods only librarians may call, the way
+// resource.register guards the HTTP routes: every write, and every read of
+// readers and books.
+func librarianMethod(fullMethod string) bool {
+	service, method := path.Split(fullMethod)
+	for _, prefix := range []string{"Create", "Update", "Delete"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(service, ".ReaderService/") || strings.HasSuffix(service, ".BookService/")
+}
+
+func firstOf(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// grpcCodes map the status of an apiError to a gRPC code.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.FailedPrecondition,
+	http.StatusPreconditionFailed:    codes.Aborted,
+	http.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.InvalidArgument,
+	http.StatusPreconditionRequired:  codes.FailedPrecondition,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+}
+
+// grpcError turns err into a status the way respondError renders it for
+// HTTP. Field errors are attached as a BadRequest detail.
+func grpcError(lang language.Tag, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	e := toAPIError(err)
+	code, ok := grpcCodes[e.Status]
+	switch {
+	case e.Key == msgAlreadyExists:
+		code = codes.AlreadyExists
+	case !ok:
+		code = codes.Internal
+	}
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("grpc: %v", err)
+	}
+	p := printer(lang)
+	st := status.New(code, p.Sprintf(e.Key, e.Args...))
+	if fields, ok := e.Details.([]fieldError); ok {
+		violations := make([]*errdetails.BadRequest_FieldViolation, len(fields))
+		for i, f := range localizeFieldErrors(p, fields) {
+			violations[i] = &errdetails.BadRequest_FieldViolation{Field: f.Field, Description: f.Message}
+		}
+		if detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/my/repo/librarypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func timestampOf(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func timeOf(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+// updateRow is PUT for an empty mask and PATCH otherwise. Mask paths are
+// the column names, which are also the proto field names.
+func updateRow(res resource, id int64, model interface{}, mask *fieldmaskpb.FieldMask) (interface{}, error) {
+	version := versionOf(model)
+	if len(mask.GetPaths()) == 0 {
+		return model, res.save(id, version, model, nil)
+	}
+	current, err := res.find(id)
+	if err != nil {
+		return nil, err
+	}
+	if versionOf(current) != version {
+		return nil, errVersionChanged
+	}
+	var fields, readOnly []string
+	for _, path := range mask.GetPaths() {
+		field, ok := res.fieldOf(path)
+		if !ok {
+			readOnly = append(readOnly, path)
+			continue
+		}
+		fields = append(fields, field)
+		reflect.Indirect(reflect.ValueOf(current)).FieldByName(field).
+			Set(reflect.Indirect(reflect.ValueOf(model)).FieldByName(field))
+	}
+	if len(readOnly) > 0 {
+		return nil, newAPIError(http.StatusUnprocessableEntity, codeValidationFailed, msgReadOnlyFields).withDetails(readOnly)
+	}
+	return current, res.save(id, version, current, fields)
+}
+
+type authorServer struct {
+	librarypb.UnimplementedAuthorServiceServer
+}
+
+func authorToPB(a *Author) *librarypb.Author {
+	return &librarypb.Author{AuthorId: a.AuthorId, AuthorName: a.AuthorName, Version: a.Version}
+}
+
+func authorFromPB(a *librarypb.Author) *Author {
+	return &Author{AuthorId: a.GetAuthorId(), AuthorName: a.GetAuthorName(), Version: a.GetVersion()}
+}
+
+func (authorServer) ListAuthors(ctx context.Context, req *librarypb.ListAuthorsRequest) (*librarypb.ListAuthorsResponse, error) {
+	rows, err := authorResource.all()
+	if err != nil {
+		return nil, err
+	}
+	resp := &librarypb.ListAuthorsResponse{}
+	for _, a := range *rows.(*[]Author) {
+		resp.Authors = append(resp.Authors, authorToPB(&a))
+	}
+	return resp, nil
+}
+
+func (authorServer) GetAuthor(ctx context.Context, req *librarypb.GetAuthorRequest) (*librarypb.Author, error) {
+	row, err := authorResource.find(req.AuthorId)
+	if err != nil {
+		return nil, err
+	}
+	return authorToPB(row.(*Author)), nil
+}
+
+func (authorServer) CreateAuthor(ctx context.Context, req *librarypb.CreateAuthorRequest) (*librarypb.Author, error) {
+	author := authorFromPB(req.Author)
+	if err := authorResource.insert(author, authorResource.writableFields()); err != nil {
+		return nil, err
+	}
+	return authorToPB(author), nil
+}
+
+func (authorServer) UpdateAuthor(ctx context.Context, req *librarypb.UpdateAuthorRequest) (*librarypb.Author, error) {
+	author := authorFromPB(req.Author)
+	row, err := updateRow(authorResource, author.AuthorId, author, req.UpdateMask)
+	if err != nil {
+		return nil, err
+	}
+	return authorToPB(row.(*Author)), nil
+}
+
+func (authorServer) DeleteAuthor(ctx context.Context, req *librarypb.DeleteAuthorRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, authorResource.delete(req.AuthorId, req.Version)
+}
+
+type genreServer struct {
+	librarypb.UnimplementedGenreServiceServer
+}
+
+func genreToPB(g *Genre) *librarypb.Genre {
+	return &librarypb.Genre{GenreId: g.GenreId, Genre: g.Genre, Version: g.Version}
+}
+
+func genreFromPB(g *librarypb.Genre) *Genre {
+	return &Genre{GenreId: g.GetGenreId(), Genre: g.GetGenre(), Version: g.GetVersion()}
+}
+
+func (genreServer) ListGenres(ctx context.Context, req *librarypb.ListGenresRequest) (*librarypb.ListGenresResponse, error) {
+	rows, err := genreResource.all()
+	if err != nil {
+		return nil, err
+	}
+	resp := &librarypb.ListGenresResponse{}
+	for _, g := range *rows.(*[]Genre) {
+		resp.Genres = append(resp.Genres, genreToPB(&g))
+	}
+	return resp, nil
+}
+
+func (genreServer) GetGenre(ctx context.Context, req *librarypb.GetGenreRequest) (*librarypb.Genre, error) {
+	row, err := genreResource.find(req.GenreId)
+	if err != nil {
+		return nil, err
+	}
+	return genreToPB(row.(*Genre)), nil
+}
+
+func (genreServer) CreateGenre(ctx context.Context, req *librarypb.CreateGenreRequest) (*librarypb.Genre, error) {
+	genre := genreFromPB(req.Genre)
+	if err := genreResource.insert(genre, genreResource.writableFields()); err != nil {
+		return nil, err
+	}
+	return genreToPB(genre), nil
+}
+
+func (genreServer) UpdateGenre(ctx context.Context, req *librarypb.UpdateGenreRequest) (*librarypb.Genre, error) {
+	genre := genreFromPB(req.Genre)
+	row, err := updateRow(genreResource, genre.GenreId, genre, req.UpdateMask)
+	if err != nil {
+		return nil, err
+	}
+	return genreToPB(row.(*Genre)), nil
+}
+
+func (genreServer) DeleteGenre(ctx context.Context, req *librarypb.DeleteGenreRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, genreResource.delete(req.GenreId, req.Version)
+}
+
+type readerServer struct {
+	librarypb.UnimplementedReaderServiceServer
+}
+
+func readerToPB(r *Reader) *librarypb.Reader {
+	return &librarypb.Reader{
+		ReaderId:         r.ReaderId,
+		Name:             r.Name,
+		BirthDate:        timestampOf(r.BirthDate),
+		RegistrationDate: timestampOf(r.RegistrationDate),
+		Version:          r.Version,
+	}
+}
+
+func readerFromPB(r *librarypb.Reader) *Reader {
+	return &Reader{
+		ReaderId:  r.GetReaderId(),
+		Name:      r.GetName(),
+		BirthDate: timeOf(r.GetBirthDate()),
+		Version:   r.GetVersion(),
+	}
+}
+
+func (readerServer) ListReaders(ctx context.Context, req *librarypb.ListReadersRequest) (*librarypb.ListReadersResponse, error) {
+	rows, err := readerResource.all()
+	if err != nil {
+		return nil, err
+	}
+	resp := &librarypb.ListReadersResponse{}
+	for _, r := range *rows.(*[]Reader) {
+		resp.Readers = append(resp.Readers, readerToPB(&r))
+	}
+	return resp, nil
+}
+
+func (readerServer) GetReader(ctx context.Context, req *librarypb.GetReaderRequest) (*librarypb.Reader, error) {
+	row, err := readerResource.find(req.ReaderId)
+	if err != nil {
+		return nil, err
+	}
+	return readerToPB(row.(*Reader)), nil
+}
+
+func (readerServer) CreateReader(ctx context.Context, req *librarypb.CreateReaderRequest) (*librarypb.Reader, error) {
+	reader := readerFromPB(req.Reader)
+	if err := readerResource.insert(reader, readerResource.writableFields()); err != nil {
+		return nil, err
+	}
+	return readerToPB(reader), nil
+}
+
+func (readerServer) UpdateReader(ctx context.Context, req *librarypb.UpdateReaderRequest) (*librarypb.Reader, error) {
+	reader := readerFromPB(req.Reader)
+	row, err := updateRow(readerResource, reader.ReaderId, reader, req.UpdateMask)
+	if err != nil {
+		return nil, err
+	}
+	return readerToPB(row.(*Reader)), nil
+}
+
+func (readerServer) DeleteReader(ctx context.Context, req *librarypb.DeleteReaderRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, readerResource.delete(req.ReaderId, req.Version)
+}
+
+type bookServer struct {
+	librarypb.UnimplementedBookServiceServer
+}
+
+func bookToPB(b *Book) *librarypb.Book {
+	return &librarypb.Book{
+		BookId:        b.BookId,
+		Name:          b.Name,
+		AuthorId:      b.AuthorId,
+		GenreId:       b.GenreId,
+		CurrentReader: b.CurrentReader,
+		ReleaseDate:   timestampOf(b.ReleaseDate),
+		Language:      b.Language,
+		PageCount:     int32(b.PageCount),
+		Isbn:          b.Isbn,
+		Version:       b.Version,
+	}
+}
+
+func bookFromPB(b *librarypb.Book) *Book {
+	return &Book{
+		BookId:      b.GetBookId(),
+		Name:        b.GetName(),
+		AuthorId:    b.GetAuthorId(),
+		GenreId:     b.GetGenreId(),
+		ReleaseDate: timeOf(b.GetReleaseDate()),
+		Language:    b.GetLanguage(),
+		PageCount:   int(b.GetPageCount()),
+		Isbn:        b.GetIsbn(),
+		Version:     b.GetVersion(),
+	}
+}
+
+func (bookServer) ListBooks(ctx context.Context, req *librarypb.ListBooksRequest) (*librarypb.ListBooksResponse, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	books, err := searchBooks(searchParams{
+		Offset:  int(req.Offset),
+		Status:  req.Status,
+		OrderBy: req.Order,
+		Author:  req.Author,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &librarypb.ListBooksResponse{}
+	for _, b := range books {
+		resp.Books = append(resp.Books, &librarypb.BookSummary{
+			BookId:        b.BookId,
+			Book:          b.Book,
+			Author:        b.Author,
+			ReleaseDate:   timestampOf(b.ReleaseDate),
+			Genre:         b.Genre,
+			CurrentReader: b.CurrentReader,
+		})
+	}
+	return resp, nil
+}
+
+func (bookServer) GetBook(ctx context.Context, req *librarypb.GetBookRequest) (*librarypb.Book, error) {
+	row, err := bookResource.find(req.BookId)
+	if err != nil {
+		return nil, err
+	}
+	return bookToPB(row.(*Book)), nil
+}
+
+func (bookServer) UpdateBook(ctx context.Context, req *librarypb.UpdateBookRequest) (*librarypb.Book, error) {
+	book := bookFromPB(req.Book)
+	row, err := updateRow(bookResource, book.BookId, book, req.UpdateMask)
+	if err != nil {
+		return nil, err
+	}
+	return bookToPB(row.(*Book)), nil
+}
+
+func (bookServer) DeleteBook(ctx context.Context, req *librarypb.DeleteBookRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, bookResource.delete(req.BookId, req.Version)
+}
+
+type rentalServer struct {
+	librarypb.UnimplementedRentalServiceServer
+}
+
+func bookTokenToPB(t *bookTokens) *librarypb.BookToken {
+	return &librarypb.BookToken{
+		Token:     t.Token,
+		BookId:    t.BookId,
+		ReaderId:  t.ReaderId,
+		ExpiresAt: timestampOf(t.ExpiresAt),
+		RevokedAt: timestampOf(t.RevokedAt),
+	}
+}
+
+func rentalToPB(r *RentalHistory) *librarypb.Rental {
+	return &librarypb.Rental{
+		RentalId:   r.RentalId,
+		BookId:     r.BookId,
+		ReaderId:   r.ReaderId,
+		RentalDate: timestampOf(r.RentalDate),
+		ReturnDate: timestampOf(r.ReturnDate),
+	}
+}
+
+func (rentalServer) TakeBook(ctx context.Context, req *librarypb.TakeBookRequest) (*librarypb.BookToken, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	token := &bookTokens{BookId: req.BookId, ReaderId: req.ReaderId}
+	if err := validate(token, "BookId", "ReaderId"); err != nil {
+		return nil, err
+	}
+	if err := issueBookToken(token); err != nil {
+		return nil, err
+	}
+	return bookTokenToPB(token), nil
+}
+
+func (rentalServer) RevokeBookToken(ctx context.Context, req *librarypb.RevokeBookTokenRequest) (*librarypb.BookToken, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	token := &bookTokens{Token: req.Token}
+	if err := validate(token, "Token"); err != nil {
+		return nil, err
+	}
+	if err := revokeToken(token); err != nil {
+		return nil, err
+	}
+	return bookTokenToPB(token), nil
+}
+
+func (rentalServer) ReturnBook(ctx context.Context, req *librarypb.ReturnBookRequest) (*librarypb.Rental, error) {
+	rental := &RentalHistory{BookId: req.BookId}
+	if err := validate(rental, "BookId"); err != nil {
+		return nil, err
+	}
+	if err := returnRental(rental); err != nil {
+		return nil, err
+	}
+	return rentalToPB(rental), nil
+}
+
+func (rentalServer) ListRentals(ctx context.Context, req *librarypb.ListRentalsRequest) (*librarypb.ListRentalsResponse, error) {
+	if err := requireLibrarian(ctx); err != nil {
+		return nil, err
+	}
+	interval := &TimeIntervalsForHistory{
+		RentalDateFrom: timeOf(req.RentalDateFrom),
+		RentalDateTo:   timeOf(req.RentalDateTo),
+		ReturnDateFrom: timeOf(req.ReturnDateFrom),
+		ReturnDateTo:   timeOf(req.ReturnDateTo),
+	}
+	if err := validate(interval); err != nil {
+		return nil, err
+	}
+	rentals, err := rentalsBetween(interval)
+	if err != nil {
+		return nil, err
+	}
+	resp := &librarypb.ListRentalsResponse{}
+	for i := range rentals {
+		resp.Rentals = append(resp.Rentals, rentalToPB(&rentals[i]))
+	}
+	return resp, nil
+}
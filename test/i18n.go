@@ -0,0 +1,472 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+// Message keys. Handlers never put user-facing text in responses directly,
+// they pass one of these to tr or newAPIError and the text is looked up in
+// the catalog of the language negotiated for the request.
+const (
+	msgUnauthorized         = "error.unauthorized"
+	msgForbidden            = "error.forbidden"
+	msgNotFound             = "error.not_found"
+	msgMalformedRequest     = "error.malformed_request"
+	msgEmptyBody            = "error.empty_body"
+	msgBodyTooLarge         = "error.body_too_large"
+	msgValidationFailed     = "error.validation_failed"
+	msgInternal             = "error.internal"
+	msgAlreadyExists        = "error.already_exists"
+	msgStillInUse           = "error.still_in_use"
+	msgMissingReference     = "error.missing_reference"
+	msgInvalidValue         = "error.invalid_value"
+	msgNotAcceptable        = "error.not_acceptable"
+	msgFileTooLarge         = "error.file_too_large"
+	msgUnsupportedBook      = "error.unsupported_book_format"
+	msgUnsupportedImage     = "error.unsupported_content_type"
+	msgInvalidBookFile      = "error.invalid_book_file"
+	msgInvalidCoverFile     = "error.invalid_cover_file"
+	msgBookTokenInvalid     = "error.book_token_invalid"
+	msgBookTokenNotFound    = "error.book_token_not_found"
+	msgBadWatermark         = "error.bad_watermark"
+	msgInvalidAccessToken   = "error.invalid_access_token"
+	msgRefreshTokenNotValid = "error.refresh_token_not_access"
+	msgBadCredentials       = "error.bad_credentials"
+	msgUserInUse            = "error.user_in_use"
+	msgBookInUse            = "error.book_in_use"
+	msgGenreInUse           = "error.genre_in_use"
+	msgInvalidId            = "error.invalid_id"
+	msgReadOnlyFields       = "error.read_only_fields"
+	msgVersionChanged       = "error.version_changed"
+	msgIfMatchRequired      = "error.if_match_required"
+
+	msgFieldRequired  = "validation.required"
+	msgFieldPast      = "validation.past"
+	msgFieldISBN      = "validation.isbn"
+	msgFieldRole      = "validation.role"
+	msgFieldEmail     = "validation.email"
+	msgFieldMin       = "validation.min"
+	msgFieldMax       = "validation.max"
+	msgFieldMinLength = "validation.min_length"
+	msgFieldMaxLength = "validation.max_length"
+	msgFieldNotBefore = "validation.not_before"
+	msgFieldUnique    = "validation.unique"
+	msgFieldExists    = "validation.exists"
+
+	msgBookTokenIssued = "book_token.issued"
+	msgSessionDeleted  = "session.deleted"
+	msgFileUploaded    = "file.uploaded"
+	msgRoleChanged     = "role.changed"
+	msgRoleDeleted     = "role.deleted"
+	msgRoleCreated     = "role.created"
+	msgPasswordChanged = "user.password_changed"
+	msgUserUpdated     = "user.updated"
+	msgUserDeleted     = "user.deleted"
+	msgUserCreated     = "user.created"
+	msgBookCreated     = "book.created"
+	msgBookUpdated     = "book.updated"
+	msgBookDeleted     = "book.deleted"
+	msgReaderCreated   = "reader.created"
+	msgReaderUpdated   = "reader.updated"
+	msgReaderDeleted   = "reader.deleted"
+	msgGenreCreated    = "genre.created"
+	msgGenreUpdated    = "genre.updated"
+	msgGenreDeleted    = "genre.deleted"
+	msgAuthorCreated   = "author.created"
+	msgAuthorUpdated   = "author.updated"
+	msgAuthorDeleted   = "author.deleted"
+
+	msgOPDSCatalog    = "opds.catalog"
+	msgOPDSNewBooks   = "opds.new_books"
+	msgOPDSAuthors    = "opds.authors"
+	msgOPDSGenres     = "opds.genres"
+	msgOPDSSearch     = "opds.search"
+	msgOPDSSearchHint = "opds.search_hint"
+	msgOPDSBookCount  = "opds.book_count"
+
+	msgOAIBadVerb            = "oai.bad_verb"
+	msgOAIIllegalArgument    = "oai.illegal_argument"
+	msgOAIRepeatedArgument   = "oai.repeated_argument"
+	msgOAIMissingArgument    = "oai.missing_argument"
+	msgOAIExclusiveArgument  = "oai.exclusive_argument"
+	msgOAIBadDate            = "oai.bad_date"
+	msgOAIGranularity        = "oai.granularity"
+	msgOAIDateRange          = "oai.date_range"
+	msgOAIBadResumptionToken = "oai.bad_resumption_token"
+	msgOAICannotDisseminate  = "oai.cannot_disseminate"
+	msgOAIIdDoesNotExist     = "oai.id_does_not_exist"
+	msgOAINoRecords          = "oai.no_records"
+	msgOAINoSets             = "oai.no_sets"
+
+	msgMARCImported        = "marc.imported"
+	msgInvalidMARCRecord   = "error.invalid_marc_record"
+	msgUnsupportedMARCType = "error.unsupported_marc_type"
+	msgExportNotAcceptable = "error.export_not_acceptable"
+
+	msgHoldPlaced  = "hold.placed"
+	msgHoldOwnLoan = "error.hold_own_loan"
+
+	msgNoReader       = "error.no_reader"
+	msgReaderLinked   = "user.reader_linked"
+	msgReaderUnlinked = "user.reader_unlinked"
+
+	msgSignupVerify      = "signup.verify"
+	msgVerifySubject     = "signup.verify_subject"
+	msgVerifyBody        = "signup.verify_body"
+	msgVerifyResent      = "signup.verify_resent"
+	msgEmailVerified     = "signup.email_verified"
+	msgVerifyLinkInvalid = "error.verify_link_invalid"
+	msgAccountInactive   = "error.account_inactive"
+	msgMailFailed        = "error.mail_failed"
+
+	msgResetSent        = "password.reset_sent"
+	msgResetSubject     = "password.reset_subject"
+	msgResetBody        = "password.reset_body"
+	msgResetLinkInvalid = "error.reset_link_invalid"
+	msgWrongPassword    = "error.wrong_password"
+)
+
+// Translations are either plain strings or catalog messages, the latter for
+// texts that have to agree with a number.
+var messagesRu = map[string]interface{}{
+	msgUnauthorized:     "Требуется авторизация",
+	msgForbidden:        "Недостаточно прав",
+	msgNotFound:         "Ресурс не найден",
+	msgMalformedRequest: "Некорректный запрос",
+	msgEmptyBody:        "Пустое тело запроса",
+	msgBodyTooLarge:     "Слишком большой запрос",
+	msgValidationFailed: "Ошибка проверки данных запроса",
+	msgInternal:         "Внутренняя ошибка сервера",
+	msgAlreadyExists:    "Такая запись уже существует",
+	msgStillInUse:       "Запись используется и не может быть удалена",
+	msgMissingReference: "Связанная запись не существует",
+	msgInvalidValue:     "Недопустимое значение",
+	msgNotAcceptable:    "Книга недоступна в запрошенном формате",
+	msgFileTooLarge: plural.Selectf(2, "%d",
+		"one", "Файл %[1]s превышает лимит в %[2]d байт",
+		"few", "Файл %[1]s превышает лимит в %[2]d байта",
+		"other", "Файл %[1]s превышает лимит в %[2]d байт"),
+	msgUnsupportedBook:      "%s: неподдерживаемый формат книги",
+	msgUnsupportedImage:     "%s: неподдерживаемый тип файла %s",
+	msgInvalidBookFile:      "Неверный формат файла книги",
+	msgInvalidCoverFile:     "Неверный формат файла обложки книги",
+	msgBookTokenInvalid:     "Токен недействителен или время токена истекло",
+	msgBookTokenNotFound:    "Токен не найден или уже отозван",
+	msgBadWatermark:         "Водяной знак отсутствует или подделан",
+	msgInvalidAccessToken:   "Недействительный токен доступа",
+	msgRefreshTokenNotValid: "Рефреш токен не авторизирует",
+	msgBadCredentials:       "Неправильный логин или пароль",
+	msgUserInUse:            "Невозможно удалить данного пользователя",
+	msgBookInUse:            "Такой книги не существует/Нельзя удалить книгу с действующим читателем",
+	msgGenreInUse:           "Такого жанра не существует/Нельзя удалить жанр с существующими книгами",
+	msgInvalidId:            "Некорректный идентификатор",
+	msgReadOnlyFields:       "Эти поля нельзя изменить",
+	msgVersionChanged:       "Запись уже изменил кто-то другой, загрузите её заново",
+	msgIfMatchRequired:      "Укажите в заголовке If-Match полученный ETag",
+
+	msgFieldRequired: "Обязательное поле",
+	msgFieldPast:     "Дата должна быть в прошлом",
+	msgFieldISBN:     "Неверный ISBN",
+	msgFieldRole:     "Название роли: от 3 до 20 строчных латинских букв или _",
+	msgFieldEmail:    "Неверный адрес электронной почты",
+	msgFieldMin:      "Значение должно быть не меньше %s",
+	msgFieldMax:      "Значение должно быть не больше %s",
+	msgFieldMinLength: plural.Selectf(1, "%d",
+		"one", "Не короче %d символа",
+		"other", "Не короче %d символов"),
+	msgFieldMaxLength: plural.Selectf(1, "%d",
+		"one", "Не длиннее %d символа",
+		"other", "Не длиннее %d символов"),
+	msgFieldNotBefore: "Не раньше, чем %s",
+	msgFieldUnique:    "Это значение уже занято",
+	msgFieldExists:    "Запись с таким идентификатором не найдена",
+
+	msgBookTokenIssued: plural.Selectf(1, "%d",
+		"one", "Токен для загрузки действителен %d минуту",
+		"few", "Токен для загрузки действителен %d минуты",
+		"other", "Токен для загрузки действителен %d минут"),
+	msgSessionDeleted:  "Сессия удалена",
+	msgFileUploaded:    "Файл успешно загружен",
+	msgRoleChanged:     "Роль изменена",
+	msgRoleDeleted:     "Роль удалена",
+	msgRoleCreated:     "Роль успешно добавлена",
+	msgPasswordChanged: "Пароль изменен",
+	msgUserUpdated:     "Пользователь изменен",
+	msgUserDeleted:     "Пользователь удален",
+	msgUserCreated:     "Пользователь успешно добавлен",
+	msgBookCreated:     "Книга добавлена успешно",
+	msgBookUpdated:     "Книга изменена успешно",
+	msgBookDeleted:     "Книга удалена успешно",
+	msgReaderCreated:   "Читатель добавлен успешно",
+	msgReaderUpdated:   "Читатель изменен успешно",
+	msgReaderDeleted:   "Читатель удален успешно",
+	msgGenreCreated:    "Жанр добавлен успешно",
+	msgGenreUpdated:    "Жанр изменен успешно",
+	msgGenreDeleted:    "Жанр удален успешно",
+	msgAuthorCreated:   "Автор добавлен успешно",
+	msgAuthorUpdated:   "Автор изменен успешно",
+	msgAuthorDeleted:   "Автор удален успешно",
+
+	msgOPDSCatalog:    "Библиотека",
+	msgOPDSNewBooks:   "Новые книги",
+	msgOPDSAuthors:    "Авторы",
+	msgOPDSGenres:     "Жанры",
+	msgOPDSSearch:     "Поиск: %s",
+	msgOPDSSearchHint: "Поиск книг по названию или автору",
+	msgOPDSBookCount: plural.Selectf(1, "%d",
+		"one", "%d книга",
+		"few", "%d книги",
+		"other", "%d книг"),
+
+	msgOAIBadVerb:            "Неизвестный или отсутствующий verb",
+	msgOAIIllegalArgument:    "Недопустимый аргумент %s",
+	msgOAIRepeatedArgument:   "Аргумент %s повторяется",
+	msgOAIMissingArgument:    "Не указан аргумент %s",
+	msgOAIExclusiveArgument:  "%s нельзя сочетать с другими аргументами",
+	msgOAIBadDate:            "Неверная дата %s",
+	msgOAIGranularity:        "from и until должны быть в одном формате",
+	msgOAIDateRange:          "from позже until",
+	msgOAIBadResumptionToken: "Недействительный resumptionToken",
+	msgOAICannotDisseminate:  "Формат метаданных %s не поддерживается",
+	msgOAIIdDoesNotExist:     "Запись %s не найдена",
+	msgOAINoRecords:          "Нет записей, подходящих под запрос",
+	msgOAINoSets:             "В каталоге нет жанров",
+
+	msgMARCImported:        "Импортировано записей: %d из %d",
+	msgInvalidMARCRecord:   "Неверная запись MARC",
+	msgUnsupportedMARCType: "%s: ожидается application/marc или application/marcxml+xml",
+	msgExportNotAcceptable: "Выгрузка возможна в CSV, XLSX или JSON Lines",
+
+	msgHoldPlaced:  "Книга зарезервирована",
+	msgHoldOwnLoan: "Эта книга уже у вас",
+
+	msgNoReader:       "Учетная запись не связана с читателем",
+	msgReaderLinked:   "Читатель связан с пользователем",
+	msgReaderUnlinked: "Читатель отвязан от пользователя",
+
+	msgSignupVerify:      "Мы отправили ссылку для подтверждения на вашу почту",
+	msgVerifySubject:     "Подтвердите адрес электронной почты",
+	msgVerifyBody:        "Здравствуйте, %s!\n\nЧтобы подтвердить адрес и войти в библиотеку, откройте ссылку:\n%s\n\nСсылка действует до %s.\n",
+	msgVerifyResent:      "Если адрес принадлежит неподтвержденной учетной записи, мы отправили новую ссылку",
+	msgEmailVerified:     "Адрес подтвержден, теперь можно войти",
+	msgVerifyLinkInvalid: "Ссылка недействительна или устарела",
+	msgAccountInactive:   "Учетная запись не подтверждена, откройте ссылку из письма",
+	msgMailFailed:        "Не удалось отправить письмо, попробуйте позже",
+
+	msgResetSent:        "Если адрес принадлежит учетной записи, мы отправили ссылку для смены пароля",
+	msgResetSubject:     "Смена пароля",
+	msgResetBody:        "Здравствуйте, %s!\n\nЧтобы задать новый пароль, откройте ссылку:\n%s\n\nСсылка одноразовая и действует до %s. Если вы не просили сменить пароль, просто проигнорируйте это письмо.\n",
+	msgResetLinkInvalid: "Ссылка для смены пароля недействительна, устарела или уже использована",
+	msgWrongPassword:    "Неверный текущий пароль",
+}
+
+var messagesEn = map[string]interface{}{
+	msgUnauthorized:     "Authorization required",
+	msgForbidden:        "Insufficient permissions",
+	msgNotFound:         "Resource not found",
+	msgMalformedRequest: "Malformed request",
+	msgEmptyBody:        "Request body is empty",
+	msgBodyTooLarge:     "Request body is too large",
+	msgValidationFailed: "Request validation failed",
+	msgInternal:         "Internal server error",
+	msgAlreadyExists:    "Resource already exists",
+	msgStillInUse:       "Resource is still in use",
+	msgMissingReference: "Referenced resource does not exist",
+	msgInvalidValue:     "Invalid value",
+	msgNotAcceptable:    "Book is not available in the requested format",
+	msgFileTooLarge: plural.Selectf(2, "%d",
+		"one", "%[1]s is larger than %[2]d byte",
+		"other", "%[1]s is larger than %[2]d bytes"),
+	msgUnsupportedBook:      "%s: unsupported book format",
+	msgUnsupportedImage:     "%s: unsupported content type %s",
+	msgInvalidBookFile:      "Invalid book file",
+	msgInvalidCoverFile:     "Invalid cover image",
+	msgBookTokenInvalid:     "Token is invalid or has expired",
+	msgBookTokenNotFound:    "Token not found or already revoked",
+	msgBadWatermark:         "Watermark is missing or forged",
+	msgInvalidAccessToken:   "Invalid access token",
+	msgRefreshTokenNotValid: "A refresh token can't be used for authorization",
+	msgBadCredentials:       "Wrong login or password",
+	msgUserInUse:            "This user can't be deleted",
+	msgBookInUse:            "The book doesn't exist or is currently rented out",
+	msgGenreInUse:           "The genre doesn't exist or still has books",
+	msgInvalidId:            "Invalid id",
+	msgReadOnlyFields:       "These fields can't be changed",
+	msgVersionChanged:       "Someone else changed this record, reload it",
+	msgIfMatchRequired:      "Send the ETag you got in the If-Match header",
+
+	msgFieldRequired: "This field is required",
+	msgFieldPast:     "Must be a date in the past",
+	msgFieldISBN:     "Invalid ISBN",
+	msgFieldRole:     "Role names are 3 to 20 lower case letters or _",
+	msgFieldEmail:    "Invalid email address",
+	msgFieldMin:      "Must be at least %s",
+	msgFieldMax:      "Must be at most %s",
+	msgFieldMinLength: plural.Selectf(1, "%d",
+		"one", "Must be at least %d character long",
+		"other", "Must be at least %d characters long"),
+	msgFieldMaxLength: plural.Selectf(1, "%d",
+		"one", "Must be at most %d character long",
+		"other", "Must be at most %d characters long"),
+	msgFieldNotBefore: "Must not be before %s",
+	msgFieldUnique:    "This value is already taken",
+	msgFieldExists:    "No record has this id",
+
+	msgBookTokenIssued: plural.Selectf(1, "%d",
+		"one", "Download token is valid for %d minute",
+		"other", "Download token is valid for %d minutes"),
+	msgSessionDeleted:  "Session deleted",
+	msgFileUploaded:    "Your file has been successfully uploaded",
+	msgRoleChanged:     "Role changed",
+	msgRoleDeleted:     "Role deleted",
+	msgRoleCreated:     "Role added",
+	msgPasswordChanged: "Password changed",
+	msgUserUpdated:     "User updated",
+	msgUserDeleted:     "User deleted",
+	msgUserCreated:     "User added",
+	msgBookCreated:     "Book added",
+	msgBookUpdated:     "Book updated",
+	msgBookDeleted:     "Book deleted",
+	msgReaderCreated:   "Reader added",
+	msgReaderUpdated:   "Reader updated",
+	msgReaderDeleted:   "Reader deleted",
+	msgGenreCreated:    "Genre added",
+	msgGenreUpdated:    "Genre updated",
+	msgGenreDeleted:    "Genre deleted",
+	msgAuthorCreated:   "Author added",
+	msgAuthorUpdated:   "Author updated",
+	msgAuthorDeleted:   "Author deleted",
+
+	msgOPDSCatalog:    "Library",
+	msgOPDSNewBooks:   "New books",
+	msgOPDSAuthors:    "Authors",
+	msgOPDSGenres:     "Genres",
+	msgOPDSSearch:     "Search: %s",
+	msgOPDSSearchHint: "Search books by title or author",
+	msgOPDSBookCount: plural.Selectf(1, "%d",
+		"one", "%d book",
+		"other", "%d books"),
+
+	msgOAIBadVerb:            "Illegal or missing verb",
+	msgOAIIllegalArgument:    "Illegal argument %s",
+	msgOAIRepeatedArgument:   "Argument %s is repeated",
+	msgOAIMissingArgument:    "Missing argument %s",
+	msgOAIExclusiveArgument:  "%s can't be combined with other arguments",
+	msgOAIBadDate:            "Invalid date %s",
+	msgOAIGranularity:        "from and until must have the same granularity",
+	msgOAIDateRange:          "from is later than until",
+	msgOAIBadResumptionToken: "Invalid resumptionToken",
+	msgOAICannotDisseminate:  "Metadata format %s is not supported",
+	msgOAIIdDoesNotExist:     "No record %s",
+	msgOAINoRecords:          "No records match the request",
+	msgOAINoSets:             "The catalogue has no genres",
+
+	msgMARCImported:        "Imported records: %d of %d",
+	msgInvalidMARCRecord:   "Invalid MARC record",
+	msgUnsupportedMARCType: "%s: expected application/marc or application/marcxml+xml",
+	msgExportNotAcceptable: "Exports are available as CSV, XLSX or JSON Lines",
+
+	msgHoldPlaced:  "The book is on hold for you",
+	msgHoldOwnLoan: "You already have this book",
+
+	msgNoReader:       "The account is not linked to a reader",
+	msgReaderLinked:   "Reader linked to the user",
+	msgReaderUnlinked: "Reader unlinked from the user",
+
+	msgSignupVerify:      "We sent a verification link to your email",
+	msgVerifySubject:     "Confirm your email address",
+	msgVerifyBody:        "Hello %s,\n\nopen this link to confirm your address and log in to the library:\n%s\n\nThe link works until %s.\n",
+	msgVerifyResent:      "If the address belongs to an unverified account, we sent a new link",
+	msgEmailVerified:     "Email confirmed, you can log in now",
+	msgVerifyLinkInvalid: "The link is invalid or has expired",
+	msgAccountInactive:   "The account is not verified yet, open the link from the email",
+	msgMailFailed:        "Could not send the email, try again later",
+
+	msgResetSent:        "If the address belongs to an account, we sent a link to reset the password",
+	msgResetSubject:     "Reset your password",
+	msgResetBody:        "Hello %s,\n\nopen this link to set a new password:\n%s\n\nThe link works once, until %s. If you did not ask to reset the password, ignore this email.\n",
+	msgResetLinkInvalid: "The reset link is invalid, has expired or was already used",
+	msgWrongPassword:    "The current password is wrong",
+}
+
+// supportedLanguages are offered to Accept-Language negotiation, the first
+// one is used when nothing matches.
+var supportedLanguages = []language.Tag{language.Russian, language.English}
+
+var (
+	messageCatalog  = buildCatalog()
+	languageMatcher = language.NewMatcher(supportedLanguages)
+)
+
+func buildCatalog() catalog.Catalog {
+	b := catalog.NewBuilder(catalog.Fallback(supportedLanguages[0]))
+	for tag, messages := range map[language.Tag]map[string]interface{}{
+		language.Russian: messagesRu,
+		language.English: messagesEn,
+	} {
+		for key, m := range messages {
+			var err error
+			switch m := m.(type) {
+			case string:
+				err = b.SetString(tag, key, m)
+			case catalog.Message:
+				err = b.Set(tag, key, m)
+			default:
+				err = fmt.Errorf("unsupported message type %T", m)
+			}
+			if err != nil {
+				panic(fmt.Sprintf("i18n: %s %s: %v", tag, key, err))
+			}
+		}
+	}
+	for key := range messagesRu {
+		if _, ok := messagesEn[key]; !ok {
+			panic("i18n: no English translation for " + key)
+		}
+	}
+	for key := range messagesEn {
+		if _, ok := messagesRu[key]; !ok {
+			panic("i18n: no Russian translation for " + key)
+		}
+	}
+	return b
+}
+
+// localize picks the response language from Accept-Language.
+func localize(c *gin.Context) {
+	lang := matchLanguage(c.GetHeader("Accept-Language"))
+	c.Set("lang", lang)
+	c.Header("Content-Language", lang.String())
+	c.Writer.Header().Add("Vary", "Accept-Language")
+	c.Next()
+}
+
+// matchLanguage picks the supported language closest to an
+// Accept-Language value.
+func matchLanguage(acceptLanguage string) language.Tag {
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, i, _ := languageMatcher.Match(tags...)
+	return supportedLanguages[i]
+}
+
+func requestLanguage(c *gin.Context) language.Tag {
+	if lang, ok := c.Keys["lang"].(language.Tag); ok {
+		return lang
+	}
+	return supportedLanguages[0]
+}
+
+func printer(lang language.Tag) *message.Printer {
+	return message.NewPrinter(lang, message.Catalog(messageCatalog))
+}
+
+// tr renders the message key in the language of the request.
+func tr(c *gin.Context, key string, args ...interface{}) string {
+	return printer(requestLanguage(c)).Sprintf(key, args...)
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg"
+	"golang.org/x/text/language"
+)
+
+// `import` loads authors, genres, readers and books from CSV or JSON Lines
+// files. Columns are named after the fields of the JSON API, rows are
+// matched with the catalogue on their unique name and created or updated.
+// Everything runs in one transaction, -dry-run reports the changes and
+// rolls it back.
+
+// importColumn maps a field of the row to the column it is written to.
+type importColumn struct {
+	field, column string
+	// value is the expression written, the named parameter by default
+	value string
+}
+
+func (col importColumn) expr() string {
+	if col.value != "" {
+		return col.value
+	}
+	return "?" + col.column
+}
+
+type importEntity struct {
+	name  string
+	table string
+	// columns can appear in the file, the first one is the natural key
+	columns  []importColumn
+	required []string
+	model    func() interface{}
+	// current selects the row with the key, in the form of model
+	current string
+	// resolve turns names into the ids written
+	resolve func(tx *pg.Tx, row interface{}) error
+}
+
+// importEntities are imported in this order, so books can refer to the
+// authors and genres of the same run.
+var importEntities = []importEntity{
+	{
+		name:     "authors",
+		table:    "author",
+		columns:  []importColumn{{field: "AuthorName", column: "author_name"}},
+		required: []string{"AuthorName"},
+		model:    func() interface{} { return new(Author) },
+		current:  `SELECT * FROM author WHERE author_name = ? FOR UPDATE`,
+	},
+	{
+		name:     "genres",
+		table:    "genre",
+		columns:  []importColumn{{field: "Genre", column: "genre"}},
+		required: []string{"Genre"},
+		model:    func() interface{} { return new(Genre) },
+		current:  `SELECT * FROM genre WHERE genre = ? FOR UPDATE`,
+	},
+	{
+		name:  "readers",
+		table: "reader",
+		columns: []importColumn{
+			{field: "Name", column: "name"},
+			{field: "BirthDate", column: "birth_date"},
+		},
+		required: []string{"Name", "BirthDate"},
+		model:    func() interface{} { return new(Reader) },
+		current:  `SELECT * FROM reader WHERE name = ? FOR UPDATE`,
+	},
+	{
+		name:  "books",
+		table: "book",
+		columns: []importColumn{
+			{field: "Name", column: "name"},
+			{field: "Author", column: "author_id"},
+			{field: "Genre", column: "genre_id"},
+			{field: "ReleaseDate", column: "release_date"},
+			{field: "Language", column: "language", value: "NULLIF(?language, '')"},
+			{field: "PageCount", column: "page_count", value: "NULLIF(?page_count, 0)"},
+			{field: "Isbn", column: "isbn", value: "NULLIF(?isbn, '')"},
+		},
+		required: []string{"Name", "Author", "ReleaseDate"},
+		model:    func() interface{} { return new(namedBook) },
+		current: `SELECT b.*, a.author_name AS author, g.genre AS genre FROM book b
+JOIN author a ON a.author_id = b.author_id LEFT JOIN genre g ON g.genre_id = b.genre_id
+WHERE b.name = ? FOR UPDATE OF b`,
+		resolve: resolveBookNames,
+	},
+}
+
+func resolveBookNames(tx *pg.Tx, row interface{}) error {
+	b := row.(*namedBook)
+	_, err := tx.QueryOne(&b.AuthorId, `SELECT author_id FROM author WHERE author_name = ?`, b.Author)
+	if err == pg.ErrNoRows {
+		return fmt.Errorf("no author %q", b.Author)
+	}
+	if err != nil || b.Genre == "" {
+		return err
+	}
+	_, err = tx.QueryOne(&b.GenreId, `SELECT genre_id FROM genre WHERE genre = ?`, b.Genre)
+	if err == pg.ErrNoRows {
+		return fmt.Errorf("no genre %q", b.Genre)
+	}
+	return err
+}
+
+// importRow is a row of a file, numbered from 1, with the fields it sets.
+type importRow struct {
+	line   int
+	fields []string
+	value  interface{}
+}
+
+type importFile struct {
+	entity importEntity
+	path   string
+	rows   []importRow
+}
+
+var errDryRun = errors.New("dry run")
+
+// runImport is the import command, it returns the exit code.
+func runImport(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dryRun := flags.Bool("dry-run", false, "report the changes without writing them")
+	paths := map[string]*string{}
+	for _, e := range importEntities {
+		paths[e.name] = flags.String(e.name, "", e.name+" as .csv or .jsonl")
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s import [-dry-run] [-authors file] [-genres file] [-readers file] [-books file]\n", filepath.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var files []importFile
+	failed := false
+	for _, e := range importEntities {
+		path := *paths[e.name]
+		if path == "" {
+			continue
+		}
+		rows, errs := readImportFile(e, path)
+		for _, err := range errs {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+		}
+		failed = failed || len(errs) > 0
+		files = append(files, importFile{e, path, rows})
+	}
+	if len(files) == 0 {
+		flags.Usage()
+		return 2
+	}
+	if failed {
+		return 1
+	}
+
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		for _, f := range files {
+			var created, updated, unchanged int
+			for _, row := range f.rows {
+				diff, isNew, err := f.entity.apply(tx, row)
+				if err != nil {
+					return fmt.Errorf("%s: row %d: %v", f.path, row.line, err)
+				}
+				key := reflect.Indirect(reflect.ValueOf(row.value)).FieldByName(f.entity.columns[0].field)
+				switch {
+				case isNew:
+					created++
+					fmt.Fprintf(stdout, "+ %s %q\n", f.entity.name, key)
+				case len(diff) > 0:
+					updated++
+					fmt.Fprintf(stdout, "~ %s %q: %s\n", f.entity.name, key, strings.Join(diff, ", "))
+				default:
+					unchanged++
+				}
+			}
+			fmt.Fprintf(stdout, "%s: %d created, %d updated, %d unchanged\n", f.entity.name, created, updated, unchanged)
+		}
+		if *dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	switch {
+	case err == errDryRun:
+		fmt.Fprintln(stdout, "dry run, nothing was written")
+	case err != nil:
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// apply creates the row or updates the fields of the file that differ,
+// returning the differences as `Field "old" → "new"`.
+func (e importEntity) apply(tx *pg.Tx, row importRow) ([]string, bool, error) {
+	if e.resolve != nil {
+		if err := e.resolve(tx, row.value); err != nil {
+			return nil, false, err
+		}
+	}
+	key := e.columns[0]
+	newValue := reflect.Indirect(reflect.ValueOf(row.value))
+	current := e.model()
+	_, err := tx.QueryOne(current, e.current, newValue.FieldByName(key.field).Interface())
+	if err == pg.ErrNoRows {
+		var columns, values []string
+		for _, col := range e.present(row) {
+			columns = append(columns, col.column)
+			values = append(values, col.expr())
+		}
+		_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`,
+			e.table, strings.Join(columns, ","), strings.Join(values, ",")), row.value)
+		return nil, true, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	oldValue := reflect.Indirect(reflect.ValueOf(current))
+	var diff, set []string
+	for _, col := range e.present(row)[1:] {
+		before := importText(oldValue.FieldByName(col.field))
+		after := importText(newValue.FieldByName(col.field))
+		if before != after {
+			diff = append(diff, fmt.Sprintf("%s %q → %q", col.field, before, after))
+			set = append(set, col.column+" = "+col.expr())
+		}
+	}
+	if len(set) == 0 {
+		return nil, false, nil
+	}
+	_, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET %s, version = version + 1 WHERE %s = %s`,
+		e.table, strings.Join(set, ", "), key.column, key.expr()), row.value)
+	return diff, false, err
+}
+
+// present returns the columns the row sets, the key first.
+func (e importEntity) present(row importRow) []importColumn {
+	var cols []importColumn
+	for _, col := range e.columns {
+		for _, field := range row.fields {
+			if field == col.field {
+				cols = append(cols, col)
+				break
+			}
+		}
+	}
+	return cols
+}
+
+func importText(v reflect.Value) string {
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	}
+	if v.IsZero() {
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// readImportFile reads and validates the rows of a file, reporting every
+// broken row.
+func readImportFile(e importEntity, path string) ([]importRow, []error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer f.Close()
+	var records []map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		records, err = readCSVRecords(f)
+	case ".jsonl", ".ndjson":
+		records, err = readJSONLRecords(f)
+	default:
+		err = errors.New("expected a .csv or .jsonl file")
+	}
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	p := printer(language.English)
+	var rows []importRow
+	var errs []error
+	for i, record := range records {
+		row, err := e.parse(record)
+		if err == nil {
+			err = validate(row.value, e.required...)
+		}
+		if err == nil {
+			row.line = i + 1
+			rows = append(rows, row)
+			continue
+		}
+		if fields, ok := toAPIError(err).Details.([]fieldError); ok {
+			var messages []string
+			for _, f := range localizeFieldErrors(p, fields) {
+				messages = append(messages, f.Field+": "+f.Message)
+			}
+			err = errors.New(strings.Join(messages, "; "))
+		}
+		errs = append(errs, fmt.Errorf("row %d: %v", i+1, err))
+	}
+	return rows, errs
+}
+
+// parse sets the fields of a new row from the record.
+func (e importEntity) parse(record map[string]string) (importRow, error) {
+	row := importRow{value: e.model()}
+	v := reflect.ValueOf(row.value).Elem()
+	for _, col := range e.columns {
+		value, ok := record[col.field]
+		if !ok {
+			continue
+		}
+		delete(record, col.field)
+		row.fields = append(row.fields, col.field)
+		if err := setImportField(v.FieldByName(col.field), value); err != nil {
+			return row, fmt.Errorf("%s: %v", col.field, err)
+		}
+	}
+	for field := range record {
+		return row, fmt.Errorf("unknown column %s", field)
+	}
+	return row, nil
+}
+
+// setImportField parses the text of a field, dates as YYYY-MM-DD or
+// RFC 3339.
+func setImportField(f reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if _, ok := f.Interface().(time.Time); ok {
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			t, err = time.Parse(time.RFC3339, value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid date %q", value)
+		}
+		f.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		f.SetInt(n)
+	}
+	return nil
+}
+
+// readCSVRecords reads a file with a header line.
+func readCSVRecords(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("no header line")
+	}
+	if err != nil {
+		return nil, err
+	}
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	var records []map[string]string
+	for {
+		line, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := make(map[string]string, len(header))
+		for i, name := range header {
+			record[strings.TrimSpace(name)] = line[i]
+		}
+		records = append(records, record)
+	}
+}
+
+// readJSONLRecords reads one JSON object per line, blank lines are
+// skipped.
+func readJSONLRecords(r io.Reader) ([]map[string]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var records []map[string]string
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		d := json.NewDecoder(bytes.NewReader(line))
+		d.UseNumber()
+		var object map[string]interface{}
+		if err := d.Decode(&object); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		record := make(map[string]string, len(object))
+		for name, value := range object {
+			switch value := value.(type) {
+			case nil:
+				record[name] = ""
+			case string:
+				record[name] = value
+			case json.Number:
+				record[name] = value.String()
+			default:
+				return nil, fmt.Errorf("line %d: %s: expected a string or a number", n, name)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
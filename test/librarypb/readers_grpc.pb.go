@@ -0,0 +1,252 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package librarypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ReaderServiceClient is the client API for ReaderService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReaderServiceClient interface {
+	ListReaders(ctx context.Context, in *ListReadersRequest, opts ...grpc.CallOption) (*ListReadersResponse, error)
+	GetReader(ctx context.Context, in *GetReaderRequest, opts ...grpc.CallOption) (*Reader, error)
+	CreateReader(ctx context.Context, in *CreateReaderRequest, opts ...grpc.CallOption) (*Reader, error)
+	// UpdateReader replaces the reader, or only the fields in update_mask.
+	// reader.version has to be the current version, like If-Match.
+	UpdateReader(ctx context.Context, in *UpdateReaderRequest, opts ...grpc.CallOption) (*Reader, error)
+	// DeleteReader fails while the reader holds a book.
+	DeleteReader(ctx context.Context, in *DeleteReaderRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+type readerServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReaderServiceClient(cc grpc.ClientConnInterface) ReaderServiceClient {
+	return &readerServiceClient{cc}
+}
+
+func (c *readerServiceClient) ListReaders(ctx context.Context, in *ListReadersRequest, opts ...grpc.CallOption) (*ListReadersResponse, error) {
+	out := new(ListReadersResponse)
+	err := c.cc.Invoke(ctx, "/library.v1.ReaderService/ListReaders", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *readerServiceClient) GetReader(ctx context.Context, in *GetReaderRequest, opts ...grpc.CallOption) (*Reader, error) {
+	out := new(Reader)
+	err := c.cc.Invoke(ctx, "/library.v1.ReaderService/GetReader", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *readerServiceClient) CreateReader(ctx context.Context, in *CreateReaderRequest, opts ...grpc.CallOption) (*Reader, error) {
+	out := new(Reader)
+	err := c.cc.Invoke(ctx, "/library.v1.ReaderService/CreateReader", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *readerServiceClient) UpdateReader(ctx context.Context, in *UpdateReaderRequest, opts ...grpc.CallOption) (*Reader, error) {
+	out := new(Reader)
+	err := c.cc.Invoke(ctx, "/library.v1.ReaderService/UpdateReader", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *readerServiceClient) DeleteReader(ctx context.Context, in *DeleteReaderRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/library.v1.ReaderService/DeleteReader", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReaderServiceServer is the server API for ReaderService service.
+// All implementations must embed UnimplementedReaderServiceServer
+// for forward compatibility
+type ReaderServiceServer interface {
+	ListReaders(context.Context, *ListReadersRequest) (*ListReadersResponse, error)
+	GetReader(context.Context, *GetReaderRequest) (*Reader, error)
+	CreateReader(context.Context, *CreateReaderRequest) (*Reader, error)
+	// UpdateReader replaces the reader, or only the fields in update_mask.
+	// reader.version has to be the current version, like If-Match.
+	UpdateReader(context.Context, *UpdateReaderRequest) (*Reader, error)
+	// DeleteReader fails while the reader holds a book.
+	DeleteReader(context.Context, *DeleteReaderRequest) (*emptypb.Empty, error)
+	mustEmbedUnimplementedReaderServiceServer()
+}
+
+// UnimplementedReaderServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedReaderServiceServer struct {
+}
+
+func (UnimplementedReaderServiceServer) ListReaders(context.Context, *ListReadersRequest) (*ListReadersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListReaders not implemented")
+}
+func (UnimplementedReaderServiceServer) GetReader(context.Context, *GetReaderRequest) (*Reader, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetReader not implemented")
+}
+func (UnimplementedReaderServiceServer) CreateReader(context.Context, *CreateReaderRequest) (*Reader, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateReader not implemented")
+}
+func (UnimplementedReaderServiceServer) UpdateReader(context.Context, *UpdateReaderRequest) (*Reader, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateReader not implemented")
+}
+func (UnimplementedReaderServiceServer) DeleteReader(context.Context, *DeleteReaderRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteReader not implemented")
+}
+func (UnimplementedReaderServiceServer) mustEmbedUnimplementedReaderServiceServer() {}
+
+// UnsafeReaderServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReaderServiceServer will
+// result in compilation errors.
+type UnsafeReaderServiceServer interface {
+	mustEmbedUnimplementedReaderServiceServer()
+}
+
+func RegisterReaderServiceServer(s grpc.ServiceRegistrar, srv ReaderServiceServer) {
+	s.RegisterService(&ReaderService_ServiceDesc, srv)
+}
+
+func _ReaderService_ListReaders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListReadersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReaderServiceServer).ListReaders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/library.v1.ReaderService/ListReaders",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReaderServiceServer).ListReaders(ctx, req.(*ListReadersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReaderService_GetReader_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetReaderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReaderServiceServer).GetReader(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/library.v1.ReaderService/GetReader",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReaderServiceServer).GetReader(ctx, req.(*GetReaderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReaderService_CreateReader_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateReaderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReaderServiceServer).CreateReader(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/library.v1.ReaderService/CreateReader",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReaderServiceServer).CreateReader(ctx, req.(*CreateReaderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReaderService_UpdateReader_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateReaderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReaderServiceServer).UpdateReader(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/library.v1.ReaderService/UpdateReader",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReaderServiceServer).UpdateReader(ctx, req.(*UpdateReaderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ReaderService_DeleteReader_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteReaderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReaderServiceServer).DeleteReader(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/library.v1.ReaderService/DeleteReader",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReaderServiceServer).DeleteReader(ctx, req.(*DeleteReaderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ReaderService_ServiceDesc is the grpc.ServiceDesc for ReaderService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ReaderService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "library.v1.ReaderService",
+	HandlerType: (*ReaderServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListReaders",
+			Handler:    _ReaderService_ListReaders_Handler,
+		},
+		{
+			MethodName: "GetReader",
+			Handler:    _ReaderService_GetReader_Handler,
+		},
+		{
+			MethodName: "CreateReader",
+			Handler:    _ReaderService_CreateReader_Handler,
+		},
+		{
+			MethodName: "UpdateReader",
+			Handler:    _ReaderService_UpdateReader_Handler,
+		},
+		{
+			MethodName: "DeleteReader",
+			Handler:    _ReaderService_DeleteReader_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "library/v1/readers.proto",
+}
@@ -0,0 +1,213 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package librarypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// RentalServiceClient is the client API for RentalService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RentalServiceClient interface {
+	// TakeBook issues a single-use load token, librarians only.
+	TakeBook(ctx context.Context, in *TakeBookRequest, opts ...grpc.CallOption) (*BookToken, error)
+	// RevokeBookToken is for librarians only.
+	RevokeBookToken(ctx context.Context, in *RevokeBookTokenRequest, opts ...grpc.CallOption) (*BookToken, error)
+	ReturnBook(ctx context.Context, in *ReturnBookRequest, opts ...grpc.CallOption) (*Rental, error)
+	ListRentals(ctx context.Context, in *ListRentalsRequest, opts ...grpc.CallOption) (*ListRentalsResponse, error)
+}
+
+type rentalServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRentalServiceClient(cc grpc.ClientConnInterface) RentalServiceClient {
+	return &rentalServiceClient{cc}
+}
+
+func (c *rentalServiceClient) TakeBook(ctx context.Context, in *TakeBookRequest, opts ...grpc.CallOption) (*BookToken, error) {
+	out := new(BookToken)
+	err := c.cc.Invoke(ctx, "/library.v1.RentalService/TakeBook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rentalServiceClient) RevokeBookToken(ctx context.Context, in *RevokeBookTokenRequest, opts ...grpc.CallOption) (*BookToken, error) {
+	out := new(BookToken)
+	err := c.cc.Invoke(ctx, "/library.v1.RentalService/RevokeBookToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rentalServiceClient) ReturnBook(ctx context.Context, in *ReturnBookRequest, opts ...grpc.CallOption) (*Rental, error) {
+	out := new(Rental)
+	err := c.cc.Invoke(ctx, "/library.v1.RentalService/ReturnBook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rentalServiceClient) ListRentals(ctx context.Context, in *ListRentalsRequest, opts ...grpc.CallOption) (*ListRentalsResponse, error) {
+	out := new(ListRentalsResponse)
+	err := c.cc.Invoke(ctx, "/library.v1.RentalService/ListRentals", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RentalServiceServer is the server API for RentalService service.
+// All implementations must embed UnimplementedRentalServiceServer
+// for forward compatibility
+type RentalServiceServer interface {
+	// TakeBook issues a single-use load token, librarians only.
+	TakeBook(context.Context, *TakeBookRequest) (*BookToken, error)
+	// RevokeBookToken is for librarians only.
+	RevokeBookToken(context.Context, *RevokeBookTokenRequest) (*BookToken, error)
+	ReturnBook(context.Context, *ReturnBookRequest) (*Rental, error)
+	ListRentals(context.Context, *ListRentalsRequest) (*ListRentalsResponse, error)
+	mustEmbedUnimplementedRentalServiceServer()
+}
+
+// UnimplementedRentalServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedRentalServiceServer struct {
+}
+
+func (UnimplementedRentalServiceServer) TakeBook(context.Context, *TakeBookRequest) (*BookToken, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TakeBook not implemented")
+}
+func (UnimplementedRentalServiceServer) RevokeBookToken(context.Context, *RevokeBookTokenRequest) (*BookToken, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeBookToken not implemented")
+}
+func (UnimplementedRentalServiceServer) ReturnBook(context.Context, *ReturnBookRequest) (*Rental, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReturnBook not implemented")
+}
+func (UnimplementedRentalServiceServer) ListRentals(context.Context, *ListRentalsRequest) (*ListRentalsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRentals not implemented")
+}
+func (UnimplementedRentalServiceServer) mustEmbedUnimplementedRentalServiceServer() {}
+
+// UnsafeRentalServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RentalServiceServer will
+// result in compilation errors.
+type UnsafeRentalServiceServer interface {
+	mustEmbedUnimplementedRentalServiceServer()
+}
+
+func RegisterRentalServiceServer(s grpc.ServiceRegistrar, srv RentalServiceServer) {
+	s.RegisterService(&RentalService_ServiceDesc, srv)
+}
+
+func _RentalService_TakeBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TakeBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RentalServiceServer).TakeBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/library.v1.RentalService/TakeBook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RentalServiceServer).TakeBook(ctx, req.(*TakeBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RentalService_RevokeBookToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeBookTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RentalServiceServer).RevokeBookToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/library.v1.RentalService/RevokeBookToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RentalServiceServer).RevokeBookToken(ctx, req.(*RevokeBookTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RentalService_ReturnBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReturnBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RentalServiceServer).ReturnBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/library.v1.RentalService/ReturnBook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RentalServiceServer).ReturnBook(ctx, req.(*ReturnBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RentalService_ListRentals_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRentalsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RentalServiceServer).ListRentals(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/library.v1.RentalService/ListRentals",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RentalServiceServer).ListRentals(ctx, req.(*ListRentalsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RentalService_ServiceDesc is the grpc.ServiceDesc for RentalService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RentalService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "library.v1.RentalService",
+	HandlerType: (*RentalServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "TakeBook",
+			Handler:    _RentalService_TakeBook_Handler,
+		},
+		{
+			MethodName: "RevokeBookToken",
+			Handler:    _RentalService_RevokeBookToken_Handler,
+		},
+		{
+			MethodName: "ReturnBook",
+			Handler:    _RentalService_ReturnBook_Handler,
+		},
+		{
+			MethodName: "ListRentals",
+			Handler:    _RentalService_ListRentals_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "library/v1/rentals.proto",
+}
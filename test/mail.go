@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mail goes to the SMTP server at SMTP_ADDR, MailHog listens on
+// localhost:1025 by default. Credentials are sent only when SMTP_USER is
+// set.
+var (
+	smtpAddr     = envString("SMTP_ADDR", "localhost:1025")
+	smtpUser     = envString("SMTP_USER", "")
+	smtpPassword = envString("SMTP_PASSWORD", "")
+	mailFrom     = envString("MAIL_FROM", "library@library.local")
+	// publicURL is where links in emails point. It has to be configured:
+	// the Host of a request is up to the client, a link built from it could
+	// hand a reset token to someone else's server.
+	publicURL = strings.TrimSuffix(envString("PUBLIC_URL", ""), "/")
+)
+
+var errMailFailed = newAPIError(http.StatusServiceUnavailable, codeUnavailable, msgMailFailed)
+
+// sendMail sends a plain text message. to has passed the email validator,
+// so it can't smuggle in headers.
+func sendMail(to, subject, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", mailFrom, to,
+		mime.QEncoding.Encode("UTF-8", subject), time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(&msg)
+	w.Write([]byte(body))
+	w.Close()
+
+	var auth smtp.Auth
+	if smtpUser != "" {
+		host, _, _ := net.SplitHostPort(smtpAddr)
+		auth = smtp.PlainAuth("", smtpUser, smtpPassword, host)
+	}
+	if err := smtp.SendMail(smtpAddr, auth, mailFrom, []string{to}, msg.Bytes()); err != nil {
+		log.Printf("mail to %s: %v", to, err)
+		return errMailFailed
+	}
+	return nil
+}
+
+// mailURL is the absolute URL of path for links in emails. Without
+// PUBLIC_URL no links are sent.
+func mailURL(path string) (string, error) {
+	if publicURL == "" {
+		log.Printf("PUBLIC_URL is not set, emails with links are not sent")
+		return "", errMailFailed
+	}
+	return publicURL + path, nil
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
 	"github.com/golang-jwt/jwt"
-	"io/ioutil"
+	"image/jpeg"
+	"log"
 	"mime/multipart"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -17,37 +24,58 @@ import (
 
 type Author struct {
 	AuthorId   int64  `pg:"author_id"`
-	AuthorName string `pg:"author_name"`
+	AuthorName string `pg:"author_name" binding:"omitempty,max=50"`
+	Version    int64  `pg:"version" doc:"Incremented by every update, sent as the ETag"`
 }
 
 type Genre struct {
 	GenreId int64  `pg:"genre_id"`
-	Genre   string `pg:"genre"`
+	Genre   string `pg:"genre" binding:"omitempty,max=50"`
+	Version int64  `pg:"version" doc:"Incremented by every update, sent as the ETag"`
 }
 
 type bookTokens struct {
-	Id			int64		`pg:"id"`
-	BookId        int64     `pg:"book_id"`
-	Token          string   `pg:"token"`
-	CreatedAt	time.Time	`pg:"created_at"`
+	Id        int64     `pg:"id"`
+	BookId    int64     `pg:"book_id"`
+	ReaderId  int64     `pg:"reader_id"`
+	Token     string    `pg:"token"`
+	CreatedAt time.Time `pg:"created_at"`
+	ExpiresAt time.Time `pg:"expires_at"`
+	UsedAt    time.Time `pg:"used_at"`
+	RevokedAt time.Time `pg:"revoked_at"`
+	Resumes   int       `pg:"resumes"`
 }
 
 type Reader struct {
 	ReaderId         int64     `pg:"reader_id"`
-	Name             string    `pg:"name"`
-	BirthDate        time.Time `pg:"birth_date"`
-	RegistrationDate time.Time `pg:"registration_date"`
+	Name             string    `pg:"name" binding:"omitempty,max=50"`
+	BirthDate        time.Time `pg:"birth_date" binding:"omitempty,past"`
+	RegistrationDate time.Time `pg:"registration_date" doc:"Set by the server"`
+	Version          int64     `pg:"version" doc:"Incremented by every update, sent as the ETag"`
 }
 
 type Book struct {
 	BookId        int64     `pg:"book_id"`
-	Name          string    `pg:"name"`
+	Name          string    `pg:"name" binding:"omitempty,max=50"`
 	AuthorId      int64     `pg:"author_id"`
 	GenreId       int64     `pg:"genre_id"`
-	CurrentReader int64     `pg:"current_reader"`
+	CurrentReader int64     `pg:"current_reader" doc:"Reader holding the book, 0 when it is free"`
 	ReleaseDate   time.Time `pg:"release_date"`
-	BookFilepath string `pg:"book_filepath"`
-	ImageFilepath string `pg:"image_filepath"`
+	BookFilepath  string    `pg:"book_filepath" doc:"File of the first uploaded format"`
+	ImageFilepath string    `pg:"image_filepath" doc:"Full size cover"`
+	Language      string    `pg:"language" binding:"omitempty,max=10" doc:"Language code from the book metadata"`
+	PageCount     int       `pg:"page_count" binding:"omitempty,min=1" doc:"Known for PDF only"`
+	Isbn          string    `pg:"isbn" binding:"omitempty,isbn" doc:"ISBN-10 or ISBN-13, hyphens allowed"`
+	UpdatedAt     time.Time `pg:"updated_at" doc:"Last change of the catalogue record, set by the server"`
+	Version       int64     `pg:"version" doc:"Incremented by every update, sent as the ETag"`
+}
+
+// namedBook is a book with the names of its author and genre, the form
+// MARC records and import files map to.
+type namedBook struct {
+	Book
+	Author string `binding:"omitempty,max=50"`
+	Genre  string `binding:"omitempty,max=50"`
 }
 
 type RentalHistory struct {
@@ -55,41 +83,50 @@ type RentalHistory struct {
 	BookId     int64     `pg:"book_id"`
 	ReaderId   int64     `pg:"reader_id"`
 	RentalDate time.Time `pg:"rental_date"`
-	ReturnDate time.Time `pg:"return_date"`
+	ReturnDate time.Time `pg:"return_date" doc:"Zero while the book is out"`
+	DueDate    time.Time `pg:"due_date" doc:"Fines are charged for every day the book is kept longer"`
 }
 
 type TimeIntervalsForHistory struct {
 	RentalDateFrom time.Time
-	RentalDateTo   time.Time
+	RentalDateTo   time.Time `binding:"omitempty,gtefield=RentalDateFrom"`
 	ReturnDateFrom time.Time
-	ReturnDateTo   time.Time
+	ReturnDateTo   time.Time `binding:"omitempty,gtefield=ReturnDateFrom"`
 }
 type bookSearch struct {
-	BookId        int64     `pg:"book_id"`
-	Book          string    `pg:"book"`
-	Author        string    `pg:"author"`
-	ReleaseDate   time.Time `pg:"release_date"`
-	Genre         string    `pg:"genre"`
-	CurrentReader int64     `pg:"current_reader"`
-	ImageFilepath string `pg:"image_filepath"`
+	BookId        int64             `pg:"book_id"`
+	Book          string            `pg:"book" doc:"Book name"`
+	Author        string            `pg:"author" doc:"Author name"`
+	ReleaseDate   time.Time         `pg:"release_date"`
+	Genre         string            `pg:"genre"`
+	CurrentReader int64             `pg:"current_reader" doc:"Reader holding the book, 0 when it is free"`
+	ImageFilepath string            `pg:"image_filepath"`
+	ImageVariants map[string]string `pg:"-" doc:"Cover thumbnails by size name"`
 }
 
 type searchParams struct {
 	OrderBy string
 	Offset  int `pg:"offset"`
 	Status  string
-	Author string `pg:"author.author_name"`
+	Author  string `pg:"author.author_name"`
 }
 
 type Users struct {
-	Id         int64      `pg:"id"`
-	Name        string    `pg:"name"`
-	Password    string    `pg:"password"`
-	Role 		string 		`pg:"role"`
+	Id       int64  `pg:"id"`
+	Name     string `pg:"name" binding:"omitempty,max=20"`
+	Password string `pg:"password" binding:"omitempty,min=4,max=72" doc:"Stored as a bcrypt hash"`
+	Role     string `pg:"role"`
+	ReaderId int64  `pg:"reader_id" doc:"Reader the user borrows as, 0 when not linked"`
+	Email    string `pg:"email" binding:"omitempty,email,max=254"`
+	// Active is false for signed up users until they verify the email
+	Active     bool      `pg:"active"`
+	VerifiedAt time.Time `pg:"verified_at" doc:"When the email was verified"`
+	Version    int64     `pg:"version" doc:"Incremented by every update, sent as the ETag"`
 }
 type Roles struct {
-	Id   int64  `pg:"id"`
-	Role string `pg:"role"`
+	Id      int64  `pg:"id"`
+	Role    string `pg:"role" binding:"omitempty,role"`
+	Version int64  `pg:"version" doc:"Incremented by every update, sent as the ETag"`
 }
 type jwtRefreshClaims struct {
 	Id int64
@@ -97,10 +134,10 @@ type jwtRefreshClaims struct {
 }
 
 type jwtAccessClaims struct {
-	Id int64
+	Id   int64
 	User string
 	Role string
-	Pop string
+	Pop  string
 	jwt.StandardClaims
 }
 
@@ -116,191 +153,221 @@ func main() {
 	})
 	defer db.Close()
 
-	r := gin.Default()
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		code := runImport(os.Args[2:], os.Stdout, os.Stderr)
+		db.Close()
+		os.Exit(code)
+	}
 
-	r.POST("refresh", validateRefreshToken)
-	r.POST("login", login)
-
-	r.Use(verifyAccessToken)
-
-	authorsApi := r.Group("api/authors")
-	authorsApi.GET("", allAuthors)
-	authorsApi.POST("", createAuthor)
-	authorsApi.DELETE("", deleteAuthor)
-	authorsApi.PUT("", updateAuthor)
-
-	genreApi := r.Group("api/genres")
-	genreApi.GET("", allGenres)
-	genreApi.POST("", createGenre)
-	genreApi.DELETE("", deleteGenre)
-	genreApi.PUT("", updateGenre)
-
-	readerApi := r.Group("api/readers")
-	readerApi.GET("", allReaders)
-	readerApi.POST("", createReader)
-	readerApi.DELETE("", deleteReader)
-	readerApi.PUT("", updateReader)
-
-	bookApi := r.Group("api/books")
-	bookApi.GET("", showBooks)
-	bookApi.POST("", createBook)
-	bookApi.DELETE("", deleteBook)
-	bookApi.PUT("", updateBook)
-
-	userApi := r.Group("api/users")
-	userApi.GET("*id", getUser)
-	userApi.POST("", createUsers)
-	userApi.DELETE("", deleteUser)
-	userApi.PUT("", changePassword)
-
-	roleApi := r.Group("api/roles")
-	roleApi.GET("*id", getRoles)
-	roleApi.POST("", createRole)
-	roleApi.DELETE("", deleteRole)
-	roleApi.PUT("", changeRole)
-
-	//r.POST("/api/rentbook", rentABook)
-	r.POST("/api/returnbook", returnBook)
-	r.POST("/api/rentalhistory", showHistory)
-	r.POST("/save",saveFile)
-	r.GET("logout", logout)
-	r.POST("take-book", takeBook)
-	r.POST("load-book", loadBook)
+	go purgeBookTokens(bookTokenPurgeInterval)
+	go func() {
+		log.Fatal(serveGRPC(grpcAddr))
+	}()
 
+	r := setupRouter()
 	r.Run() // listen and serve on 0.0.0.0:8080 (for windows "localhost:8080")
 }
 
-func loadBook(c *gin.Context){
-	var bookToken *bookTokens
-	err := c.Bind(&bookToken)
+func setupRouter() *gin.Engine {
+	r := gin.Default()
+	r.Use(requestId, localize)
+
+	for _, v := range apiVersions {
+		v.register(r.Group(v.prefix))
+	}
+	// the document covers every version, so it is not deprecated with the
+	// legacy routes
+	r.GET("openapi.json", serveOpenAPI)
+	r.GET("docs/*file", serveSwaggerUI)
+	registerLegacy(r)
+	return r
+}
+
+func loadBook(c *gin.Context) {
+	bookToken := new(bookTokens)
+	if token := c.Param("token"); token != "" {
+		bookToken.Token = token
+	} else if err := bindValid(c, bookToken, "Token"); err != nil {
+		respondError(c, err)
+		return
+	}
+	readerId, err := currentReaderId(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
-	_, err = db.QueryOne(bookToken, `SELECT * FROM book_load_tokens WHERE token = ?`, bookToken.Token)
+	err = findBookToken(bookToken, readerId)
+	if err == pg.ErrNoRows {
+		err = newAPIError(http.StatusNotFound, codeNotFound, msgBookTokenInvalid)
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
-	timeDifference := time.Now().Sub(bookToken.CreatedAt)
-	if timeDifference.Hours() > 1 {
-		c.JSON(http.StatusNotFound, gin.H{
-			"Время токена истекло" : "",
-		})
+	// the file is picked before the token is used up, so a missing format
+	// or file leaves the token as it was
+	file, format, err := pickBookFile(c, bookToken.BookId)
+	if err == nil {
+		_, err = os.Stat(file.Filepath)
+	}
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	bookPath, err := queryLoadBook(bookToken.Token)
-	fmt.Println(timeDifference)
-	data, err := ioutil.ReadFile(bookPath)
-	fmt.Println(bookPath)
+	var resume bool
+	if !bookToken.UsedAt.IsZero() {
+		// a used token only resumes the download of the same bytes
+		if path, err := rentedBookPath(file, format, readerId, bookToken.BookId); err == nil {
+			if info, err := os.Stat(path); err == nil {
+				resume = resumesDownload(c.Request.Header, fileETag(info))
+			}
+		}
+	}
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		redeemed, err := redeemBookToken(tx, bookToken, readerId, resume)
+		if err == pg.ErrNoRows {
+			return newAPIError(http.StatusNotFound, codeNotFound, msgBookTokenInvalid)
+		}
+		if err != nil || !redeemed {
+			return err
+		}
+		// tokens of /me/loans download a book the reader already holds
+		var holding bool
+		_, err = tx.QueryOne(pg.Scan(&holding), `SELECT EXISTS (SELECT 1 FROM rental_history
+WHERE reader_id = ? AND book_id = ? AND return_date IS NULL)`, readerId, bookToken.BookId)
+		if err != nil || holding {
+			return err
+		}
+		return rentABook(tx, readerId, bookToken.BookId)
+	})
 	if err != nil {
-		fmt.Println("File reading error", err)
+		respondError(c, err)
 		return
 	}
-	fmt.Println(c.Keys["id"])
-	readerId := c.Keys["id"].(int64)
-	fmt.Println(readerId)
+	sendRentedBook(c, file, format, readerId, bookToken.BookId)
+}
 
-	err = rentABook(readerId,bookToken.BookId)
+// sendRentedBook sends a file of a book the reader holds.
+func sendRentedBook(c *gin.Context, file BookFile, format bookFormat, readerId, bookId int64) {
+	path, err := rentedBookPath(file, format, readerId, bookId)
 	if err != nil {
-		fmt.Println("error", err)
+		respondError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"байты книги" : data,
-	})
-	//if err := os.WriteFile("file.pdf", data, 0666); err != nil { проверка файла
-	//	log.Fatal(err)
-	//}
+	serveBookFile(c, path, filepath.Base(file.Filepath), format.ContentType)
 }
-func queryLoadBook(token string) (bookPath string,err error) {
-	_, err = db.QueryOne(&bookPath, `SELECT book_filepath FROM book INNER JOIN book_load_tokens bl on bl.book_id = book.book_id WHERE token = ?`, token)
+
+// rentedBookPath is the path of the file sent to the reader holding the
+// book. Only PDFs can be stamped, EPUB and FB2 are sent as uploaded.
+func rentedBookPath(file BookFile, format bookFormat, readerId, bookId int64) (string, error) {
+	if format.Name != "pdf" {
+		return file.Filepath, nil
+	}
+	rental, err := currentRental(readerId, bookId)
 	if err != nil {
-		fmt.Println("finduser err",err.Error())
 		return "", err
 	}
-	return bookPath, err
+	return watermarkedCopy(file.Filepath, rental)
 }
 
-func takeBook(c *gin.Context) {
-	if c.Keys["role"] != "librarian"{
-		c.JSON(http.StatusBadRequest, gin.H{"Недостаточно прав": ""})
-		return}
-	var loadBooks *bookTokens
-	err := c.Bind(&loadBooks)
+// currentReaderId returns the reader linked to the user of the request.
+// Users without one get errNoReader.
+func currentReaderId(c *gin.Context) (int64, error) {
+	if id, ok := c.Keys["reader_id"].(int64); ok {
+		return id, nil
+	}
+	var id int64
+	_, err := db.QueryOne(pg.Scan(&id), `SELECT coalesce(reader_id, 0) FROM users WHERE id = ?`, c.GetInt64("id"))
+	if err == pg.ErrNoRows || err == nil && id == 0 {
+		return 0, errNoReader
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		return 0, err
+	}
+	c.Set("reader_id", id)
+	return id, nil
+}
+
+// requireReader stops requests of users that are not linked to a reader.
+func requireReader(c *gin.Context) {
+	if _, err := currentReaderId(c); err != nil {
+		respondError(c, err)
 		return
 	}
-	loadBooks.Token, err = generateBookToken()
-	if err != nil {
-	c.JSON(http.StatusInternalServerError, gin.H{
-		"error": err.Error(),
-	})
-	return
+	c.Next()
+}
+
+func takeBook(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	loadBooks := new(bookTokens)
+	err := bindValid(c, loadBooks, "BookId", "ReaderId")
+	if err == nil {
+		err = issueBookToken(loadBooks)
 	}
-	_, err = db.QueryOne(loadBooks, `INSERT INTO book_load_tokens (token, book_id) VALUES (?token,?book_id) RETURNING *`, loadBooks)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"токен для закгрузки" : loadBooks.Token,
+		"message":    tr(c, msgBookTokenIssued, int(bookTokenTTL.Minutes())),
+		"token":      loadBooks.Token,
+		"expires_at": loadBooks.ExpiresAt,
 	})
 }
 
+// issueBookToken stores a new load token for token.BookId and
+// token.ReaderId and fills in the rest.
+func issueBookToken(token *bookTokens) error {
+	var err error
+	token.Token, err = generateBookToken()
+	if err != nil {
+		return err
+	}
+	_, err = db.QueryOne(token, `INSERT INTO book_load_tokens (token, book_id, reader_id, expires_at) 
+VALUES (?token,?book_id,?reader_id,CURRENT_TIMESTAMP + make_interval(secs => ?)) RETURNING *`, token, bookTokenTTL.Seconds())
+	return err
+}
+
 func logout(c *gin.Context) {
 	var id string
-	fmt.Println(c.Keys["id"])
 	_, err := db.QueryOne(&id, `DELETE FROM sessions WHERE user_id = ? RETURNING user_id`, c.Keys["id"])
 	if err == nil {
 		c.JSON(http.StatusOK, gin.H{
-			id : "удален",
+			"message": tr(c, msgSessionDeleted),
+			"result":  id,
 		})
 		return
 	}
-	c.JSON(http.StatusBadRequest, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 }
 
 func validateRefreshToken(c *gin.Context) {
 	var RefreshPar struct {
 		RefreshToken string `pg:"refresh_token"`
 	}
-	err := c.Bind(&RefreshPar)
+	err := bindValid(c, &RefreshPar, "RefreshToken")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
-	fmt.Println(RefreshPar)
-	fmt.Println(RefreshPar.RefreshToken)
 	user, err := findSession(RefreshPar.RefreshToken)
+	if err == pg.ErrNoRows {
+		err = errUnauthorized
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 	accessToken, err := generateAccessToken(*user)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"accessToken": accessToken,
+		"accessToken":  accessToken,
 		"refreshToken": RefreshPar.RefreshToken,
 	})
 }
@@ -309,47 +376,40 @@ func findSession(refreshToken string) (*Users, error) {
 	var user Users
 	_, err := db.QueryOne(&user, `SELECT user_roles.user_id AS id, name, password, role FROM user_roles INNER JOIN roles r on r.id = user_roles.role_id INNER JOIN users u on u.id = user_roles.user_id INNER JOIN sessions s on s.user_id = user_roles.user_id WHERE refresh_token = ?`, refreshToken)
 	if err != nil {
-		fmt.Println("finduser err",err)
 		return nil, err
 	}
-	fmt.Println(user)
 	return &user, nil
 }
 
-
 func saveFile(c *gin.Context) {
 	type loginPar struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
 	}
 	var jsonAndFile struct {
-		login loginPar
+		login    loginPar
 		fileData *multipart.FileHeader
 	}
 
-	err := c.Bind(&jsonAndFile.login)
+	err := c.ShouldBind(&jsonAndFile.login)
 
 	//
 	//err = c.ShouldBindJSON(&loginPar)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 	jsonAndFile.fileData, err = c.FormFile("file")
 	// The file cannot be received.
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
-	filePath := "resources/" +jsonAndFile.fileData.Filename
+	filePath := "resources/" + jsonAndFile.fileData.Filename
 	//// File saved successfully. Return proper result
 	//c.JSON(http.StatusOK, gin.H{
-	//	"message": "Your file has been successfully uploaded.",
+	//	"message": tr(c, msgFileUploaded),
 	//	"json":loginPar,
 	//})
 	// Retrieve file information
@@ -359,27 +419,37 @@ func saveFile(c *gin.Context) {
 
 	// The file is received, so let's save it
 	if err := c.SaveUploadedFile(jsonAndFile.fileData, filePath); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"message":err,
-		})
+		respondError(c, err)
 		return
 	}
 	// File saved successfully. Return proper result
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Your file has been successfully uploaded.",
-		"json":jsonAndFile.fileData.Filename,
-		"12313":jsonAndFile.login,
+		"message": tr(c, msgFileUploaded),
+		"json":    jsonAndFile.fileData.Filename,
+		"12313":   jsonAndFile.login,
 	})
 }
 
 func verifyAccessToken(c *gin.Context) {
+	user, err := authenticate(c.GetHeader("Authorization"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Set("id", user.Id)
+	c.Set("username", user.Name)
+	c.Set("role", user.Role)
+	//c.Writer.Header().Set("Authorization", "Bearer "+token)
+	c.Next()
+}
 
-	authValue := c.GetHeader("Authorization")
+// authenticate checks the bearer token of an Authorization header, for
+// both the HTTP and the gRPC API.
+func authenticate(authValue string) (*Users, error) {
 	arr := strings.Split(authValue, " ")
 	//fmt.Println(arr,"arr")
 	if len(arr) != 2 {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{})
-		return
+		return nil, errUnauthorized
 	}
 	//authType := strings.Trim(arr[0], "\n\r\t")
 	//fmt.Println(authType,"authtype")
@@ -391,19 +461,12 @@ func verifyAccessToken(c *gin.Context) {
 	//fmt.Println(token,"token")
 	user, err := validateToken(token)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Ошибка": err.Error()})
-		return
+		return nil, newAPIError(http.StatusUnauthorized, codeUnauthorized, msgInvalidAccessToken).withDetails(err.Error())
 	}
-	if user.Name == ""{
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"рефреш токен не авторизирует": ""})
-		return
+	if user.Name == "" {
+		return nil, newAPIError(http.StatusUnauthorized, codeUnauthorized, msgRefreshTokenNotValid)
 	}
-	c.Set("id", user.Id)
-	c.Set("username", user.Name)
-	c.Set("role", user.Role)
-	//c.Writer.Header().Set("Authorization", "Bearer "+token)
-	fmt.Println(c.Keys["username"])
-	c.Next()
+	return user, nil
 }
 
 func login(c *gin.Context) {
@@ -412,47 +475,39 @@ func login(c *gin.Context) {
 		Username string `json:"username"`
 		Password string `json:"password"`
 	}
-	err := c.ShouldBindJSON(&loginPar)
+	err := bindValid(c, &loginPar, "Username", "Password")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "incorrect parameters",
-		})
+		respondError(c, err)
 		return
 	}
 	user, err := findUser(&Users{
-		Name: loginPar.Username,
+		Name:     loginPar.Username,
 		Password: loginPar.Password,
 	})
+	if err == pg.ErrNoRows {
+		err = newAPIError(http.StatusUnauthorized, codeUnauthorized, msgBadCredentials)
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": fmt.Sprintf("Неправильный логин или пароль"),
-		})
+		respondError(c, err)
 		return
 	}
-	fmt.Println(user)
 	accessToken, err := generateAccessToken(*user)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 	refreshToken, err := generateRefreshToken(*user)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
-	err = startSession(user.Id,refreshToken)
+	err = startSession(user.Id, refreshToken)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"accessToken": accessToken,
+		"accessToken":  accessToken,
 		"refreshToken": refreshToken,
 	})
 }
@@ -464,16 +519,20 @@ func generateRefreshToken(user Users) (string, error) {
 		},
 		Id: user.Id,
 	})
-	fmt.Println(token.Claims)
 	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
-func generateBookToken() (string,error) {
+func generateBookToken() (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.StandardClaims{
-		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Id:        hex.EncodeToString(jti),
+		ExpiresAt: time.Now().Add(bookTokenTTL).Unix(),
 	})
 	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
@@ -489,9 +548,8 @@ func generateAccessToken(user Users) (string, error) {
 		},
 		User: user.Name,
 		Role: user.Role,
-		Id: user.Id,
+		Id:   user.Id,
 	})
-	fmt.Println(token.Claims)
 	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
 		return "", err
@@ -514,25 +572,36 @@ func validateToken(tokenString string) (*Users, error) {
 	if !token.Valid {
 		return nil, errors.New("invalid token")
 	}
+	// tokens with an audience, like verification links, are not for access
+	if claims.Audience != "" {
+		return nil, errors.New("token is not an access token")
+	}
 	return &Users{
 		Name: claims.User,
 		Role: claims.Role,
-		Id: claims.Id,
+		Id:   claims.Id,
 	}, nil
 }
 
+// findUser looks up the user by name and checks the password, a wrong
+// password is reported like an unknown name.
 func findUser(user *Users) (*Users, error) {
-	_, err := db.QueryOne(user, `SELECT user_id AS id, name, password, role FROM user_roles INNER JOIN roles r on r.id = user_roles.role_id INNER JOIN users u on u.id = user_roles.user_id WHERE name = ? AND password = ?`, user.Name,user.Password)
+	password := user.Password
+	_, err := db.QueryOne(user, `SELECT user_id AS id, name, password, role, active FROM user_roles INNER JOIN roles r on r.id = user_roles.role_id INNER JOIN users u on u.id = user_roles.user_id WHERE name = ?`, user.Name)
+	if err == nil && !checkPassword(user.Password, password) {
+		err = pg.ErrNoRows
+	}
 	if err != nil {
-		fmt.Println("finduser err",err)
 		return nil, err
 	}
-	fmt.Println(user)
+	if !user.Active {
+		return nil, errAccountInactive
+	}
 	return user, nil
 }
 
-func startSession (id int64, token string) error {
-	_, err := db.Exec(`INSERT INTO sessions (user_id, refresh_token) values (?,?)`, id,token)
+func startSession(id int64, token string) error {
+	_, err := db.Exec(`INSERT INTO sessions (user_id, refresh_token) values (?,?)`, id, token)
 	if err != nil {
 		return err
 	}
@@ -541,69 +610,77 @@ func startSession (id int64, token string) error {
 
 func changeRole(c *gin.Context) {
 
-	var role *Roles
-	err := c.Bind(&role)
+	role := new(Roles)
+	err := bindValid(c, role, "Id", "Role")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error msg": err.Error(),
-		})
+		respondError(c, err)
+		return
+	}
+	if role.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
 		return
 	}
-	_, err = db.QueryOne(role, `UPDATE roles SET role = (?role) WHERE id = (?id) RETURNING *`, role)
+	_, err = db.QueryOne(role, `UPDATE roles SET role = (?role), version = version + 1 WHERE id = (?id) AND version = COALESCE(NULLIF(?version, 0), version) RETURNING *`, role)
+	if err == pg.ErrNoRows {
+		err = missedRow("roles", "id", role.Id, role.Version, errVersionChanged)
+	}
 	if err == nil {
+		setETag(c, role.Version)
 		c.JSON(http.StatusOK, gin.H{
-			role.Role: "роль изменена",
+			"message": tr(c, msgRoleChanged),
+			"result":  role,
 		})
 		return
 	}
-	c.JSON(http.StatusBadRequest, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 
 func deleteRole(c *gin.Context) {
 
-	var role *Roles
-	err := c.Bind(&role)
+	role := new(Roles)
+	err := bindValid(c, role, "Id")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error msg": err.Error()})
+		respondError(c, err)
+		return
+	}
+	if role.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
 		return
 	}
 
-	_, err = db.QueryOne(role, `DELETE FROM roles WHERE id = ? RETURNING *`, role.Id)
+	_, err = db.QueryOne(role, `DELETE FROM roles WHERE id = ?0 AND version = COALESCE(NULLIF(?1, 0), version) RETURNING *`, role.Id, role.Version)
+	if err == pg.ErrNoRows {
+		err = missedRow("roles", "id", role.Id, role.Version, errVersionChanged)
+	}
 	if err == nil {
 		c.JSON(http.StatusOK, gin.H{
-			role.Role : "удален",
+			"message": tr(c, msgRoleDeleted),
+			"result":  role,
 		})
 		return
 	}
-	c.JSON(http.StatusBadRequest, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 }
 
 func createRole(c *gin.Context) {
 
-	var role *Roles
-	err := c.Bind(&role)
+	role := new(Roles)
+	err := bindValid(c, role, "Role")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error msg": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 	_, err = db.QueryOne(role, `
 		INSERT INTO roles (role) VALUES (?role) RETURNING *`, role)
 	if err == nil {
 		c.JSON(http.StatusOK, gin.H{
-			role.Role : "Роль успешно добавлена",
+			"message": tr(c, msgRoleCreated),
+			"result":  role,
 		})
 		return
 	}
-	c.JSON(http.StatusBadRequest, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 
@@ -617,14 +694,12 @@ func getRoles(c *gin.Context) {
 			c.JSON(http.StatusOK, role)
 			return
 		}
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error msg": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
-	//err := c.Bind(&role)
+	//err := c.ShouldBind(&role)
 	//if err != nil {
-	//	c.JSON(http.StatusBadRequest, gin.H{"error msg": err.Error()})
+	//	respondError(c, err)
 	//	return
 	//}
 	_, err := db.Query(&role, `SELECT * FROM roles`)
@@ -632,263 +707,280 @@ func getRoles(c *gin.Context) {
 		c.JSON(http.StatusOK, role)
 		return
 	}
-	c.JSON(http.StatusBadRequest, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 
+// changePassword lets a librarian set the password of a user, who is
+// logged out everywhere.
 func changePassword(c *gin.Context) {
-
-	var user *Users
-	err := c.Bind(&user)
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	user := new(Users)
+	err := bindValid(c, user, "Id", "Password")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error msg": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
-	_, err = db.QueryOne(user, `UPDATE users SET password = (?password) WHERE id = (?id) RETURNING *`, user)
+	if user.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
+		return
+	}
+	if user.Password, err = hashPassword(user.Password); err != nil {
+		respondError(c, err)
+		return
+	}
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		_, err := tx.QueryOne(user, `UPDATE users SET password = (?password), version = version + 1 WHERE id = (?id) AND version = COALESCE(NULLIF(?version, 0), version) RETURNING *`, user)
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, user.Id)
+		}
+		return err
+	})
+	if err == pg.ErrNoRows {
+		err = missedRow("users", "id", user.Id, user.Version, errVersionChanged)
+	}
 	if err == nil {
+		setETag(c, user.Version)
 		c.JSON(http.StatusOK, gin.H{
-			user.Name: "пароль изменен",
+			"message": tr(c, msgPasswordChanged),
+			"result":  user.Name,
 		})
 		return
 	}
-	c.JSON(http.StatusBadRequest, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 }
 
 func deleteUser(c *gin.Context) {
 
-	var user *Users
-	err := c.Bind(&user)
+	user := new(Users)
+	err := bindValid(c, user, "Id")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error msg": err.Error()})
+		respondError(c, err)
 		return
 	}
-	if user.Id == 1{
-		c.JSON(http.StatusBadRequest, gin.H{
-			"Невозможно удалить данного пользователя":"",
-		})
+	if user.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
+		return
+	}
+	if user.Id == 1 {
+		respondError(c, newAPIError(http.StatusConflict, codeConflict, msgUserInUse))
 		return
 	}
-	_, err = db.QueryOne(user, `DELETE FROM users WHERE id = ? RETURNING *`, user.Id)
+	_, err = db.QueryOne(user, `DELETE FROM users WHERE id = ?0 AND version = COALESCE(NULLIF(?1, 0), version) RETURNING *`, user.Id, user.Version)
+	if err == pg.ErrNoRows {
+		err = missedRow("users", "id", user.Id, user.Version, errVersionChanged)
+	}
 	if err == nil {
 		c.JSON(http.StatusOK, gin.H{
-			user.Name : "удален",
+			"message": tr(c, msgUserDeleted),
+			"result":  user.Name,
 		})
 		return
 	}
-	c.JSON(http.StatusBadRequest, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 }
 
 func createUsers(c *gin.Context) {
-
-		var user *Users
-		err := c.Bind(&user)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error msg": err.Error(),
-			})
-			return
-		}
-		_, err = db.QueryOne(user, `
-		INSERT INTO users (name, password) VALUES (?name,?password) RETURNING *`, user)
-		if err == nil {
-			c.JSON(http.StatusOK, gin.H{
-				user.Name : "успешно добавлен",
-			})
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error msg": err.Error(),
-		})
-}
-
-func getUser(c *gin.Context) {
-
-		var user []Users
-	id := c.Param("id")
-	fmt.Println(id)
-	id = strings.ReplaceAll(id, "/", "")
-	if id != "" {
-		_, err := db.Query(&user, `SELECT * FROM users WHERE id = (?)`, id)
-		if err == nil {
-			c.JSON(http.StatusOK, user)
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error msg": err.Error(),
+	user, err := bindRegistration(c)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgUserCreated),
+			"result":  user.Name,
 		})
 		return
 	}
-		_, err := db.Query(&user, `SELECT * FROM users`)
-		if err == nil {
-			c.JSON(http.StatusOK, user)
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error msg": err.Error(),
-		})
-	}
-
-
-func BasicAuth() gin.HandlerFunc {
-
-	var user []Users
-	_, err := db.Query(&user, `SELECT * FROM users`)
+	respondError(c, err)
+}
 
+// getUser lists the users without their passwords. Users who are not
+// librarians only see themselves.
+func getUser(c *gin.Context) {
+	var users []Users
+	query := `SELECT ` + userResource.columns + ` FROM users WHERE TRUE`
+	var params []interface{}
+	if id := strings.ReplaceAll(c.Param("id"), "/", ""); id != "" {
+		query += ` AND id = ?`
+		params = append(params, id)
+	}
+	if !isLibrarian(c) {
+		query += ` AND id = ?`
+		params = append(params, c.GetInt64("id"))
+	}
+	_, err := db.Query(&users, query+` ORDER BY id`, params...)
 	if err != nil {
-		fmt.Println(err)
+		respondError(c, err)
+		return
 	}
-	m:= make(map[string]string)
-	for _, v:= range user{
-		m[v.Name] = v.Password
+	result := make([]publicUser, len(users))
+	for i := range users {
+		result[i] = presentUser(&users[i]).(publicUser)
 	}
-	fmt.Println(m)
-	return gin.BasicAuth(m)
+	c.JSON(http.StatusOK, result)
 }
 
-
 func showHistory(c *gin.Context) {
 
 	var history []RentalHistory
-	var interval *TimeIntervalsForHistory
-	err := c.Bind(&interval)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error msg": err.Error()})
-		return
+	interval := new(TimeIntervalsForHistory)
+	err := bindValid(c, interval)
+	if err == nil {
+		history, err = rentalsBetween(interval)
 	}
-
-	_, err = db.Query(&history, `SELECT * FROM rental_history WHERE rental_date >= (?) AND rental_date <= (?)
-AND return_date >= (?) AND return_date <= (?)`, interval.RentalDateFrom, interval.RentalDateTo, interval.ReturnDateFrom, interval.ReturnDateTo)
 	if err == nil {
-		c.JSON(200, gin.H{
+		c.JSON(http.StatusOK, gin.H{
 			"result": history,
 		})
-		//c.String(200, fmt.Sprint(book))
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
+
+}
 
+// rentalsBetween returns the rentals within the dates, bounds that are not
+// given are open.
+func rentalsBetween(interval *TimeIntervalsForHistory) ([]RentalHistory, error) {
+	var history []RentalHistory
+	where, params := (&rentalQuery{TimeIntervalsForHistory: *interval}).where()
+	cond := "TRUE"
+	if len(where) > 0 {
+		cond = strings.Join(where, " AND ")
+	}
+	_, err := db.Query(&history, `SELECT r.* FROM rental_history r WHERE `+cond+` ORDER BY r.rental_id`, params...)
+	return history, err
 }
 
 func returnBook(c *gin.Context) {
-	var history *RentalHistory
-	t := time.Now().Add(time.Minute * 360)
-	err := c.Bind(&history)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error msg": err.Error()})
-		return
+	history := new(RentalHistory)
+	err := bindValid(c, history, "BookId")
+	if err == nil {
+		err = returnRental(history)
 	}
-	history.ReturnDate = t
-	_, err = db.QueryOne(history, `UPDATE rental_history SET return_date = (?return_date) WHERE book_id = (?book_id) AND rental_date = (select max(rental_date) from rental_history where book_id = (?book_id)) RETURNING *`, history)
 	if err == nil {
-		c.JSON(400, gin.H{
+		c.JSON(http.StatusOK, gin.H{
 			"result": history,
 		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 
-func rentABook(readerId int64, bookId int64) error{
-
-	_, err := db.Exec(`BEGIN`)
-	if err != nil {
-		return err
-	}
+// returnRental closes the latest rental of history.BookId.
+func returnRental(history *RentalHistory) error {
+	history.ReturnDate = time.Now().Add(time.Minute * 360)
+	_, err := db.QueryOne(history, `UPDATE rental_history SET return_date = (?return_date) WHERE book_id = (?book_id) AND rental_date = (select max(rental_date) from rental_history where book_id = (?book_id)) RETURNING *`, history)
+	return err
+}
 
-	_, err = db.Exec(`UPDATE book SET current_reader = (?) WHERE book_id = (?)`, readerId,bookId)
-	if err != nil {
-		return err
-	}
+// loanPeriod is how long a book may be kept before fines are charged.
+var loanPeriod = envInt64("LOAN_DAYS", 14)
 
-	_, err = db.Exec(`
-		INSERT INTO rental_history (reader_id,book_id) VALUES (?,?)`, readerId, bookId)
+// rentABook gives the book to the reader, fulfilling the reader's hold on
+// it.
+// rentABook lends the book to the reader within tx.
+func rentABook(tx orm.DB, readerId int64, bookId int64) error {
+	_, err := tx.Exec(`UPDATE book SET current_reader = (?) WHERE book_id = (?)`, readerId, bookId)
 	if err != nil {
 		return err
 	}
-
-	_, err = db.Exec(`COMMIT`)
+	_, err = tx.Exec(`
+		INSERT INTO rental_history (reader_id,book_id,due_date) VALUES (?,?,CURRENT_TIMESTAMP + ? * INTERVAL '1 day')`, readerId, bookId, loanPeriod)
 	if err != nil {
 		return err
 	}
-	fmt.Println(readerId, " c книгой ", bookId, " добавлен")
-	return nil
+	_, err = tx.Exec(`DELETE FROM holds WHERE reader_id = ? AND book_id = ?`, readerId, bookId)
+	return err
 }
 
 func updateBook(c *gin.Context) {
-	var book *Book
-	err := c.Bind(&book)
+	book := new(Book)
+	err := bindValid(c, book, "BookId", "Name")
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error msg": err.Error(),
-		})
+		respondError(c, err)
+		return
+	}
+	if book.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
+		return
+	}
+	_, err = db.QueryOne(book, `UPDATE book SET name = (?name), version = version + 1 WHERE book_id = (?book_id) AND version = COALESCE(NULLIF(?version, 0), version) RETURNING *`, book)
+	if err == pg.ErrNoRows {
+		err = missedRow("book", "book_id", book.BookId, book.Version, errVersionChanged)
 	}
-	_, err = db.QueryOne(book, `UPDATE book SET name = (?name) WHERE book_id = (?book_id) RETURNING *`, book)
 	if err == nil {
-		c.String(200, fmt.Sprint(book.BookId, " ", book.Name, " изменен успешно"))
+		setETag(c, book.Version)
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgBookUpdated),
+			"result":  book,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 
 func deleteBook(c *gin.Context) {
 
-	var book *Book
-	err := c.Bind(&book)
+	book := new(Book)
+	err := bindValid(c, book, "BookId")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error msg": err.Error()})
+		respondError(c, err)
 		return
 	}
-	_, err = db.QueryOne(book, `DELETE FROM book WHERE book_id = ? AND current_reader IS NULL RETURNING *`, book.BookId)
-	if err == nil {
-		c.String(200, fmt.Sprint(book.BookId, " ", book.Name, " удален успешно"))
+	if book.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
 		return
 	}
-	if err.Error() == "pg: no rows in result set" {
-		c.String(200, fmt.Sprint("Такой книги не существует/Нельзя удалить книгу с действующим читателем"))
+	_, err = db.QueryOne(book, `DELETE FROM book WHERE book_id = ?0 AND version = COALESCE(NULLIF(?1, 0), version) AND current_reader IS NULL RETURNING *`, book.BookId, book.Version)
+	if err == pg.ErrNoRows {
+		err = missedRow("book", "book_id", book.BookId, book.Version, newAPIError(http.StatusConflict, codeConflict, msgBookInUse))
+	}
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgBookDeleted),
+			"result":  book,
+		})
 		return
 	}
 
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 
 func showBooks(c *gin.Context) {
 
-	if c.Keys["role"] != "librarian"{
-		c.JSON(http.StatusBadRequest, gin.H{"Недостаточно прав": ""})
-		return}
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
 
-	var book []*bookSearch
 	var params searchParams
 	var err error
 	queryOffset := c.Query("offset")
 	params.Status = c.Query("status")
 	params.OrderBy = c.Query("order")
 	params.Author = c.Query("author")
-	if queryOffset != ""{
+	if queryOffset != "" {
 		params.Offset, err = strconv.Atoi(queryOffset)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error msg": err.Error()})
-			return}
+			respondError(c, err)
+			return
+		}
 	}
+	book, err := searchBooks(params)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{"result": book, "params": params})
+		return
+	}
+	respondError(c, err)
+}
+
+// searchBooks returns a page of the catalogue.
+func searchBooks(params searchParams) ([]*bookSearch, error) {
+	var book []*bookSearch
 	mainQueryBody := "SELECT book_id,release_date,current_reader,name AS book, genre,author_name AS author, image_filepath FROM book INNER JOIN genre ON genre.genre_id = book.genre_id INNER JOIN author ON author.author_id = book.author_id"
 	queryEnd := " LIMIT 20 OFFSET (?offset)"
 
@@ -914,311 +1006,532 @@ func showBooks(c *gin.Context) {
 		mainQueryBody += " ORDER BY author"
 	}
 	mainQueryBody += queryEnd
-	fmt.Println(mainQueryBody)
-	fmt.Println(params.Author)
-	_, err = db.Query(&book, mainQueryBody, params)
-	if err == nil {
-		c.JSON(http.StatusOK, gin.H{"result": book, "params": params})
-		return
-	}
-	c.JSON(http.StatusBadRequest, gin.H{
-		"error msg": err.Error(),
-	})
-	return
+	_, err := db.Query(&book, mainQueryBody, params)
+	for _, b := range book {
+		b.ImageVariants = coverVariants(b.ImageFilepath)
 	}
+	return book, err
+}
 
 func createBook(c *gin.Context) {
 	var bookAndFiles struct {
-		book Book
-		bookFile  *multipart.FileHeader
+		book      Book
+		bookFiles []*multipart.FileHeader
 		bookImage *multipart.FileHeader
 	}
 
-	err := c.Bind(&bookAndFiles.book)
+	// one file per format plus the cover and multipart overhead
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBookSize*int64(len(bookFormats))+maxImageSize+1<<20)
+	err := bindValid(c, &bookAndFiles.book)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
-	fmt.Println(&bookAndFiles)
-	bookAndFiles.bookFile, err = c.FormFile("book")
+	form, err := c.MultipartForm()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
+	bookAndFiles.bookFiles = form.File["book"]
+	if len(bookAndFiles.bookFiles) == 0 {
+		respondError(c, http.ErrMissingFile)
+		return
+	}
+	formats := make([]bookFormat, len(bookAndFiles.bookFiles))
+	var meta *ebookMeta
+	for i, fh := range bookAndFiles.bookFiles {
+		formats[i], err = detectBookFormat(fh)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		for _, prev := range formats[:i] {
+			if prev.Name == formats[i].Name {
+				respondError(c, invalidFile(msgInvalidBookFile, errors.New("duplicate "+prev.Name+" file")))
+				return
+			}
+		}
+		if meta == nil {
+			// metadata only fills in the form, a file it can't be read
+			// from is still a book
+			meta, err = readEbookMeta(fh, formats[i])
+			if err != nil {
+				log.Printf("metadata of %s: %v", fh.Filename, err)
+				meta = nil
+			}
+		}
+	}
+	var filePathForImage string
+	var files []BookFile
+	// the book files and cover written before a failure are removed again,
+	// the authors added by prefillBook go with the transaction
+	var written []string
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		if err := prefillBook(tx, &bookAndFiles.book, meta); err != nil {
+			return err
+		}
+		// the metadata may fill in what the form left out
+		if err := validate(&bookAndFiles.book, "Name", "AuthorId", "ReleaseDate"); err != nil {
+			return err
+		}
 
-	filePathForBook := "nginx-1.21.1/resources/books/" + bookAndFiles.bookFile.Filename
-	bookAndFiles.bookImage, err = c.FormFile("image")
+		var err error
+		bookAndFiles.bookImage, err = c.FormFile("image")
+		switch {
+		case err == nil:
+			if _, err := checkUpload(bookAndFiles.bookImage, maxImageSize, coverTypes); err != nil {
+				return err
+			}
+			filePathForImage, err = saveCover(bookAndFiles.bookImage)
+		case meta != nil && meta.Cover != nil:
+			// no cover uploaded, use the one embedded in the e-book
+			base := filepath.Base(bookAndFiles.bookFiles[0].Filename)
+			filePathForImage, err = writeCover(meta.Cover, strings.TrimSuffix(base, filepath.Ext(base)))
+		case err == http.ErrMissingFile:
+			// the cover is optional
+			err = nil
+		}
+		if err != nil {
+			return invalidFile(msgInvalidCoverFile, err)
+		}
+
+		files = make([]BookFile, len(bookAndFiles.bookFiles))
+		for i, fh := range bookAndFiles.bookFiles {
+			name, err := uniqueName(fh.Filename)
+			if err != nil {
+				return err
+			}
+			files[i] = BookFile{Format: formats[i].Name, Filepath: booksDir + name}
+			written = append(written, files[i].Filepath)
+			if err := c.SaveUploadedFile(fh, files[i].Filepath); err != nil {
+				return err
+			}
+		}
+		bookAndFiles.book.BookFilepath = files[0].Filepath
+		bookAndFiles.book.ImageFilepath = filePathForImage
+		_, err = tx.QueryOne(&bookAndFiles.book, `
+		INSERT INTO book (name,author_id,genre_id,release_date,book_filepath,image_filepath,language,page_count,isbn) 
+VALUES (?name,?author_id,?genre_id,?release_date,?book_filepath,NULLIF(?image_filepath, ''),NULLIF(?language, ''),NULLIF(?page_count, 0),NULLIF(?isbn, '')) RETURNING book_id`, bookAndFiles.book)
+		if err != nil {
+			return err
+		}
+		return insertBookFiles(tx, bookAndFiles.book.BookId, files)
+	})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": err.Error(),
+		removeFiles(written)
+		removeCover(filePathForImage)
+	}
+	if err == nil {
+		c.Header("Location", bookResource.location(bookAndFiles.book.BookId))
+		c.JSON(http.StatusCreated, gin.H{
+			"message":        tr(c, msgBookCreated),
+			"result":         bookAndFiles.book,
+			"files":          files,
+			"image_variants": coverVariants(filePathForImage),
 		})
 		return
 	}
+	respondError(c, err)
+}
 
-	filePathForImage := "nginx-1.21.1/resources/images/" + bookAndFiles.bookImage.Filename
+// prefillBook copies e-book metadata into the fields the librarian left
+// empty. Unknown authors are added to the catalogue.
+func prefillBook(tx orm.DB, book *Book, meta *ebookMeta) error {
+	if meta == nil {
+		return nil
+	}
+	if book.Name == "" {
+		book.Name = meta.Title
+	}
+	if book.Language == "" {
+		book.Language = meta.Language
+	}
+	if book.ReleaseDate.IsZero() {
+		book.ReleaseDate = meta.ReleaseDate
+	}
+	if book.PageCount == 0 {
+		book.PageCount = meta.PageCount
+	}
+	if book.AuthorId == 0 && len(meta.Authors) > 0 {
+		id, err := findOrCreateAuthor(tx, meta.Authors[0])
+		if err != nil {
+			return err
+		}
+		book.AuthorId = id
+	}
+	return nil
+}
 
-	if strings.ToLower(filepath.Ext(bookAndFiles.bookFile.Filename)) != ".pdf"{
-		c.JSON(http.StatusBadRequest, gin.H{
-			"Неверный формат файла книги": "",
-		})
+func insertBookFiles(tx *pg.Tx, bookId int64, files []BookFile) error {
+	for i := range files {
+		files[i].BookId = bookId
+		_, err := tx.QueryOne(&files[i], `INSERT INTO book_files (book_id, format, filepath) VALUES (?book_id, ?format, ?filepath) 
+ON CONFLICT (book_id, format) DO UPDATE SET filepath = EXCLUDED.filepath RETURNING id`, &files[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addBookFile attaches another format to an existing book.
+func addBookFile(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
 		return
 	}
-	if strings.ToLower(filepath.Ext(bookAndFiles.bookImage.Filename)) !=".jpg"{
-		c.JSON(http.StatusBadRequest, gin.H{
-			"Неверный формат файла обложки книги": "",
-		})
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBookSize+1<<20)
+	bookId, err := pathId(c)
+	if c.Param("id") == "" {
+		bookId, err = strconv.ParseInt(c.PostForm("BookId"), 10, 64)
+	}
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	if err := c.SaveUploadedFile(bookAndFiles.bookImage, filePathForImage); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"message":err.Error(),
-		})
+	fh, err := c.FormFile("book")
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-
-	if err := c.SaveUploadedFile(bookAndFiles.bookFile, filePathForBook); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-			"message":err.Error(),
-		})
+	format, err := detectBookFormat(fh)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	bookAndFiles.book.BookFilepath = filePathForBook
-	bookAndFiles.book.ImageFilepath = filePathForImage
-	_, err = db.QueryOne(&bookAndFiles.book, `
-		INSERT INTO book (name,author_id,genre_id,release_date,book_filepath,image_filepath) VALUES (?name,?author_id,?genre_id,?release_date,?book_filepath,?image_filepath)`, bookAndFiles.book)
-	if err == nil {
-		c.JSON(http.StatusOK, gin.H{
-			bookAndFiles.book.Name: "Книга добавлена успешно",
-			filePathForImage:filePathForBook,
-		})
+	name, err := uniqueName(fh.Filename)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	file := BookFile{Format: format.Name, Filepath: booksDir + name}
+	if err := c.SaveUploadedFile(fh, file.Filepath); err != nil {
+		respondError(c, err)
 		return
 	}
-	c.JSON(http.StatusBadRequest, gin.H{
-		"error msg": err.Error(),
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		files := []BookFile{file}
+		if err := insertBookFiles(tx, bookId, files); err != nil {
+			return err
+		}
+		file = files[0]
+		return nil
 	})
+	if err == nil {
+		c.Header("Location", bookResource.location(bookId))
+		c.JSON(http.StatusCreated, file)
+		return
+	}
+	removeFiles([]string{file.Filepath})
+	respondError(c, err)
+}
+
+// draftBook reads the metadata of an uploaded book file without saving
+// anything, so the librarian can review the suggestions before createBook.
+func draftBook(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBookSize+1<<20)
+	fh, err := c.FormFile("book")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	format, err := detectBookFormat(fh)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	meta, err := readEbookMeta(fh, format)
+	if err != nil {
+		respondError(c, invalidFile(msgInvalidBookFile, err))
+		return
+	}
+	draft := gin.H{
+		"format":     format.Name,
+		"name":       meta.Title,
+		"authors":    meta.Authors,
+		"language":   meta.Language,
+		"page_count": meta.PageCount,
+	}
+	if !meta.ReleaseDate.IsZero() {
+		draft["release_date"] = meta.ReleaseDate.Format("2006-01-02")
+	}
+	if len(meta.Authors) > 0 {
+		var authorId int64
+		_, err := db.QueryOne(&authorId, `SELECT author_id FROM author WHERE author_name = ?`, meta.Authors[0])
+		if err == nil {
+			draft["author_id"] = authorId
+		}
+	}
+	if meta.Cover != nil {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resizeToWidth(meta.Cover, 300), nil); err == nil {
+			draft["cover"] = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+	}
+	c.JSON(http.StatusOK, draft)
 }
 
 func updateReader(c *gin.Context) {
-	var reader *Reader
-	err := c.Bind(&reader)
+	reader := new(Reader)
+	err := bindValid(c, reader, "ReaderId", "Name")
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error msg": err.Error(),
-		})
+		respondError(c, err)
+		return
+	}
+	if reader.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
+		return
+	}
+	_, err = db.QueryOne(reader, `UPDATE reader SET name = (?name), version = version + 1 WHERE reader_id = (?reader_id) AND version = COALESCE(NULLIF(?version, 0), version) RETURNING *`, reader)
+	if err == pg.ErrNoRows {
+		err = missedRow("reader", "reader_id", reader.ReaderId, reader.Version, errVersionChanged)
 	}
-	_, err = db.QueryOne(reader, `UPDATE reader SET name = (?name) WHERE reader_id = (?reader_id) RETURNING *`, reader)
 	if err == nil {
-		c.String(200, fmt.Sprint(reader.ReaderId, " ", reader.Name, " изменен успешно"))
+		setETag(c, reader.Version)
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgReaderUpdated),
+			"result":  reader,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 
 func deleteReader(c *gin.Context) {
 
-	var reader *Reader
-	err := c.Bind(&reader)
+	reader := new(Reader)
+	err := bindValid(c, reader, "ReaderId")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error msg": err.Error()})
+		respondError(c, err)
 		return
 	}
-	_, err = db.QueryOne(reader, `DELETE FROM reader r WHERE r.reader_id = ? AND NOT EXISTS 
-(SELECT 1 FROM book b WHERE r.reader_id = b.current_reader AND r.reader_id = ?) RETURNING *`, reader.ReaderId, reader.ReaderId)
+	if reader.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
+		return
+	}
+	_, err = db.QueryOne(reader, `DELETE FROM reader r WHERE r.reader_id = ?0 AND r.version = COALESCE(NULLIF(?1, 0), r.version) AND NOT EXISTS 
+(SELECT 1 FROM book b WHERE r.reader_id = b.current_reader AND r.reader_id = ?2) RETURNING *`, reader.ReaderId, reader.Version, reader.ReaderId)
+	if err == pg.ErrNoRows {
+		err = missedRow("reader", "reader_id", reader.ReaderId, reader.Version, newAPIError(http.StatusConflict, codeConflict, msgStillInUse))
+	}
 	if err == nil {
-		c.String(200, fmt.Sprint(reader.ReaderId, " ", reader.Name, " удален успешно"))
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgReaderDeleted),
+			"result":  reader,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 
 func allReaders(c *gin.Context) {
 
 	var reader []Reader
-	err := c.Bind(&reader)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error msg": err.Error()})
-		return
-	}
-	_, err = db.Query(&reader, `SELECT * FROM reader`)
+	_, err := db.Query(&reader, `SELECT * FROM reader`)
 	if err == nil {
-		c.JSON(200, gin.H{
+		c.JSON(http.StatusOK, gin.H{
 			"result": reader,
 		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 }
 
 func createReader(c *gin.Context) {
-	var reader *Reader
-	err := c.Bind(&reader)
+	reader := new(Reader)
+	err := bindValid(c, reader, "Name", "BirthDate")
 	if err != nil {
-		panic(err)
+		respondError(c, err)
+		return
 	}
 	_, err = db.QueryOne(reader, `
 		INSERT INTO reader (name,birth_date) VALUES (?name,?birth_date) RETURNING reader_id`, reader)
 	if err == nil {
-		c.String(200, fmt.Sprint(reader.ReaderId, " ", reader.Name, " добавлен успешно"))
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgReaderCreated),
+			"result":  reader,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 
 func updateGenre(c *gin.Context) {
-	var genre *Genre
-	err := c.Bind(&genre)
+	genre := new(Genre)
+	err := bindValid(c, genre, "GenreId", "Genre")
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error msg": err.Error(),
-		})
+		respondError(c, err)
+		return
+	}
+	if genre.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
+		return
+	}
+	_, err = db.QueryOne(genre, `UPDATE genre SET genre = (?genre), version = version + 1 WHERE genre_id = (?genre_id) AND version = COALESCE(NULLIF(?version, 0), version) RETURNING *`, genre)
+	if err == pg.ErrNoRows {
+		err = missedRow("genre", "genre_id", genre.GenreId, genre.Version, errVersionChanged)
 	}
-	_, err = db.QueryOne(genre, `UPDATE genre SET genre = (?genre) WHERE genre_id = (?genre_id) RETURNING *`, genre)
 	if err == nil {
-		c.String(200, fmt.Sprint(genre.GenreId, " ", genre.Genre, " изменен успешно"))
+		setETag(c, genre.Version)
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgGenreUpdated),
+			"result":  genre,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 }
 
 func deleteGenre(c *gin.Context) {
-	var genre *Genre
-	err := c.Bind(&genre)
+	genre := new(Genre)
+	err := bindValid(c, genre, "GenreId")
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error msg": err.Error(),
-		})
+		respondError(c, err)
+		return
 	}
-	_, err = db.QueryOne(genre, `DELETE FROM genre g WHERE g.genre_id = ? AND NOT EXISTS 
-(SELECT 1 FROM book b WHERE g.genre_id = b.genre_id AND g.genre_id = ?) RETURNING *`, genre.GenreId, genre.GenreId)
-	if err.Error() == "pg: no rows in result set" {
-		c.String(200, fmt.Sprint("Такого жанра не существует/Нельзя удалить жанр с существующими книгами"))
+	if genre.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
 		return
 	}
+	_, err = db.QueryOne(genre, `DELETE FROM genre g WHERE g.genre_id = ?0 AND g.version = COALESCE(NULLIF(?1, 0), g.version) AND NOT EXISTS 
+(SELECT 1 FROM book b WHERE g.genre_id = b.genre_id AND g.genre_id = ?2) RETURNING *`, genre.GenreId, genre.Version, genre.GenreId)
+	if err == pg.ErrNoRows {
+		err = missedRow("genre", "genre_id", genre.GenreId, genre.Version, newAPIError(http.StatusConflict, codeConflict, msgGenreInUse))
+	}
 	if err == nil {
-		c.String(200, fmt.Sprint(genre.GenreId, " ", genre.Genre, " удален успешно"))
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgGenreDeleted),
+			"result":  genre,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 }
 
 func createGenre(c *gin.Context) {
-	var genre *Genre
-	err := c.Bind(&genre)
+	genre := new(Genre)
+	err := bindValid(c, genre, "Genre")
 	if err != nil {
-		panic(err)
+		respondError(c, err)
+		return
 	}
 	_, err = db.QueryOne(genre, `
 		INSERT INTO genre (genre) VALUES (?) RETURNING genre_id`, genre.Genre)
 	if err == nil {
-		c.String(200, fmt.Sprint(genre.GenreId, " ", genre.Genre, " добавлен успешно"))
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgGenreCreated),
+			"result":  genre,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 
 func allGenres(c *gin.Context) {
 	var genre []Genre
-	err := c.Bind(&genre)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error msg": err.Error()})
-		return
-	}
 	string2 := "SELECT * "
 	string2 += "FROM genre"
-	_, err = db.Query(&genre, string2)
+	_, err := db.Query(&genre, string2)
 	if err == nil {
-		c.JSON(400, genre)
+		c.JSON(http.StatusOK, gin.H{
+			"result": genre,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 }
 
 func updateAuthor(c *gin.Context) {
-	var authorID *Author
-	err := c.Bind(&authorID)
+	authorID := new(Author)
+	err := bindValid(c, authorID, "AuthorId", "AuthorName")
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error msg": err.Error(),
-		})
+		respondError(c, err)
+		return
+	}
+	if authorID.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
+		return
+	}
+	_, err = db.QueryOne(authorID, `UPDATE author SET author_name = (?author_name), version = version + 1 WHERE author_id = (?author_id) AND version = COALESCE(NULLIF(?version, 0), version) RETURNING *`, authorID)
+	if err == pg.ErrNoRows {
+		err = missedRow("author", "author_id", authorID.AuthorId, authorID.Version, errVersionChanged)
 	}
-	_, err = db.QueryOne(authorID, `UPDATE author SET author_name = (?author_name) WHERE author_id = (?author_id) RETURNING *`, authorID)
 	if err == nil {
-		c.String(200, fmt.Sprint(authorID.AuthorId, " ", authorID.AuthorName, " изменен успешно"))
+		setETag(c, authorID.Version)
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgAuthorUpdated),
+			"result":  authorID,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 
 }
 func deleteAuthor(c *gin.Context) {
-	var authorID *Author
-	err := c.Bind(&authorID)
+	authorID := new(Author)
+	err := bindValid(c, authorID, "AuthorId")
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error msg": err.Error(),
-		})
+		respondError(c, err)
+		return
+	}
+	if authorID.Version, err = ifMatch(c); err != nil {
+		respondError(c, err)
+		return
+	}
+	_, err = db.QueryOne(authorID, `DELETE FROM author a WHERE a.author_id = ?0 AND a.version = COALESCE(NULLIF(?1, 0), a.version) AND NOT EXISTS 
+(SELECT 1 FROM book b WHERE a.author_id = b.author_id AND a.author_id = ?2) RETURNING *`, authorID.AuthorId, authorID.Version, authorID.AuthorId)
+	if err == pg.ErrNoRows {
+		err = missedRow("author", "author_id", authorID.AuthorId, authorID.Version, newAPIError(http.StatusConflict, codeConflict, msgStillInUse))
 	}
-	_, err = db.QueryOne(authorID, `DELETE FROM author a WHERE a.author_id = ? AND NOT EXISTS 
-(SELECT 1 FROM book b WHERE a.author_id = b.author_id AND a.author_id = ?) RETURNING *`, authorID.AuthorId, authorID.AuthorId)
 	if err == nil {
-		c.String(200, fmt.Sprint(authorID.AuthorId, " ", authorID.AuthorName, " удален успешно"))
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgAuthorDeleted),
+			"result":  authorID,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 }
 
 func createAuthor(c *gin.Context) {
 
-	var authorName *Author
-	err := c.Bind(&authorName)
+	authorName := new(Author)
+	err := bindValid(c, authorName, "AuthorName")
 	if err != nil {
-		panic(err)
+		respondError(c, err)
+		return
 	}
 	_, err = db.QueryOne(authorName, `
 		INSERT INTO author (author_name) VALUES (?author_name) RETURNING author_id`, authorName)
 	if err == nil {
-		c.String(200, fmt.Sprint(authorName.AuthorId, " ", authorName.AuthorName, " добавлен успешно"))
+		c.JSON(http.StatusOK, gin.H{
+			"message": tr(c, msgAuthorCreated),
+			"result":  authorName,
+		})
 		return
 	}
-	c.JSON(400, gin.H{
-		"error msg": err.Error(),
-	})
+	respondError(c, err)
 }
 
 func allAuthors(c *gin.Context) {
 
-	authors, err := GetUsers(db)
+	var authors []Author
+	_, err := db.Query(&authors, `SELECT * FROM author`)
 	if err != nil {
-		panic(err)
+		respondError(c, err)
+		return
 	}
-	c.String(200, fmt.Sprint(authors))
+	c.JSON(http.StatusOK, gin.H{
+		"result": authors,
+	})
 
 }
 
@@ -1234,8 +1547,16 @@ func GetUsersByIds(db *pg.DB, ids []int64) ([]string, error) {
 	return authors, err
 }
 
+func findOrCreateAuthor(db orm.DB, name string) (int64, error) {
+	var id int64
+	_, err := db.QueryOne(&id, `
+		INSERT INTO author (author_name) VALUES (?) 
+ON CONFLICT (author_name) DO UPDATE SET author_name = EXCLUDED.author_name RETURNING author_id`, name)
+	return id, err
+}
+
 func CreateUser(db *pg.DB, author *Author) error {
 	_, err := db.QueryOne(author, `
 		INSERT INTO author (author_name) VALUES (?author_name) RETURNING author_id`, author)
 	return err
-}
\ No newline at end of file
+}
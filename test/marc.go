@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"golang.org/x/text/language"
+)
+
+// MARC 21 bibliographic records, imported and exported both in the ISO 2709
+// transmission format and as MARCXML. Only UTF-8 records are read, MARC-8
+// has to be converted by the sending system.
+
+const (
+	marcType    = "application/marc"
+	marcXMLType = "application/marcxml+xml"
+
+	marcRecordEnd = 0x1d
+	marcFieldEnd  = 0x1e
+	marcDelimiter = 0x1f
+
+	maxMARCSize    = 32 << 20
+	maxMARCExport  = 1000
+	marcLeaderSize = 24
+)
+
+type marcRecord struct {
+	XMLName  xml.Name      `xml:"record"`
+	Leader   string        `xml:"leader"`
+	Controls []marcControl `xml:"controlfield"`
+	Fields   []marcField   `xml:"datafield"`
+}
+
+type marcControl struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcField struct {
+	Tag       string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Subfields []marcSubfield `xml:"subfield"`
+}
+
+type marcSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcCollection struct {
+	XMLName xml.Name     `xml:"http://www.loc.gov/MARC21/slim collection"`
+	Records []marcRecord `xml:"record"`
+}
+
+// control returns the control field with the tag, "" when there is none.
+func (rec *marcRecord) control(tag string) string {
+	for _, f := range rec.Controls {
+		if f.Tag == tag {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// subfield returns the first non-empty subfield code of the fields with the
+// first of the tags that has one.
+func (rec *marcRecord) subfield(code string, tags ...string) string {
+	for _, tag := range tags {
+		for _, f := range rec.Fields {
+			if f.Tag != tag {
+				continue
+			}
+			for _, sf := range f.Subfields {
+				if sf.Code == code && strings.TrimSpace(sf.Value) != "" {
+					return strings.TrimSpace(sf.Value)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// marcInput is one record of an upload, err is set when it couldn't be
+// decoded.
+type marcInput struct {
+	record marcRecord
+	err    error
+}
+
+// readMARC splits an ISO 2709 file into records. A broken record doesn't
+// stop the others from being read.
+func readMARC(r io.Reader) ([]marcInput, error) {
+	br := bufio.NewReader(r)
+	var inputs []marcInput
+	for {
+		data, err := br.ReadBytes(marcRecordEnd)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			rec, decodeErr := decodeMARC(data)
+			inputs = append(inputs, marcInput{rec, decodeErr})
+		}
+		if err == io.EOF {
+			return inputs, nil
+		}
+	}
+}
+
+func decodeMARC(data []byte) (marcRecord, error) {
+	var rec marcRecord
+	data = bytes.TrimSuffix(bytes.TrimLeft(data, "\r\n"), []byte{marcRecordEnd})
+	if len(data) < marcLeaderSize {
+		return rec, errors.New("record is shorter than the leader")
+	}
+	if data[9] != 'a' && !isASCII(data) {
+		return rec, errors.New("MARC-8 records are not supported, convert them to UTF-8")
+	}
+	if !utf8.Valid(data) {
+		return rec, errors.New("record is not valid UTF-8")
+	}
+	rec.Leader = string(data[:marcLeaderSize])
+	base, err := strconv.Atoi(string(data[12:17]))
+	if err != nil || base <= marcLeaderSize || base > len(data) || data[base-1] != marcFieldEnd {
+		return rec, errors.New("invalid base address of data")
+	}
+	directory := data[marcLeaderSize : base-1]
+	if len(directory)%12 != 0 {
+		return rec, errors.New("invalid directory length")
+	}
+	for i := 0; i < len(directory); i += 12 {
+		entry := directory[i : i+12]
+		tag := string(entry[:3])
+		length, err1 := strconv.Atoi(string(entry[3:7]))
+		start, err2 := strconv.Atoi(string(entry[7:12]))
+		if err1 != nil || err2 != nil || length == 0 || base+start+length > len(data) {
+			return rec, fmt.Errorf("field %s: invalid directory entry", tag)
+		}
+		value := bytes.TrimSuffix(data[base+start:base+start+length], []byte{marcFieldEnd})
+		if strings.HasPrefix(tag, "00") {
+			rec.Controls = append(rec.Controls, marcControl{Tag: tag, Value: string(value)})
+			continue
+		}
+		if len(value) < 2 {
+			return rec, fmt.Errorf("field %s: missing indicators", tag)
+		}
+		f := marcField{Tag: tag, Ind1: string(value[0]), Ind2: string(value[1])}
+		for _, sf := range bytes.Split(value[2:], []byte{marcDelimiter})[1:] {
+			if len(sf) > 0 {
+				f.Subfields = append(f.Subfields, marcSubfield{Code: string(sf[0]), Value: string(sf[1:])})
+			}
+		}
+		rec.Fields = append(rec.Fields, f)
+	}
+	return rec, nil
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// readMARCXML reads the records of a collection, or a single record. The
+// document has to be well-formed as a whole.
+func readMARCXML(r io.Reader) ([]marcInput, error) {
+	d := xml.NewDecoder(r)
+	var inputs []marcInput
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return inputs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "record" {
+			var rec marcRecord
+			if err := d.DecodeElement(&rec, &start); err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, marcInput{record: rec})
+		}
+	}
+}
+
+// encodeMARC writes the record in ISO 2709, filling in the lengths and
+// addresses of the leader.
+func encodeMARC(w io.Writer, rec *marcRecord) error {
+	var directory, body bytes.Buffer
+	add := func(tag string, value []byte) {
+		fmt.Fprintf(&directory, "%s%04d%05d", tag, len(value)+1, body.Len())
+		body.Write(value)
+		body.WriteByte(marcFieldEnd)
+	}
+	for _, f := range rec.Controls {
+		add(f.Tag, []byte(f.Value))
+	}
+	for _, f := range rec.Fields {
+		value := []byte(f.Ind1 + f.Ind2)
+		for _, sf := range f.Subfields {
+			value = append(value, marcDelimiter)
+			value = append(value, sf.Code+sf.Value...)
+		}
+		add(f.Tag, value)
+	}
+	base := marcLeaderSize + directory.Len() + 1
+	leader := []byte(fmt.Sprintf("%-24s", rec.Leader)[:marcLeaderSize])
+	copy(leader[0:5], fmt.Sprintf("%05d", base+body.Len()+1))
+	leader[9] = 'a'
+	copy(leader[10:12], "22")
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+	copy(leader[20:24], "4500")
+
+	var out bytes.Buffer
+	out.Write(leader)
+	out.Write(directory.Bytes())
+	out.WriteByte(marcFieldEnd)
+	out.Write(body.Bytes())
+	out.WriteByte(marcRecordEnd)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+var (
+	marcYear   = regexp.MustCompile(`\d{4}`)
+	marcNumber = regexp.MustCompile(`\d+`)
+)
+
+// book maps the record: title from 245, author from 100, 110 or 700, ISBN
+// from 020, year from 264 or 260 and then 008, language from 041 or 008,
+// pages from 300 and genre from 655 or 650.
+func (rec *marcRecord) book() namedBook {
+	var b namedBook
+	b.Name = trimISBD(rec.subfield("a", "245"))
+	b.Author = trimISBD(rec.subfield("a", "100", "110", "700"))
+	b.Genre = trimISBD(rec.subfield("a", "655", "650"))
+	if isbn := strings.Fields(rec.subfield("a", "020")); len(isbn) > 0 {
+		b.Isbn = isbn[0]
+	}
+	fixed := rec.control("008")
+	year := marcYear.FindString(rec.subfield("c", "264", "260"))
+	if year == "" && len(fixed) >= 11 {
+		year = marcYear.FindString(fixed[7:11])
+	}
+	if y, err := strconv.Atoi(year); err == nil {
+		b.ReleaseDate = time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	lang := rec.subfield("a", "041")
+	if lang == "" && len(fixed) >= 38 {
+		lang = fixed[35:38]
+	}
+	b.Language = languageFromMARC(lang)
+	if pages := marcNumber.FindString(rec.subfield("a", "300")); pages != "" {
+		b.PageCount, _ = strconv.Atoi(pages)
+	}
+	return b
+}
+
+// trimISBD drops the punctuation that separates the parts of a field,
+// keeping the full stop of an initial.
+func trimISBD(s string) string {
+	s = strings.TrimRight(strings.TrimSpace(s), " /:;,=")
+	if strings.HasSuffix(s, ".") {
+		word := s[strings.LastIndexAny(s, " ,")+1:]
+		if utf8.RuneCountInString(word) > 2 {
+			s = strings.TrimSuffix(s, ".")
+		}
+	}
+	return s
+}
+
+// marcLanguages maps the ISO 639-2 terminology codes to the bibliographic
+// ones MARC uses where the two differ.
+var marcLanguages = map[string]string{
+	"bod": "tib", "ces": "cze", "cym": "wel", "deu": "ger", "ell": "gre",
+	"eus": "baq", "fas": "per", "fra": "fre", "hye": "arm", "isl": "ice",
+	"kat": "geo", "mkd": "mac", "mri": "mao", "msa": "may", "mya": "bur",
+	"nld": "dut", "ron": "rum", "slk": "slo", "sqi": "alb", "zho": "chi",
+}
+
+// languageFromMARC returns the shortest code of a MARC language code, ""
+// for undetermined or unknown ones.
+func languageFromMARC(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	for t, b := range marcLanguages {
+		if code == b {
+			code = t
+		}
+	}
+	base, err := language.ParseBase(code)
+	if err != nil || base.String() == "und" {
+		return ""
+	}
+	return base.String()
+}
+
+func languageToMARC(code string) string {
+	base, err := language.ParseBase(code)
+	if err != nil || base.String() == "und" {
+		return "und"
+	}
+	if b, ok := marcLanguages[base.ISO3()]; ok {
+		return b
+	}
+	return base.ISO3()
+}
+
+// record maps the book back to MARC, as a record for a language material
+// in electronic form.
+func (b *namedBook) record() marcRecord {
+	year := b.ReleaseDate.Format("2006")
+	rec := marcRecord{
+		Leader: "00000nam a2200000 i 4500",
+		Controls: []marcControl{
+			{Tag: "001", Value: strconv.FormatInt(b.BookId, 10)},
+			{Tag: "005", Value: b.UpdatedAt.UTC().Format("20060102150405.0")},
+			{Tag: "008", Value: b.UpdatedAt.UTC().Format("060102") + "s" + year + "    xx      s           " + languageToMARC(b.Language) + " d"},
+		},
+	}
+	if b.Isbn != "" {
+		rec.Fields = append(rec.Fields, marcField{Tag: "020", Ind1: " ", Ind2: " ", Subfields: []marcSubfield{{"a", b.Isbn}}})
+	}
+	rec.Fields = append(rec.Fields,
+		marcField{Tag: "100", Ind1: "1", Ind2: " ", Subfields: []marcSubfield{{"a", b.Author}}},
+		marcField{Tag: "245", Ind1: "1", Ind2: "0", Subfields: []marcSubfield{{"a", b.Name}}},
+		marcField{Tag: "264", Ind1: " ", Ind2: "1", Subfields: []marcSubfield{{"c", year}}},
+	)
+	if b.PageCount > 0 {
+		rec.Fields = append(rec.Fields, marcField{Tag: "300", Ind1: " ", Ind2: " ", Subfields: []marcSubfield{{"a", fmt.Sprintf("%d p.", b.PageCount)}}})
+	}
+	if b.Genre != "" {
+		rec.Fields = append(rec.Fields, marcField{Tag: "655", Ind1: " ", Ind2: "7", Subfields: []marcSubfield{{"a", b.Genre}, {"2", "local"}}})
+	}
+	return rec
+}
+
+// marcResult reports the outcome of one imported record, numbered from 1.
+type marcResult struct {
+	Record        int       `json:"record"`
+	ControlNumber string    `json:"control_number,omitempty" doc:"001 of the record"`
+	BookId        int64     `json:"book_id,omitempty" doc:"Set when the book was created"`
+	Error         *apiError `json:"error,omitempty"`
+}
+
+// importMARC creates a book for every record of the body, adding the
+// authors and genres that are not in the catalogue yet. Each record is
+// imported on its own, the response lists what happened to every one.
+func importMARC(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxMARCSize)
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	var inputs []marcInput
+	var err error
+	switch mediaType {
+	case marcType:
+		inputs, err = readMARC(c.Request.Body)
+	case marcXMLType, "application/xml", "text/xml":
+		inputs, err = readMARCXML(c.Request.Body)
+		if _, ok := err.(*xml.SyntaxError); ok {
+			err = newAPIError(http.StatusBadRequest, codeBadRequest, msgMalformedRequest).withDetails(err.Error())
+		}
+	default:
+		err = newAPIError(http.StatusUnsupportedMediaType, codeUnsupportedMedia, msgUnsupportedMARCType, mediaType)
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if len(inputs) == 0 {
+		respondError(c, io.EOF)
+		return
+	}
+
+	results := make([]marcResult, len(inputs))
+	created := 0
+	for i, in := range inputs {
+		results[i] = marcResult{Record: i + 1, ControlNumber: in.record.control("001")}
+		err := in.err
+		if err != nil {
+			err = invalidFile(msgInvalidMARCRecord, err)
+		} else {
+			results[i].BookId, err = importMARCRecord(&in.record)
+		}
+		if err != nil {
+			results[i].Error = localizedError(c, err)
+			continue
+		}
+		created++
+	}
+	c.JSON(http.StatusOK, gin.H{"message": tr(c, msgMARCImported, created, len(inputs)), "records": results})
+}
+
+func importMARCRecord(rec *marcRecord) (int64, error) {
+	b := rec.book()
+	if err := validate(&b, "Name", "Author", "ReleaseDate"); err != nil {
+		return 0, err
+	}
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		var err error
+		if b.AuthorId, err = findOrCreateAuthor(tx, b.Author); err != nil {
+			return err
+		}
+		if b.Genre != "" {
+			if b.GenreId, err = findOrCreateGenre(tx, b.Genre); err != nil {
+				return err
+			}
+		}
+		_, err = tx.QueryOne(&b.Book, `
+		INSERT INTO book (name,author_id,genre_id,release_date,language,page_count,isbn)
+VALUES (?name,?author_id,?genre_id,?release_date,NULLIF(?language, ''),NULLIF(?page_count, 0),NULLIF(?isbn, '')) RETURNING book_id`, b.Book)
+		return err
+	})
+	return b.BookId, err
+}
+
+func findOrCreateGenre(db orm.DB, name string) (int64, error) {
+	var id int64
+	_, err := db.QueryOne(&id, `
+		INSERT INTO genre (genre) VALUES (?)
+ON CONFLICT (genre) DO UPDATE SET genre = EXCLUDED.genre RETURNING genre_id`, name)
+	return id, err
+}
+
+// exportMARC sends the books listed in ?ids= as MARC, binary unless
+// ?format=marcxml or the Accept header asks for MARCXML.
+func exportMARC(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	var ids []int64
+	for _, s := range strings.Split(c.Query("ids"), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	switch {
+	case len(ids) == 0:
+		respondError(c, validationError([]fieldError{{Field: "ids", Rule: "required"}}))
+		return
+	case len(ids) > maxMARCExport:
+		respondError(c, validationError([]fieldError{{Field: "ids", Rule: "max", Param: strconv.Itoa(maxMARCExport)}}))
+		return
+	}
+
+	var contentType string
+	switch c.Query("format") {
+	case "marc":
+		contentType = marcType
+	case "marcxml":
+		contentType = marcXMLType
+	case "":
+		contentType = c.NegotiateFormat(marcType, marcXMLType)
+	}
+	if contentType == "" {
+		respondError(c, errNotAcceptable)
+		return
+	}
+
+	var books []namedBook
+	_, err := db.Query(&books, `
+		SELECT b.*, a.author_name AS author, g.genre AS genre FROM book b
+JOIN author a ON a.author_id = b.author_id LEFT JOIN genre g ON g.genre_id = b.genre_id
+WHERE b.book_id IN (?) ORDER BY b.book_id`, pg.In(ids))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if len(books) == 0 {
+		respondError(c, errNotFound)
+		return
+	}
+
+	var out bytes.Buffer
+	if contentType == marcType {
+		for i := range books {
+			rec := books[i].record()
+			if err := encodeMARC(&out, &rec); err != nil {
+				respondError(c, err)
+				return
+			}
+		}
+	} else {
+		collection := marcCollection{Records: make([]marcRecord, len(books))}
+		for i := range books {
+			collection.Records[i] = books[i].record()
+		}
+		out.WriteString(xml.Header)
+		if err := xml.NewEncoder(&out).Encode(collection); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+	name := "books.mrc"
+	if contentType == marcXMLType {
+		name = "books.xml"
+	}
+	c.Header("Content-Disposition", contentDisposition(name))
+	c.Data(http.StatusOK, contentType, out.Bytes())
+}
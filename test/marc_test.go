@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMARCRoundTrip(t *testing.T) {
+	rec := marcRecord{
+		Leader:   "00000nam a2200000 a 4500",
+		Controls: []marcControl{{Tag: "001", Value: "b42"}, {Tag: "008", Value: "261019s2004    ru            000 0 rus d"}},
+		Fields: []marcField{
+			{Tag: "020", Ind1: " ", Ind2: " ", Subfields: []marcSubfield{{Code: "a", Value: "9783161484100"}}},
+			{Tag: "100", Ind1: "1", Ind2: " ", Subfields: []marcSubfield{{Code: "a", Value: "Толстой, Лев"}}},
+			{Tag: "245", Ind1: "1", Ind2: "0", Subfields: []marcSubfield{{Code: "a", Value: "Война и мир /"}, {Code: "c", Value: "Л. Толстой"}}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := encodeMARC(&buf, &rec); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if data[len(data)-1] != marcRecordEnd {
+		t.Fatal("record does not end with the record terminator")
+	}
+	got, err := decodeMARC(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Controls, rec.Controls) || !reflect.DeepEqual(got.Fields, rec.Fields) {
+		t.Errorf("decodeMARC(encodeMARC(rec)) = %+v, want %+v", got, rec)
+	}
+	var again bytes.Buffer
+	if err := encodeMARC(&again, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), data) {
+		t.Errorf("encoding the decoded record changed it:\n%q\n%q", again.Bytes(), data)
+	}
+}
+
+func TestDecodeMARCErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeMARC(&buf, &marcRecord{Leader: "00000nam a2200000 a 4500",
+		Fields: []marcField{{Tag: "245", Ind1: "1", Ind2: "0", Subfields: []marcSubfield{{Code: "a", Value: "Title"}}}}}); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	badBase := append([]byte(nil), valid...)
+	copy(badBase[12:17], "99999")
+	marc8 := append([]byte(nil), valid...)
+	marc8[9] = ' '
+	marc8 = append(marc8[:len(marc8)-1], 0xE1, marcRecordEnd)
+	for name, data := range map[string][]byte{
+		"short":        []byte("00012nam"),
+		"base address": badBase,
+		"MARC-8":       marc8,
+	} {
+		if _, err := decodeMARC(data); err == nil {
+			t.Errorf("%s: decodeMARC succeeded", name)
+		}
+	}
+}
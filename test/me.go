@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+)
+
+// The /me routes show the authenticated reader their own loans, history,
+// holds and fines.
+
+// finePerDay is charged for every day a book is overdue, in the smallest
+// unit of the currency.
+var finePerDay = envInt64("FINE_PER_DAY", 10)
+
+type Hold struct {
+	HoldId    int64     `pg:"hold_id"`
+	BookId    int64     `pg:"book_id"`
+	ReaderId  int64     `pg:"reader_id"`
+	CreatedAt time.Time `pg:"created_at" doc:"Set by the server"`
+}
+
+// readerHold is a hold with the book's name and the reader's place in the
+// queue.
+type readerHold struct {
+	Hold
+	Book      string
+	Position  int  `doc:"1 when the reader is next in line"`
+	Available bool `doc:"The book is not rented at the moment"`
+}
+
+// loan is an open rental.
+type loan struct {
+	namedRental
+	Overdue     bool
+	DownloadUrl string `pg:"-" doc:"Redirects to a new load token while the loan is open, ?format= picks the format"`
+}
+
+type fine struct {
+	namedRental
+	DaysOverdue int
+	Amount      int64 `pg:"-" doc:"FINE_PER_DAY for every day, in the smallest currency unit"`
+}
+
+func registerMe(g *gin.RouterGroup) {
+	g.Use(requireReader)
+	g.GET("loans", myLoans)
+	g.GET("loans/:id/download", downloadLoan)
+	g.GET("history", myHistory)
+	g.GET("holds", myHolds)
+	g.POST("holds", placeHold)
+	g.DELETE("holds/:id", cancelHold)
+	g.GET("fines", myFines)
+}
+
+func myLoans(c *gin.Context) {
+	loans := []loan{}
+	_, err := db.Query(&loans, `SELECT r.*, b.name AS book, rd.name AS reader, r.due_date < CURRENT_TIMESTAMP AS overdue
+FROM `+rentalsFrom+` WHERE r.reader_id = ? AND r.return_date IS NULL ORDER BY r.due_date`, c.GetInt64("reader_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	for i := range loans {
+		loans[i].DownloadUrl = fmt.Sprintf("%s/me/loans/%d/download", apiV1, loans[i].RentalId)
+	}
+	c.JSON(http.StatusOK, gin.H{"result": loans})
+}
+
+// downloadLoan issues a load token for the book of an open loan of the
+// reader and redirects to it, so the download is single-use and expires
+// like any other. The loan is not renewed.
+func downloadLoan(c *gin.Context) {
+	id, err := pathId(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	var rental RentalHistory
+	_, err = db.QueryOne(&rental, `SELECT * FROM rental_history WHERE rental_id = ? AND reader_id = ? AND return_date IS NULL`,
+		id, c.GetInt64("reader_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	token := &bookTokens{BookId: rental.BookId, ReaderId: rental.ReaderId}
+	if err := issueBookToken(token); err != nil {
+		respondError(c, err)
+		return
+	}
+	location := apiV1 + "/load-book/" + url.PathEscape(token.Token)
+	if query := c.Request.URL.RawQuery; query != "" {
+		location += "?" + query
+	}
+	c.Redirect(http.StatusSeeOther, location)
+}
+
+// myHistory is the rental history list restricted to the reader.
+func myHistory(c *gin.Context) {
+	var q rentalQuery
+	if err := bindValid(c, &q); err != nil {
+		respondError(c, err)
+		return
+	}
+	q.ReaderId = c.GetInt64("reader_id")
+	if q.Limit == 0 {
+		q.Limit = 20
+	}
+	order, ok := rentalSorts[q.Sort]
+	if !ok {
+		order = rentalSorts["-rental_date"]
+	}
+	where, params := q.where()
+	rentals := []namedRental{}
+	total, err := queryPage(&rentals, "r.*, b.name AS book, rd.name AS reader", rentalsFrom, where, params, order, q.Limit, q.Offset)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": rentals, "total": total})
+}
+
+func myHolds(c *gin.Context) {
+	holds := []readerHold{}
+	_, err := db.Query(&holds, `SELECT h.*, b.name AS book, b.current_reader IS NULL AS available,
+(SELECT count(*) FROM holds q WHERE q.book_id = h.book_id AND (q.created_at, q.hold_id) <= (h.created_at, h.hold_id)) AS position
+FROM holds h JOIN book b ON b.book_id = h.book_id WHERE h.reader_id = ? ORDER BY h.created_at`, c.GetInt64("reader_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": holds})
+}
+
+// placeHold queues the reader for a book, readers can't hold a book they
+// have.
+func placeHold(c *gin.Context) {
+	hold := new(Hold)
+	if err := bindValid(c, hold, "BookId"); err != nil {
+		respondError(c, err)
+		return
+	}
+	hold.ReaderId = c.GetInt64("reader_id")
+	_, err := db.QueryOne(hold, `INSERT INTO holds (book_id, reader_id)
+SELECT ?book_id, ?reader_id WHERE NOT EXISTS (SELECT 1 FROM book WHERE book_id = ?book_id AND current_reader = ?reader_id)
+RETURNING *`, hold)
+	if err == pg.ErrNoRows {
+		err = newAPIError(http.StatusConflict, codeConflict, msgHoldOwnLoan)
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondCreated(c, fmt.Sprintf("%s/me/holds/%d", apiV1, hold.HoldId), msgHoldPlaced, hold)
+}
+
+func cancelHold(c *gin.Context) {
+	id, err := pathId(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	res, err := db.Exec(`DELETE FROM holds WHERE hold_id = ? AND reader_id = ?`, id, c.GetInt64("reader_id"))
+	if err == nil && res.RowsAffected() == 0 {
+		err = errNotFound
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// myFines lists the rentals that were or are overdue. A book still out is
+// charged up to now.
+func myFines(c *gin.Context) {
+	fines := []fine{}
+	_, err := db.Query(&fines, `SELECT r.*, b.name AS book, rd.name AS reader,
+ceil(extract(epoch FROM coalesce(r.return_date, CURRENT_TIMESTAMP) - r.due_date) / 86400)::int AS days_overdue
+FROM `+rentalsFrom+` WHERE r.reader_id = ? AND coalesce(r.return_date, CURRENT_TIMESTAMP) > r.due_date
+ORDER BY r.due_date`, c.GetInt64("reader_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	var total int64
+	for i := range fines {
+		fines[i].Amount = int64(fines[i].DaysOverdue) * finePerDay
+		total += fines[i].Amount
+	}
+	c.JSON(http.StatusOK, gin.H{"result": fines, "total": total})
+}
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS book_load_tokens_expires_at_idx;
+DROP INDEX IF EXISTS book_load_tokens_token_idx;
+
+ALTER TABLE book_load_tokens
+    DROP COLUMN revoked_at,
+    DROP COLUMN used_at,
+    DROP COLUMN expires_at,
+    DROP COLUMN reader_id;
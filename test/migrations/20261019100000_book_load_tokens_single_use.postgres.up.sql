@@ -0,0 +1,11 @@
+ALTER TABLE book_load_tokens
+    ADD COLUMN reader_id INT,
+    ADD COLUMN expires_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP + interval '1 hour',
+    ADD COLUMN used_at timestamptz,
+    ADD COLUMN revoked_at timestamptz,
+    ADD FOREIGN KEY (reader_id) REFERENCES reader (reader_id) ON DELETE CASCADE;
+
+UPDATE book_load_tokens SET expires_at = created_at + interval '1 hour';
+
+CREATE UNIQUE INDEX IF NOT EXISTS book_load_tokens_token_idx ON book_load_tokens (token);
+CREATE INDEX IF NOT EXISTS book_load_tokens_expires_at_idx ON book_load_tokens (expires_at);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS book_files (
+                                        id serial PRIMARY KEY,
+                                        book_id INT NOT NULL,
+                                        format VARCHAR (10) NOT NULL,
+                                        filepath VARCHAR (255) NOT NULL,
+                                        UNIQUE (book_id, format),
+                                        FOREIGN KEY (book_id) REFERENCES book (book_id) ON DELETE CASCADE
+);
+
+INSERT INTO book_files (book_id, format, filepath)
+SELECT book_id, 'pdf', book_filepath FROM book WHERE book_filepath IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE book ADD COLUMN language VARCHAR (10);
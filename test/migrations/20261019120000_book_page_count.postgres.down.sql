@@ -0,0 +1 @@
+ALTER TABLE book DROP COLUMN page_count;
@@ -0,0 +1 @@
+ALTER TABLE book ADD COLUMN page_count INT;
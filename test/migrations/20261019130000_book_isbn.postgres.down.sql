@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS book_isbn_key;
+ALTER TABLE book DROP COLUMN isbn;
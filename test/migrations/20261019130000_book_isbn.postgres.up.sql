@@ -0,0 +1,2 @@
+ALTER TABLE book ADD COLUMN isbn VARCHAR (17);
+CREATE UNIQUE INDEX book_isbn_key ON book (regexp_replace(isbn, '[- ]', '', 'g'));
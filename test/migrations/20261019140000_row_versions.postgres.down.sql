@@ -0,0 +1,6 @@
+ALTER TABLE roles DROP COLUMN version;
+ALTER TABLE users DROP COLUMN version;
+ALTER TABLE book DROP COLUMN version;
+ALTER TABLE reader DROP COLUMN version;
+ALTER TABLE genre DROP COLUMN version;
+ALTER TABLE author DROP COLUMN version;
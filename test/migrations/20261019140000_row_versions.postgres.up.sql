@@ -0,0 +1,6 @@
+ALTER TABLE author ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE genre ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE reader ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE book ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE roles ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
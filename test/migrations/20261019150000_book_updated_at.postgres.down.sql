@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS book_files_touch_book ON book_files;
+DROP FUNCTION IF EXISTS book_files_touch_book();
+DROP TRIGGER IF EXISTS genre_touch_books ON genre;
+DROP FUNCTION IF EXISTS genre_touch_books();
+DROP TRIGGER IF EXISTS author_touch_books ON author;
+DROP FUNCTION IF EXISTS author_touch_books();
+DROP TRIGGER IF EXISTS book_touch ON book;
+DROP FUNCTION IF EXISTS book_touch();
+
+ALTER TABLE book DROP COLUMN updated_at;
@@ -0,0 +1,53 @@
+ALTER TABLE book ADD COLUMN updated_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS book_updated_at_idx ON book (updated_at, book_id);
+
+-- updated_at is the OAI-PMH datestamp of the book. It moves whenever
+-- something its record shows changes, rentals don't count.
+CREATE OR REPLACE FUNCTION book_touch() RETURNS trigger AS $$
+BEGIN
+    IF (NEW.name, NEW.author_id, NEW.genre_id, NEW.release_date, NEW.language, NEW.isbn)
+        IS DISTINCT FROM (OLD.name, OLD.author_id, OLD.genre_id, OLD.release_date, OLD.language, OLD.isbn) THEN
+        NEW.updated_at := CURRENT_TIMESTAMP;
+    END IF;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_touch BEFORE UPDATE ON book
+    FOR EACH ROW EXECUTE PROCEDURE book_touch();
+
+CREATE OR REPLACE FUNCTION author_touch_books() RETURNS trigger AS $$
+BEGIN
+    UPDATE book SET updated_at = CURRENT_TIMESTAMP WHERE author_id = NEW.author_id;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER author_touch_books AFTER UPDATE OF author_name ON author
+    FOR EACH ROW WHEN (OLD.author_name IS DISTINCT FROM NEW.author_name) EXECUTE PROCEDURE author_touch_books();
+
+CREATE OR REPLACE FUNCTION genre_touch_books() RETURNS trigger AS $$
+BEGIN
+    UPDATE book SET updated_at = CURRENT_TIMESTAMP WHERE genre_id = NEW.genre_id;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER genre_touch_books AFTER UPDATE OF genre ON genre
+    FOR EACH ROW WHEN (OLD.genre IS DISTINCT FROM NEW.genre) EXECUTE PROCEDURE genre_touch_books();
+
+-- the formats of a book are part of its record too
+CREATE OR REPLACE FUNCTION book_files_touch_book() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE book SET updated_at = CURRENT_TIMESTAMP WHERE book_id = OLD.book_id;
+    ELSE
+        UPDATE book SET updated_at = CURRENT_TIMESTAMP WHERE book_id = NEW.book_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_files_touch_book AFTER INSERT OR DELETE ON book_files
+    FOR EACH ROW EXECUTE PROCEDURE book_files_touch_book();
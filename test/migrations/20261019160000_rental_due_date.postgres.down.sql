@@ -0,0 +1 @@
+ALTER TABLE rental_history DROP COLUMN due_date;
@@ -0,0 +1,4 @@
+ALTER TABLE rental_history ADD COLUMN due_date TIMESTAMP;
+UPDATE rental_history SET due_date = rental_date + INTERVAL '14 days';
+ALTER TABLE rental_history ALTER COLUMN due_date SET NOT NULL;
+ALTER TABLE rental_history ALTER COLUMN due_date SET DEFAULT CURRENT_TIMESTAMP + INTERVAL '14 days';
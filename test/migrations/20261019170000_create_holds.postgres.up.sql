@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS holds (
+                                   hold_id serial PRIMARY KEY,
+                                   book_id INT NOT NULL,
+                                   reader_id INT NOT NULL,
+                                   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                   UNIQUE (book_id, reader_id),
+                                   FOREIGN KEY (book_id) REFERENCES book (book_id) ON DELETE CASCADE,
+                                   FOREIGN KEY (reader_id) REFERENCES reader (reader_id) ON DELETE CASCADE
+);
+
+CREATE INDEX holds_reader_id_idx ON holds (reader_id);
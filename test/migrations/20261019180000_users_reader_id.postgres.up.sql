@@ -0,0 +1,3 @@
+-- Users borrow as the reader they are linked to. Existing users stay
+-- unlinked until a librarian links them: their ids never matched readers.
+ALTER TABLE users ADD COLUMN reader_id INT UNIQUE REFERENCES reader (reader_id) ON DELETE SET NULL;
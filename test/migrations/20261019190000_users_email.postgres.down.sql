@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN verified_at;
+ALTER TABLE users DROP COLUMN active;
+ALTER TABLE users DROP COLUMN email;
@@ -0,0 +1,5 @@
+-- Accounts created by sign-up stay inactive until the email is verified,
+-- the existing ones are active.
+ALTER TABLE users ADD COLUMN email VARCHAR ( 254 ) UNIQUE;
+ALTER TABLE users ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ADD COLUMN verified_at TIMESTAMP;
@@ -0,0 +1,2 @@
+-- Hashes can't be turned back into passwords, users keep them.
+DROP TABLE IF EXISTS password_resets;
@@ -0,0 +1,16 @@
+-- Passwords are stored as bcrypt hashes from now on, pgcrypto hashes the
+-- existing ones in the same format.
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_password_key;
+ALTER TABLE users ALTER COLUMN password TYPE VARCHAR ( 60 );
+UPDATE users SET password = crypt(password, gen_salt('bf', 10));
+
+CREATE TABLE IF NOT EXISTS password_resets (
+                                   id serial PRIMARY KEY,
+                                   user_id INT NOT NULL,
+                                   token_hash VARCHAR ( 64 ) UNIQUE NOT NULL,
+                                   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                   expires_at TIMESTAMP NOT NULL,
+                                   used_at TIMESTAMP,
+                                   FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
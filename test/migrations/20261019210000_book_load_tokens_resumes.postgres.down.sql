@@ -0,0 +1 @@
+ALTER TABLE book_load_tokens DROP COLUMN resumes;
@@ -0,0 +1 @@
+ALTER TABLE book_load_tokens ADD COLUMN resumes INT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS book_files_touch_book ON book_files;
+CREATE TRIGGER book_files_touch_book AFTER INSERT OR DELETE ON book_files
+    FOR EACH ROW EXECUTE PROCEDURE book_files_touch_book();
@@ -0,0 +1,5 @@
+-- replacing the file of a format updates its row, which changes the record
+-- as much as adding one
+DROP TRIGGER IF EXISTS book_files_touch_book ON book_files;
+CREATE TRIGGER book_files_touch_book AFTER INSERT OR UPDATE OR DELETE ON book_files
+    FOR EACH ROW EXECUTE PROCEDURE book_files_touch_book();
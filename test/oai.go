@@ -0,0 +1,570 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+)
+
+// OAI-PMH 2.0 lets library consortia harvest the catalogue. Every book is a
+// record in Dublin Core (oai_dc), its datestamp is book.updated_at and its
+// set is its genre. Deleted books are not tracked, a harvester notices them
+// only by harvesting the whole list again.
+
+const (
+	oaiPageSize   = 100
+	oaiDayFormat  = "2006-01-02"
+	oaiTimeFormat = "2006-01-02T15:04:05Z"
+	oaiDCPrefix   = "oai_dc"
+	oaiSetPrefix  = "genre-"
+)
+
+var (
+	oaiRepositoryName = envString("OAI_REPOSITORY_NAME", "Library")
+	oaiRepositoryId   = envString("OAI_REPOSITORY_ID", "library.local")
+	oaiAdminEmail     = envString("OAI_ADMIN_EMAIL", "librarian@library.local")
+)
+
+// oaiError is reported inside a normal response, the way OAI-PMH wants
+// them, with a code a harvester can act on.
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+	key     string
+	args    []interface{}
+}
+
+func (e *oaiError) Error() string {
+	return e.Code
+}
+
+func newOAIError(code, key string, args ...interface{}) *oaiError {
+	return &oaiError{Code: code, key: key, args: args}
+}
+
+// oaiVerbs are the requests of the protocol with the arguments they take.
+// An exclusive argument can't be combined with any other.
+var oaiVerbs = map[string]struct {
+	required, optional []string
+	exclusive          string
+	serve              func(args url.Values, resp *oaiResponse) error
+}{
+	"Identify":            {serve: oaiIdentify},
+	"ListMetadataFormats": {optional: []string{"identifier"}, serve: oaiListMetadataFormats},
+	"ListSets":            {exclusive: "resumptionToken", serve: oaiListSets},
+	"GetRecord":           {required: []string{"identifier", "metadataPrefix"}, serve: oaiGetRecord},
+	"ListIdentifiers":     {required: []string{"metadataPrefix"}, optional: []string{"from", "until", "set"}, exclusive: "resumptionToken", serve: oaiListIdentifiers},
+	"ListRecords":         {required: []string{"metadataPrefix"}, optional: []string{"from", "until", "set"}, exclusive: "resumptionToken", serve: oaiListRecords},
+}
+
+func oaiOperations() []operation {
+	query := []param{
+		{"verb", "string", "Identify, ListMetadataFormats, ListSets, GetRecord, ListIdentifiers or ListRecords"},
+		{"identifier", "string", "oai:<repository>:book:<id>"},
+		{"metadataPrefix", "string", "oai_dc"},
+		{"from", "string", "YYYY-MM-DD or YYYY-MM-DDThh:mm:ssZ"},
+		{"until", "string", "YYYY-MM-DD or YYYY-MM-DDThh:mm:ssZ, inclusive"},
+		{"set", "string", "genre-<id>"},
+		{"resumptionToken", "string", "From the previous page of a list"},
+	}
+	return []operation{
+		{Method: "GET", Path: apiV1 + "/oai", Summary: "OAI-PMH 2.0 harvesting of the catalogue", Public: true, Query: query, Produces: []string{"text/xml"}},
+		{Method: "POST", Path: apiV1 + "/oai", Summary: "OAI-PMH 2.0 harvesting, arguments form-encoded in the body", Public: true, Produces: []string{"text/xml"}},
+	}
+}
+
+// serveOAI answers both GET and form-encoded POST requests. It is public,
+// harvesters don't log in.
+func serveOAI(c *gin.Context) {
+	resp := &oaiResponse{
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd",
+		ResponseDate:   time.Now().UTC().Format(oaiTimeFormat),
+		Request:        oaiRequest{URL: externalURL(c, apiV1+"/oai")},
+	}
+	err := c.Request.ParseForm()
+	if err != nil {
+		err = newOAIError("badArgument", msgOAIIllegalArgument, err.Error())
+	} else {
+		err = serveOAIVerb(c.Request.Form, resp)
+	}
+	if e, ok := err.(*oaiError); ok {
+		e.Message = tr(c, e.key, e.args...)
+		resp.Errors = append(resp.Errors, e)
+		if e.Code == "badVerb" || e.Code == "badArgument" {
+			// the request is echoed only when it was understood
+			resp.Request = oaiRequest{URL: resp.Request.URL}
+		}
+	} else if err != nil {
+		respondError(c, err)
+		return
+	}
+	body, err := xml.Marshal(resp)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "text/xml;charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+func serveOAIVerb(args url.Values, resp *oaiResponse) error {
+	verb, ok := oaiVerbs[args.Get("verb")]
+	if !ok || len(args["verb"]) != 1 {
+		return newOAIError("badVerb", msgOAIBadVerb)
+	}
+	resp.Request = oaiRequest{
+		Verb:            args.Get("verb"),
+		Identifier:      args.Get("identifier"),
+		MetadataPrefix:  args.Get("metadataPrefix"),
+		From:            args.Get("from"),
+		Until:           args.Get("until"),
+		Set:             args.Get("set"),
+		ResumptionToken: args.Get("resumptionToken"),
+		URL:             resp.Request.URL,
+	}
+	if _, ok := args[verb.exclusive]; ok && verb.exclusive != "" {
+		if len(args) != 2 {
+			return newOAIError("badArgument", msgOAIExclusiveArgument, verb.exclusive)
+		}
+		if len(args[verb.exclusive]) != 1 {
+			return newOAIError("badArgument", msgOAIRepeatedArgument, verb.exclusive)
+		}
+		return verb.serve(args, resp)
+	}
+	allowed := map[string]bool{"verb": true}
+	for _, name := range append(verb.required, verb.optional...) {
+		allowed[name] = true
+	}
+	for name, values := range args {
+		if !allowed[name] {
+			return newOAIError("badArgument", msgOAIIllegalArgument, name)
+		}
+		if len(values) != 1 {
+			return newOAIError("badArgument", msgOAIRepeatedArgument, name)
+		}
+	}
+	for _, name := range verb.required {
+		if args.Get(name) == "" {
+			return newOAIError("badArgument", msgOAIMissingArgument, name)
+		}
+	}
+	return verb.serve(args, resp)
+}
+
+func oaiIdentify(args url.Values, resp *oaiResponse) error {
+	var earliest time.Time
+	_, err := db.QueryOne(pg.Scan(&earliest), `SELECT coalesce(min(updated_at), CURRENT_TIMESTAMP) FROM book`)
+	if err != nil {
+		return err
+	}
+	resp.Identify = &oaiIdentifyResult{
+		RepositoryName:    oaiRepositoryName,
+		BaseURL:           resp.Request.URL,
+		ProtocolVersion:   "2.0",
+		AdminEmail:        oaiAdminEmail,
+		EarliestDatestamp: earliest.UTC().Format(oaiTimeFormat),
+		DeletedRecord:     "no",
+		Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+	}
+	return nil
+}
+
+func oaiListMetadataFormats(args url.Values, resp *oaiResponse) error {
+	if id := args.Get("identifier"); id != "" {
+		if _, err := oaiFindBook(id); err != nil {
+			return err
+		}
+	}
+	resp.ListMetadataFormats = &oaiMetadataFormats{Formats: []oaiMetadataFormat{{
+		MetadataPrefix:    oaiDCPrefix,
+		Schema:            "http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		MetadataNamespace: "http://www.openarchives.org/OAI/2.0/oai_dc/",
+	}}}
+	return nil
+}
+
+func oaiListSets(args url.Values, resp *oaiResponse) error {
+	if args.Get("resumptionToken") != "" {
+		// all sets fit in one response, so no token was ever handed out
+		return newOAIError("badResumptionToken", msgOAIBadResumptionToken)
+	}
+	var genres []Genre
+	_, err := db.Query(&genres, `SELECT * FROM genre ORDER BY genre_id`)
+	if err != nil {
+		return err
+	}
+	if len(genres) == 0 {
+		return newOAIError("noSetHierarchy", msgOAINoSets)
+	}
+	resp.ListSets = &oaiSets{}
+	for _, g := range genres {
+		resp.ListSets.Sets = append(resp.ListSets.Sets, oaiSet{Spec: oaiSetPrefix + strconv.FormatInt(g.GenreId, 10), Name: g.Genre})
+	}
+	return nil
+}
+
+func oaiGetRecord(args url.Values, resp *oaiResponse) error {
+	if err := checkMetadataPrefix(args.Get("metadataPrefix")); err != nil {
+		return err
+	}
+	book, err := oaiFindBook(args.Get("identifier"))
+	if err != nil {
+		return err
+	}
+	resp.GetRecord = &oaiList{Records: []oaiRecord{book.record()}}
+	return nil
+}
+
+func oaiListIdentifiers(args url.Values, resp *oaiResponse) error {
+	list, err := oaiListBooks(args, false)
+	resp.ListIdentifiers = list
+	return err
+}
+
+func oaiListRecords(args url.Values, resp *oaiResponse) error {
+	list, err := oaiListBooks(args, true)
+	resp.ListRecords = list
+	return err
+}
+
+func checkMetadataPrefix(prefix string) error {
+	if prefix != oaiDCPrefix {
+		return newOAIError("cannotDisseminateFormat", msgOAICannotDisseminate, prefix)
+	}
+	return nil
+}
+
+// oaiQuery selects the books of a list request. It is handed to the
+// harvester as the resumption token of the next page, which starts after
+// the book After, AfterId.
+type oaiQuery struct {
+	Prefix  string    `json:"p"`
+	GenreId int64     `json:"g,omitempty"`
+	From    time.Time `json:"f,omitempty"`
+	Until   time.Time `json:"u,omitempty"`
+	After   time.Time `json:"a,omitempty"`
+	AfterId int64     `json:"i,omitempty"`
+	Cursor  int       `json:"c,omitempty"`
+}
+
+func (q oaiQuery) token() string {
+	data, _ := json.Marshal(q)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseOAIToken(token string) (oaiQuery, error) {
+	var q oaiQuery
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &q)
+	}
+	if err != nil || q.Prefix != oaiDCPrefix || q.Cursor <= 0 {
+		return q, newOAIError("badResumptionToken", msgOAIBadResumptionToken)
+	}
+	return q, nil
+}
+
+// parseOAIQuery reads the arguments of the first page of a list.
+func parseOAIQuery(args url.Values) (oaiQuery, error) {
+	q := oaiQuery{Prefix: args.Get("metadataPrefix")}
+	if err := checkMetadataPrefix(q.Prefix); err != nil {
+		return q, err
+	}
+	if set := args.Get("set"); set != "" {
+		id, err := strconv.ParseInt(strings.TrimPrefix(set, oaiSetPrefix), 10, 64)
+		if err != nil || !strings.HasPrefix(set, oaiSetPrefix) {
+			return q, newOAIError("noRecordsMatch", msgOAINoRecords)
+		}
+		q.GenreId = id
+	}
+	from, fromLayout, err := parseOAIDate(args.Get("from"))
+	if err != nil {
+		return q, err
+	}
+	until, untilLayout, err := parseOAIDate(args.Get("until"))
+	if err != nil {
+		return q, err
+	}
+	if fromLayout != "" && untilLayout != "" && fromLayout != untilLayout {
+		return q, newOAIError("badArgument", msgOAIGranularity)
+	}
+	q.From = from
+	if !until.IsZero() {
+		// until is inclusive, the query stops before the next day or second
+		if untilLayout == oaiDayFormat {
+			q.Until = until.AddDate(0, 0, 1)
+		} else {
+			q.Until = until.Add(time.Second)
+		}
+		if !from.IsZero() && from.After(until) {
+			return q, newOAIError("badArgument", msgOAIDateRange)
+		}
+	}
+	return q, nil
+}
+
+// parseOAIDate parses a date in either granularity and tells which one it
+// was in.
+func parseOAIDate(s string) (time.Time, string, error) {
+	if s == "" {
+		return time.Time{}, "", nil
+	}
+	for _, layout := range []string{oaiDayFormat, oaiTimeFormat} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, layout, nil
+		}
+	}
+	return time.Time{}, "", newOAIError("badArgument", msgOAIBadDate, s)
+}
+
+func oaiListBooks(args url.Values, records bool) (*oaiList, error) {
+	var q oaiQuery
+	var err error
+	if token := args.Get("resumptionToken"); token != "" {
+		q, err = parseOAIToken(token)
+	} else {
+		q, err = parseOAIQuery(args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var where []string
+	var params []interface{}
+	if q.GenreId != 0 {
+		where = append(where, "book.genre_id = ?")
+		params = append(params, q.GenreId)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "book.updated_at >= ?")
+		params = append(params, q.From)
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "book.updated_at < ?")
+		params = append(params, q.Until)
+	}
+	cond := "TRUE"
+	if len(where) > 0 {
+		cond = strings.Join(where, " AND ")
+	}
+	var total int
+	_, err = db.QueryOne(pg.Scan(&total), `SELECT count(*) FROM book WHERE `+cond, params...)
+	if err != nil {
+		return nil, err
+	}
+	if !q.After.IsZero() {
+		cond += " AND (book.updated_at, book.book_id) > (?, ?)"
+		params = append(params, q.After, q.AfterId)
+	}
+	var books []oaiBook
+	_, err = db.Query(&books, oaiBookQuery+` WHERE `+cond+` ORDER BY book.updated_at, book.book_id LIMIT ?`,
+		append(params, oaiPageSize+1)...)
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		return nil, newOAIError("noRecordsMatch", msgOAINoRecords)
+	}
+	list := &oaiList{}
+	more := len(books) > oaiPageSize
+	if more {
+		books = books[:oaiPageSize]
+	}
+	for i := range books {
+		if records {
+			list.Records = append(list.Records, books[i].record())
+		} else {
+			list.Headers = append(list.Headers, books[i].header())
+		}
+	}
+	if more || q.Cursor > 0 {
+		list.ResumptionToken = &oaiResumptionToken{CompleteListSize: total, Cursor: q.Cursor}
+	}
+	if more {
+		last := books[len(books)-1]
+		next := q
+		next.After, next.AfterId, next.Cursor = last.UpdatedAt, last.BookId, q.Cursor+len(books)
+		list.ResumptionToken.Token = next.token()
+	}
+	return list, nil
+}
+
+const oaiBookQuery = `SELECT book.book_id, book.name, author.author_name, book.genre_id, genre.genre,
+book.release_date, book.language, book.isbn, book.updated_at,
+ARRAY(SELECT format FROM book_files WHERE book_files.book_id = book.book_id ORDER BY book_files.id) AS formats
+FROM book
+INNER JOIN author ON author.author_id = book.author_id
+LEFT JOIN genre ON genre.genre_id = book.genre_id`
+
+type oaiBook struct {
+	BookId      int64     `pg:"book_id"`
+	Name        string    `pg:"name"`
+	AuthorName  string    `pg:"author_name"`
+	GenreId     int64     `pg:"genre_id"`
+	Genre       string    `pg:"genre"`
+	ReleaseDate time.Time `pg:"release_date"`
+	Language    string    `pg:"language"`
+	Isbn        string    `pg:"isbn"`
+	UpdatedAt   time.Time `pg:"updated_at"`
+	Formats     []string  `pg:",array"`
+}
+
+func oaiIdentifier(bookId int64) string {
+	return "oai:" + oaiRepositoryId + ":book:" + strconv.FormatInt(bookId, 10)
+}
+
+func oaiFindBook(identifier string) (*oaiBook, error) {
+	missing := newOAIError("idDoesNotExist", msgOAIIdDoesNotExist, identifier)
+	prefix := "oai:" + oaiRepositoryId + ":book:"
+	id, err := strconv.ParseInt(strings.TrimPrefix(identifier, prefix), 10, 64)
+	if err != nil || !strings.HasPrefix(identifier, prefix) {
+		return nil, missing
+	}
+	book := new(oaiBook)
+	_, err = db.QueryOne(book, oaiBookQuery+` WHERE book.book_id = ?`, id)
+	if err == pg.ErrNoRows {
+		return nil, missing
+	}
+	return book, err
+}
+
+func (b *oaiBook) header() oaiHeader {
+	h := oaiHeader{Identifier: oaiIdentifier(b.BookId), Datestamp: b.UpdatedAt.UTC().Format(oaiTimeFormat)}
+	if b.GenreId != 0 {
+		h.SetSpecs = []string{oaiSetPrefix + strconv.FormatInt(b.GenreId, 10)}
+	}
+	return h
+}
+
+func (b *oaiBook) record() oaiRecord {
+	dc := &oaiDC{
+		XmlnsOAIDC:     "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		XmlnsDC:        "http://purl.org/dc/elements/1.1/",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		Title:          []string{b.Name},
+		Creator:        []string{b.AuthorName},
+		Type:           []string{"Text"},
+	}
+	if b.Genre != "" {
+		dc.Subject = []string{b.Genre}
+	}
+	if !b.ReleaseDate.IsZero() {
+		dc.Date = []string{b.ReleaseDate.Format(oaiDayFormat)}
+	}
+	if b.Language != "" {
+		dc.Language = []string{b.Language}
+	}
+	if b.Isbn != "" {
+		dc.Identifier = []string{"urn:isbn:" + strings.NewReplacer("-", "", " ", "").Replace(b.Isbn)}
+	}
+	for _, name := range b.Formats {
+		if format, ok := bookFormatByName(name); ok {
+			dc.Format = append(dc.Format, format.ContentType)
+		}
+	}
+	return oaiRecord{Header: b.header(), Metadata: &oaiMetadata{DC: dc}}
+}
+
+type oaiResponse struct {
+	XMLName             xml.Name            `xml:"http://www.openarchives.org/OAI/2.0/ OAI-PMH"`
+	XmlnsXsi            string              `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string              `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string              `xml:"responseDate"`
+	Request             oaiRequest          `xml:"request"`
+	Errors              []*oaiError         `xml:"error"`
+	Identify            *oaiIdentifyResult  `xml:"Identify"`
+	ListMetadataFormats *oaiMetadataFormats `xml:"ListMetadataFormats"`
+	ListSets            *oaiSets            `xml:"ListSets"`
+	GetRecord           *oaiList            `xml:"GetRecord"`
+	ListIdentifiers     *oaiList            `xml:"ListIdentifiers"`
+	ListRecords         *oaiList            `xml:"ListRecords"`
+}
+
+type oaiRequest struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	URL             string `xml:",chardata"`
+}
+
+type oaiIdentifyResult struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type oaiMetadataFormats struct {
+	Formats []oaiMetadataFormat `xml:"metadataFormat"`
+}
+
+type oaiMetadataFormat struct {
+	MetadataPrefix    string `xml:"metadataPrefix"`
+	Schema            string `xml:"schema"`
+	MetadataNamespace string `xml:"metadataNamespace"`
+}
+
+type oaiSets struct {
+	Sets []oaiSet `xml:"set"`
+}
+
+type oaiSet struct {
+	Spec string `xml:"setSpec"`
+	Name string `xml:"setName"`
+}
+
+type oaiList struct {
+	Headers         []oaiHeader         `xml:"header"`
+	Records         []oaiRecord         `xml:"record"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken"`
+}
+
+type oaiHeader struct {
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpecs   []string `xml:"setSpec"`
+}
+
+type oaiRecord struct {
+	Header   oaiHeader    `xml:"header"`
+	Metadata *oaiMetadata `xml:"metadata"`
+}
+
+type oaiMetadata struct {
+	DC *oaiDC
+}
+
+type oaiResumptionToken struct {
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+	Token            string `xml:",chardata"`
+}
+
+type oaiDC struct {
+	XMLName        xml.Name `xml:"oai_dc:dc"`
+	XmlnsOAIDC     string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title          []string `xml:"dc:title"`
+	Creator        []string `xml:"dc:creator"`
+	Subject        []string `xml:"dc:subject"`
+	Date           []string `xml:"dc:date"`
+	Type           []string `xml:"dc:type"`
+	Format         []string `xml:"dc:format"`
+	Identifier     []string `xml:"dc:identifier"`
+	Language       []string `xml:"dc:language"`
+}
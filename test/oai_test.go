@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseOAIQuery(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(oaiDayFormat, s)
+		return d
+	}
+	tests := []struct {
+		query       string
+		code        string
+		from, until time.Time
+		genre       int64
+	}{
+		{query: "metadataPrefix=oai_dc"},
+		{query: "metadataPrefix=marc", code: "cannotDisseminateFormat"},
+		{query: "metadataPrefix=oai_dc&set=genre-3", genre: 3},
+		{query: "metadataPrefix=oai_dc&set=3", code: "noRecordsMatch"},
+		{query: "metadataPrefix=oai_dc&set=genre-x", code: "noRecordsMatch"},
+		{query: "metadataPrefix=oai_dc&from=2026-01-02&until=2026-01-05",
+			from: day("2026-01-02"), until: day("2026-01-06")},
+		{query: "metadataPrefix=oai_dc&from=2026-01-02T10:00:00Z&until=2026-01-02T11:00:00Z",
+			from: time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC), until: time.Date(2026, 1, 2, 11, 0, 1, 0, time.UTC)},
+		{query: "metadataPrefix=oai_dc&until=2026-01-05", until: day("2026-01-06")},
+		{query: "metadataPrefix=oai_dc&from=2026-01-02&until=2026-01-05T00:00:00Z", code: "badArgument"},
+		{query: "metadataPrefix=oai_dc&from=2026-01-05&until=2026-01-02", code: "badArgument"},
+		{query: "metadataPrefix=oai_dc&from=2026-01-02T10:00", code: "badArgument"},
+		{query: "metadataPrefix=oai_dc&from=yesterday", code: "badArgument"},
+	}
+	for _, tt := range tests {
+		args, _ := url.ParseQuery(tt.query)
+		q, err := parseOAIQuery(args)
+		if tt.code != "" {
+			if e, ok := err.(*oaiError); !ok || e.Code != tt.code {
+				t.Errorf("%s: got %v, want %s", tt.query, err, tt.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if !q.From.Equal(tt.from) || !q.Until.Equal(tt.until) || q.GenreId != tt.genre {
+			t.Errorf("%s: got from %v until %v genre %d, want %v %v %d", tt.query, q.From, q.Until, q.GenreId, tt.from, tt.until, tt.genre)
+		}
+	}
+}
+
+func TestParseOAIToken(t *testing.T) {
+	q := oaiQuery{Prefix: oaiDCPrefix, GenreId: 3, From: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+		After: time.Date(2026, 1, 3, 4, 5, 6, 0, time.UTC), AfterId: 17, Cursor: 100}
+	got, err := parseOAIToken(q.token())
+	if err != nil || got != q {
+		t.Errorf("parseOAIToken(token()) = %+v, %v, want %+v", got, err, q)
+	}
+	for _, token := range []string{
+		"",
+		"not base64!",
+		oaiQuery{Prefix: "marc", Cursor: 100}.token(),
+		oaiQuery{Prefix: oaiDCPrefix}.token(),
+	} {
+		if _, err := parseOAIToken(token); err == nil || err.(*oaiError).Code != "badResumptionToken" {
+			t.Errorf("parseOAIToken(%q) = %v, want badResumptionToken", token, err)
+		}
+	}
+}
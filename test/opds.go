@@ -0,0 +1,715 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+)
+
+// The OPDS catalogue lets e-reader apps browse the library by author and
+// genre and search it. The same feeds are served as OPDS 1.2 (Atom) under
+// /opds and as OPDS 2.0 (JSON) under /opds2. A book can only be downloaded
+// with a load token a librarian issued through takeBook: its acquisition
+// links redeem the reader's unused token through loadBook, books without
+// one are listed without them.
+
+const (
+	opdsAtomType        = "application/atom+xml;profile=opds-catalog"
+	opdsNavigationType  = opdsAtomType + ";kind=navigation"
+	opdsAcquisitionType = opdsAtomType + ";kind=acquisition"
+	opdsJSONType        = "application/opds+json"
+	openSearchType      = "application/opensearchdescription+xml"
+
+	opdsAcquisitionRel = "http://opds-spec.org/acquisition"
+	opdsImageRel       = "http://opds-spec.org/image"
+	opdsThumbnailRel   = "http://opds-spec.org/image/thumbnail"
+
+	opdsPageSize = 50
+)
+
+// opdsFeed is a page of the catalogue before it is written in the format
+// of one OPDS version. A feed lists either subsections or books.
+type opdsFeed struct {
+	// path of the feed below the prefix of the version
+	path       string
+	title      string
+	query      url.Values
+	page       int
+	more       bool
+	navigation []opdsSection
+	books      []opdsBook
+}
+
+type opdsSection struct {
+	Id    int64
+	Title string
+	Books int
+	path  string
+	// acquisition sections list books rather than further sections
+	acquisition bool
+}
+
+type opdsBook struct {
+	BookId        int64      `pg:"book_id"`
+	Name          string     `pg:"name"`
+	AuthorId      int64      `pg:"author_id"`
+	AuthorName    string     `pg:"author_name"`
+	GenreId       int64      `pg:"genre_id"`
+	Genre         string     `pg:"genre"`
+	ReleaseDate   time.Time  `pg:"release_date"`
+	Language      string     `pg:"language"`
+	Isbn          string     `pg:"isbn"`
+	ImageFilepath string     `pg:"image_filepath"`
+	UpdatedAt     time.Time  `pg:"updated_at"`
+	Files         []BookFile `pg:"-"`
+	// Token is an unused load token the reader holds for the book
+	Token string `pg:"-"`
+}
+
+var opdsVersions = []struct {
+	prefix      string
+	contentType string
+	write       func(c *gin.Context, prefix string, feed *opdsFeed)
+}{
+	{"opds", opdsAtomType, writeAtomFeed},
+	{"opds2", opdsJSONType, writeOPDS2Feed},
+}
+
+var opdsFeeds = []struct {
+	path    string
+	summary string
+	build   func(c *gin.Context) (*opdsFeed, error)
+}{
+	{"", "Start of the OPDS catalogue", opdsRoot},
+	{"books", "All books, newest first", opdsNewBooks},
+	{"authors", "Authors that have books", opdsAuthors},
+	{"authors/:id", "Books of an author", opdsAuthorBooks},
+	{"genres", "Genres that have books", opdsGenres},
+	{"genres/:id", "Books of a genre", opdsGenreBooks},
+	{"search", "Books whose title or author contains q", opdsSearch},
+}
+
+func registerOPDS(api *gin.RouterGroup) {
+	for _, v := range opdsVersions {
+		g := api.Group(v.prefix, opdsAuth)
+		for _, f := range opdsFeeds {
+			g.GET(f.path, serveFeed(v.prefix, v.write, f.build))
+		}
+	}
+	g := api.Group("opds", opdsAuth)
+	g.GET("opensearch.xml", serveOpenSearch)
+	g.GET("books/:id/cover", serveCover)
+	g.GET("load-book/:token", loadBook)
+}
+
+func opdsOperations() []operation {
+	var ops []operation
+	for _, v := range opdsVersions {
+		for _, f := range opdsFeeds {
+			op := operation{Method: "GET", Path: apiV1 + "/" + v.prefix, Summary: f.summary, Produces: []string{v.contentType}}
+			if f.path != "" {
+				op.Path += "/" + f.path
+				op.Query = []param{{"page", "integer", "Page of the feed, from 1"}}
+			}
+			if f.path == "search" {
+				op.Query = append(op.Query, param{"q", "string", "Words of the title or the author"})
+			}
+			ops = append(ops, op)
+		}
+	}
+	return append(ops,
+		operation{Method: "GET", Path: apiV1 + "/opds/opensearch.xml", Summary: "OpenSearch description of the catalogue search", Produces: []string{openSearchType}},
+		operation{Method: "GET", Path: apiV1 + "/opds/books/:id/cover", Summary: "Cover of a book", Query: []param{{"size", "string", "small or medium for a thumbnail"}}, Produces: []string{"image/jpeg", "image/png", "image/webp"}},
+		operation{Method: "GET", Path: apiV1 + "/opds/load-book/:token", Summary: "Download a book with a load token, for e-reader apps", Query: []param{{"format", "string", "pdf, epub or fb2, overrides Accept"}}, Produces: bookFileProduces},
+	)
+}
+
+// opdsAuth accepts HTTP Basic credentials besides the bearer token, e-reader
+// apps can't log in to get one. A failure asks for Basic so they prompt for
+// the login.
+func opdsAuth(c *gin.Context) {
+	var user *Users
+	var err error
+	if name, password, ok := c.Request.BasicAuth(); ok {
+		user, err = findBasicUser(name, password)
+		if err == pg.ErrNoRows {
+			err = newAPIError(http.StatusUnauthorized, codeUnauthorized, msgBadCredentials)
+		}
+	} else {
+		user, err = authenticate(c.GetHeader("Authorization"))
+	}
+	if err != nil {
+		c.Header("WWW-Authenticate", `Basic realm="library", charset="UTF-8"`)
+		respondError(c, err)
+		return
+	}
+	c.Set("id", user.Id)
+	c.Set("username", user.Name)
+	c.Set("role", user.Role)
+	c.Next()
+}
+
+// basicLogins remembers successful Basic logins for basicLoginTTL. E-reader
+// apps send the credentials with every request, checking each of them
+// against the bcrypt hash would cost a hash per page and cover. The key is a
+// hash of the name and password, so a changed password misses the cache,
+// while a deactivated account is let in until its entry expires.
+var basicLogins = struct {
+	sync.Mutex
+	users map[[sha256.Size]byte]basicLogin
+}{users: map[[sha256.Size]byte]basicLogin{}}
+
+const basicLoginTTL = time.Minute
+
+type basicLogin struct {
+	user    Users
+	expires time.Time
+}
+
+func basicLoginKey(name, password string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(name + "\x00" + password))
+}
+
+// findBasicUser is findUser behind the basicLogins cache.
+func findBasicUser(name, password string) (*Users, error) {
+	key := basicLoginKey(name, password)
+	now := time.Now()
+	basicLogins.Lock()
+	login, ok := basicLogins.users[key]
+	basicLogins.Unlock()
+	if ok && now.Before(login.expires) {
+		return &Users{Id: login.user.Id, Name: login.user.Name, Role: login.user.Role}, nil
+	}
+	user, err := findUser(&Users{Name: name, Password: password})
+	if err != nil {
+		return nil, err
+	}
+	basicLogins.Lock()
+	for k, l := range basicLogins.users {
+		if !now.Before(l.expires) {
+			delete(basicLogins.users, k)
+		}
+	}
+	basicLogins.users[key] = basicLogin{user: Users{Id: user.Id, Name: user.Name, Role: user.Role}, expires: now.Add(basicLoginTTL)}
+	basicLogins.Unlock()
+	return user, nil
+}
+
+func serveFeed(prefix string, write func(*gin.Context, string, *opdsFeed), build func(*gin.Context) (*opdsFeed, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		feed, err := build(c)
+		if err == nil {
+			// users without a reader browse the catalogue without download links
+			var readerId int64
+			readerId, err = currentReaderId(c)
+			if err == errNoReader {
+				err = nil
+			}
+			if err == nil {
+				err = attachAcquisition(readerId, feed.books)
+			}
+		}
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		write(c, apiV1+"/"+prefix, feed)
+	}
+}
+
+func opdsPage(c *gin.Context) int {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+func opdsRoot(c *gin.Context) (*opdsFeed, error) {
+	return &opdsFeed{title: tr(c, msgOPDSCatalog), page: 1, navigation: []opdsSection{
+		{Title: tr(c, msgOPDSNewBooks), path: "books", acquisition: true},
+		{Title: tr(c, msgOPDSAuthors), path: "authors"},
+		{Title: tr(c, msgOPDSGenres), path: "genres"},
+	}}, nil
+}
+
+func opdsAuthors(c *gin.Context) (*opdsFeed, error) {
+	feed := &opdsFeed{path: "authors", title: tr(c, msgOPDSAuthors), page: opdsPage(c)}
+	return feed, feed.sections(`SELECT author.author_id AS id, author.author_name AS title, count(*) AS books
+FROM author INNER JOIN book ON book.author_id = author.author_id
+GROUP BY author.author_id ORDER BY author.author_name, author.author_id LIMIT ? OFFSET ?`)
+}
+
+func opdsGenres(c *gin.Context) (*opdsFeed, error) {
+	feed := &opdsFeed{path: "genres", title: tr(c, msgOPDSGenres), page: opdsPage(c)}
+	return feed, feed.sections(`SELECT genre.genre_id AS id, genre.genre AS title, count(*) AS books
+FROM genre INNER JOIN book ON book.genre_id = genre.genre_id
+GROUP BY genre.genre_id ORDER BY genre.genre, genre.genre_id LIMIT ? OFFSET ?`)
+}
+
+func opdsNewBooks(c *gin.Context) (*opdsFeed, error) {
+	feed := &opdsFeed{path: "books", title: tr(c, msgOPDSNewBooks), page: opdsPage(c)}
+	return feed, feed.findBooks("TRUE", "book.book_id DESC")
+}
+
+func opdsAuthorBooks(c *gin.Context) (*opdsFeed, error) {
+	id, err := pathId(c)
+	if err != nil {
+		return nil, err
+	}
+	row, err := authorResource.find(id)
+	if err != nil {
+		return nil, err
+	}
+	feed := &opdsFeed{path: "authors/" + c.Param("id"), title: row.(*Author).AuthorName, page: opdsPage(c)}
+	return feed, feed.findBooks("book.author_id = ?", "book.release_date DESC, book.book_id", id)
+}
+
+func opdsGenreBooks(c *gin.Context) (*opdsFeed, error) {
+	id, err := pathId(c)
+	if err != nil {
+		return nil, err
+	}
+	row, err := genreResource.find(id)
+	if err != nil {
+		return nil, err
+	}
+	feed := &opdsFeed{path: "genres/" + c.Param("id"), title: row.(*Genre).Genre, page: opdsPage(c)}
+	return feed, feed.findBooks("book.genre_id = ?", "book.name, book.book_id", id)
+}
+
+func opdsSearch(c *gin.Context) (*opdsFeed, error) {
+	q := strings.TrimSpace(c.Query("q"))
+	feed := &opdsFeed{path: "search", title: tr(c, msgOPDSSearch, q), page: opdsPage(c), query: url.Values{"q": {q}}}
+	if q == "" {
+		return feed, nil
+	}
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q) + "%"
+	return feed, feed.findBooks("(book.name ILIKE ? OR author.author_name ILIKE ?)", "book.name, book.book_id", pattern, pattern)
+}
+
+// sections loads a page of the sections listed by query, whose last two
+// parameters are the limit and the offset.
+func (feed *opdsFeed) sections(query string) error {
+	feed.navigation = []opdsSection{}
+	_, err := db.Query(&feed.navigation, query, opdsPageSize+1, (feed.page-1)*opdsPageSize)
+	if len(feed.navigation) > opdsPageSize {
+		feed.navigation, feed.more = feed.navigation[:opdsPageSize], true
+	}
+	for i := range feed.navigation {
+		feed.navigation[i].path = feed.path + "/" + strconv.FormatInt(feed.navigation[i].Id, 10)
+		feed.navigation[i].acquisition = true
+	}
+	return err
+}
+
+// findBooks loads a page of the books matching where.
+func (feed *opdsFeed) findBooks(where, order string, params ...interface{}) error {
+	params = append(params, opdsPageSize+1, (feed.page-1)*opdsPageSize)
+	_, err := db.Query(&feed.books, `SELECT book.book_id, book.name, book.author_id, author.author_name, book.genre_id, genre.genre,
+book.release_date, book.language, book.isbn, book.image_filepath, book.updated_at
+FROM book
+INNER JOIN author ON author.author_id = book.author_id
+LEFT JOIN genre ON genre.genre_id = book.genre_id
+WHERE `+where+` ORDER BY `+order+` LIMIT ? OFFSET ?`, params...)
+	if len(feed.books) > opdsPageSize {
+		feed.books, feed.more = feed.books[:opdsPageSize], true
+	}
+	return err
+}
+
+// attachAcquisition fills in the files of books and the unused load tokens
+// the reader holds for them.
+func attachAcquisition(readerId int64, books []opdsBook) error {
+	if len(books) == 0 {
+		return nil
+	}
+	byId := map[int64]*opdsBook{}
+	ids := make([]int64, len(books))
+	for i := range books {
+		ids[i] = books[i].BookId
+		byId[ids[i]] = &books[i]
+	}
+	var files []BookFile
+	_, err := db.Query(&files, `SELECT * FROM book_files WHERE book_id IN (?) ORDER BY id`, pg.In(ids))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		byId[f.BookId].Files = append(byId[f.BookId].Files, f)
+	}
+	var tokens []bookTokens
+	_, err = db.Query(&tokens, `SELECT DISTINCT ON (book_id) * FROM book_load_tokens
+WHERE reader_id = ? AND book_id IN (?) AND used_at IS NULL AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+ORDER BY book_id, expires_at DESC`, readerId, pg.In(ids))
+	for _, t := range tokens {
+		byId[t.BookId].Token = t.Token
+	}
+	return err
+}
+
+// acquisitions are the download links of a book, one per format.
+func (b *opdsBook) acquisitions() []atomLink {
+	if b.Token == "" {
+		return nil
+	}
+	var links []atomLink
+	for _, f := range b.Files {
+		format, ok := bookFormatByName(f.Format)
+		if !ok {
+			continue
+		}
+		links = append(links, atomLink{
+			Rel:  opdsAcquisitionRel,
+			Href: apiV1 + "/opds/load-book/" + url.PathEscape(b.Token) + "?format=" + format.Name,
+			Type: format.ContentType,
+		})
+	}
+	return links
+}
+
+// covers are the image links of a book.
+func (b *opdsBook) covers() []atomLink {
+	if b.ImageFilepath == "" {
+		return nil
+	}
+	href := apiV1 + "/opds/books/" + strconv.FormatInt(b.BookId, 10) + "/cover"
+	typ := mime.TypeByExtension(filepath.Ext(b.ImageFilepath))
+	return []atomLink{
+		{Rel: opdsImageRel, Href: href, Type: typ},
+		{Rel: opdsThumbnailRel, Href: href + "?size=" + coverSizes[0].Name, Type: typ},
+	}
+}
+
+// identifier is the ISBN of the book as a URN.
+func (b *opdsBook) identifier() string {
+	if b.Isbn == "" {
+		return ""
+	}
+	return "urn:isbn:" + strings.NewReplacer("-", "", " ", "").Replace(b.Isbn)
+}
+
+func (b *opdsBook) issued() string {
+	if b.ReleaseDate.IsZero() {
+		return ""
+	}
+	return b.ReleaseDate.Format("2006-01-02")
+}
+
+// pageHref links to another page of the feed.
+func (feed *opdsFeed) pageHref(base string, page int) string {
+	query := url.Values{}
+	for k, v := range feed.query {
+		query[k] = v
+	}
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	href := feed.href(base)
+	if len(query) > 0 {
+		href += "?" + query.Encode()
+	}
+	return href
+}
+
+func (feed *opdsFeed) href(base string) string {
+	return strings.TrimSuffix(base+"/"+feed.path, "/")
+}
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"feed"`
+	Xmlns     string      `xml:"xmlns,attr"`
+	XmlnsDC   string      `xml:"xmlns:dc,attr"`
+	XmlnsOPDS string      `xml:"xmlns:opds,attr"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomAuthor   `xml:"author"`
+	Language   string         `xml:"dc:language,omitempty"`
+	Issued     string         `xml:"dc:issued,omitempty"`
+	Identifier string         `xml:"dc:identifier,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Content    *atomContent   `xml:"content"`
+	Links      []atomLink     `xml:"link"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+func writeAtomFeed(c *gin.Context, base string, feed *opdsFeed) {
+	updated := time.Now().UTC().Format(time.RFC3339)
+	kind := opdsAcquisitionType
+	if feed.navigation != nil {
+		kind = opdsNavigationType
+	}
+	out := atomFeed{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		ID:        strings.TrimSuffix("urn:library:opds:"+feed.path, ":"),
+		Title:     feed.title,
+		Updated:   updated,
+		Links: []atomLink{
+			{Rel: "self", Href: feed.pageHref(base, feed.page), Type: kind},
+			{Rel: "start", Href: base, Type: opdsNavigationType},
+			{Rel: "search", Href: apiV1 + "/opds/opensearch.xml", Type: openSearchType},
+		},
+	}
+	if feed.page > 1 {
+		out.Links = append(out.Links, atomLink{Rel: "previous", Href: feed.pageHref(base, feed.page-1), Type: kind})
+	}
+	if feed.more {
+		out.Links = append(out.Links, atomLink{Rel: "next", Href: feed.pageHref(base, feed.page+1), Type: kind})
+	}
+	for _, s := range feed.navigation {
+		typ := opdsNavigationType
+		if s.acquisition {
+			typ = opdsAcquisitionType
+		}
+		entry := atomEntry{
+			Title:   s.Title,
+			ID:      "urn:library:opds:" + s.path,
+			Updated: updated,
+			Links:   []atomLink{{Rel: "subsection", Href: base + "/" + s.path, Type: typ}},
+		}
+		if s.Books > 0 {
+			entry.Content = &atomContent{Type: "text", Text: tr(c, msgOPDSBookCount, s.Books)}
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	for i := range feed.books {
+		b := &feed.books[i]
+		author := base + "/authors/" + strconv.FormatInt(b.AuthorId, 10)
+		entry := atomEntry{
+			Title:      b.Name,
+			ID:         "urn:library:book:" + strconv.FormatInt(b.BookId, 10),
+			Updated:    b.UpdatedAt.UTC().Format(time.RFC3339),
+			Authors:    []atomAuthor{{Name: b.AuthorName, URI: author}},
+			Language:   b.Language,
+			Issued:     b.issued(),
+			Identifier: b.identifier(),
+			Links:      append(b.covers(), b.acquisitions()...),
+		}
+		entry.Links = append(entry.Links, atomLink{Rel: "related", Href: author, Type: opdsAcquisitionType, Title: b.AuthorName})
+		if b.Genre != "" {
+			entry.Categories = []atomCategory{{Term: b.Genre, Label: b.Genre}}
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	body, err := xml.Marshal(out)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, kind+";charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+type opds2Feed struct {
+	Metadata   opds2FeedMetadata `json:"metadata"`
+	Links      []opds2Link       `json:"links"`
+	Navigation []opds2Link       `json:"navigation,omitempty"`
+	// Publications is an empty list rather than missing in a feed of books
+	// that has none
+	Publications interface{} `json:"publications,omitempty"`
+}
+
+type opds2FeedMetadata struct {
+	Title        string `json:"title"`
+	ItemsPerPage int    `json:"itemsPerPage,omitempty"`
+	CurrentPage  int    `json:"currentPage,omitempty"`
+}
+
+type opds2Link struct {
+	Rel       string `json:"rel,omitempty"`
+	Href      string `json:"href"`
+	Type      string `json:"type,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Templated bool   `json:"templated,omitempty"`
+}
+
+type opds2Publication struct {
+	Metadata opds2Metadata `json:"metadata"`
+	Links    []opds2Link   `json:"links"`
+	Images   []opds2Link   `json:"images,omitempty"`
+}
+
+type opds2Metadata struct {
+	Type       string         `json:"@type"`
+	Identifier string         `json:"identifier,omitempty"`
+	Title      string         `json:"title"`
+	Author     []opds2Subject `json:"author,omitempty"`
+	Language   string         `json:"language,omitempty"`
+	Published  string         `json:"published,omitempty"`
+	Modified   string         `json:"modified,omitempty"`
+	Subject    []opds2Subject `json:"subject,omitempty"`
+}
+
+type opds2Subject struct {
+	Name  string      `json:"name"`
+	Links []opds2Link `json:"links,omitempty"`
+}
+
+func opds2LinksOf(links []atomLink) []opds2Link {
+	out := make([]opds2Link, len(links))
+	for i, l := range links {
+		out[i] = opds2Link{Rel: l.Rel, Href: l.Href, Type: l.Type, Title: l.Title}
+	}
+	return out
+}
+
+func writeOPDS2Feed(c *gin.Context, base string, feed *opdsFeed) {
+	out := opds2Feed{
+		Metadata: opds2FeedMetadata{Title: feed.title},
+		Links: []opds2Link{
+			{Rel: "self", Href: feed.pageHref(base, feed.page), Type: opdsJSONType},
+			{Rel: "start", Href: base, Type: opdsJSONType},
+			{Rel: "search", Href: base + "/search{?q}", Type: opdsJSONType, Templated: true},
+		},
+	}
+	if feed.path != "" {
+		out.Metadata.ItemsPerPage, out.Metadata.CurrentPage = opdsPageSize, feed.page
+	}
+	if feed.page > 1 {
+		out.Links = append(out.Links, opds2Link{Rel: "previous", Href: feed.pageHref(base, feed.page-1), Type: opdsJSONType})
+	}
+	if feed.more {
+		out.Links = append(out.Links, opds2Link{Rel: "next", Href: feed.pageHref(base, feed.page+1), Type: opdsJSONType})
+	}
+	for _, s := range feed.navigation {
+		out.Navigation = append(out.Navigation, opds2Link{Rel: "subsection", Href: base + "/" + s.path, Type: opdsJSONType, Title: s.Title})
+	}
+	if feed.navigation == nil {
+		publications := []opds2Publication{}
+		for i := range feed.books {
+			b := &feed.books[i]
+			author := base + "/authors/" + strconv.FormatInt(b.AuthorId, 10)
+			p := opds2Publication{
+				Metadata: opds2Metadata{
+					Type:       "http://schema.org/Book",
+					Identifier: b.identifier(),
+					Title:      b.Name,
+					Author:     []opds2Subject{{Name: b.AuthorName, Links: []opds2Link{{Href: author, Type: opdsJSONType}}}},
+					Language:   b.Language,
+					Published:  b.issued(),
+					Modified:   b.UpdatedAt.UTC().Format(time.RFC3339),
+				},
+				Links:  opds2LinksOf(b.acquisitions()),
+				Images: opds2LinksOf(b.covers()),
+			}
+			p.Links = append(p.Links, opds2Link{Rel: "related", Href: author, Type: opdsJSONType, Title: b.AuthorName})
+			for j := range p.Images {
+				p.Images[j].Rel = ""
+			}
+			if b.Genre != "" {
+				p.Metadata.Subject = []opds2Subject{{Name: b.Genre}}
+			}
+			publications = append(publications, p)
+		}
+		out.Publications = publications
+	}
+	c.Header("Content-Type", opdsJSONType+";charset=utf-8")
+	c.JSON(http.StatusOK, out)
+}
+
+type openSearchDescription struct {
+	XMLName        xml.Name        `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName      string          `xml:"ShortName"`
+	Description    string          `xml:"Description"`
+	InputEncoding  string          `xml:"InputEncoding"`
+	OutputEncoding string          `xml:"OutputEncoding"`
+	Urls           []openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// externalURL is the absolute URL of path on the host the request came to.
+func externalURL(c *gin.Context, path string) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + path
+}
+
+// serveOpenSearch describes the search of both catalogue versions. Apps
+// fill in the template themselves, so it has to be an absolute URL.
+func serveOpenSearch(c *gin.Context) {
+	base := externalURL(c, apiV1)
+	body, err := xml.Marshal(openSearchDescription{
+		ShortName:      tr(c, msgOPDSCatalog),
+		Description:    tr(c, msgOPDSSearchHint),
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+		Urls: []openSearchURL{
+			{Type: opdsAcquisitionType, Template: base + "/opds/search?q={searchTerms}"},
+			{Type: opdsJSONType, Template: base + "/opds2/search?q={searchTerms}"},
+		},
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, openSearchType+";charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+// serveCover sends the cover of a book, or one of its thumbnails.
+func serveCover(c *gin.Context) {
+	id, err := pathId(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	var path string
+	_, err = db.QueryOne(pg.Scan(&path), `SELECT coalesce(image_filepath, '') FROM book WHERE book_id = ?`, id)
+	if err == nil && path == "" {
+		err = errNotFound
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if variant, ok := coverVariants(path)[c.Query("size")]; ok {
+		path = variant
+	}
+	c.File(path)
+}
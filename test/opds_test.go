@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindBasicUserCached(t *testing.T) {
+	key := basicLoginKey("reader", "secret")
+	basicLogins.Lock()
+	basicLogins.users[key] = basicLogin{user: Users{Id: 7, Name: "reader", Role: "reader"}, expires: time.Now().Add(basicLoginTTL)}
+	basicLogins.Unlock()
+	defer func() {
+		basicLogins.Lock()
+		delete(basicLogins.users, key)
+		basicLogins.Unlock()
+	}()
+	user, err := findBasicUser("reader", "secret")
+	if err != nil || user.Id != 7 || user.Role != "reader" || user.Password != "" {
+		t.Errorf("findBasicUser = %+v, %v, want the cached reader", user, err)
+	}
+	if basicLoginKey("reader", "secret") == basicLoginKey("reader", "other") ||
+		basicLoginKey("read", "ersecret") == basicLoginKey("reader", "secret") {
+		t.Error("different credentials share a key")
+	}
+}
@@ -0,0 +1,708 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files"
+)
+
+// The OpenAPI document is generated from apiOperations below and from the
+// request and response types they reference. Field descriptions come from
+// the doc struct tag. TestSpecCoverage keeps the table in sync with the
+// routes registered in setupRouter.
+
+type jsonObject = map[string]interface{}
+
+// operation documents one route. Paths use Gin syntax.
+type operation struct {
+	Method, Path string
+	Summary      string
+	// Public operations don't require the bearer token
+	Public bool
+	// Librarian marks operations restricted to the librarian role
+	Librarian bool
+	Query     []param
+	Request   schemaFunc
+	Form      []formField
+	// Consumes lists the content types of a binary request body
+	Consumes []string
+	Status   int
+	Response schemaFunc
+	// Produces lists the content types of a binary response
+	Produces []string
+	// Versioned reads return an ETag and writes require If-Match
+	Versioned bool
+	// Deprecated operations are the legacy routes
+	Deprecated bool
+}
+
+type param struct {
+	Name, Type, Description string
+}
+
+type formField struct {
+	Name        string
+	File        bool
+	Multiple    bool
+	Description string
+}
+
+// schemaFunc renders a schema, registering named types as components.
+type schemaFunc func(g *specBuilder) jsonObject
+
+// jsonOf describes the JSON encoding of v.
+func jsonOf(v interface{}) schemaFunc {
+	return func(g *specBuilder) jsonObject {
+		return g.schema(reflect.TypeOf(v))
+	}
+}
+
+// resultOf describes {"message": ..., "result": v}.
+func resultOf(v interface{}) schemaFunc {
+	return func(g *specBuilder) jsonObject {
+		return object(jsonObject{"message": jsonObject{"type": "string"}, "result": g.schema(reflect.TypeOf(v))})
+	}
+}
+
+// listOf describes {"result": [v]}.
+func listOf(v interface{}) schemaFunc {
+	return func(g *specBuilder) jsonObject {
+		return object(jsonObject{"result": jsonObject{"type": "array", "items": g.schema(reflect.TypeOf(v))}})
+	}
+}
+
+// messageOf describes {"message": ...} plus the given fields.
+func messageOf(fields map[string]string) schemaFunc {
+	return func(g *specBuilder) jsonObject {
+		properties := jsonObject{"message": jsonObject{"type": "string"}}
+		for name, typ := range fields {
+			properties[name] = jsonObject{"type": typ}
+		}
+		return object(properties)
+	}
+}
+
+// writableOf describes the request body accepted by the generic resource
+// handlers.
+func writableOf(res resource) schemaFunc {
+	return func(g *specBuilder) jsonObject {
+		full := g.inline(reflect.TypeOf(res.model))["properties"].(jsonObject)
+		properties := jsonObject{}
+		for field := range res.writable {
+			properties[field] = full[field]
+		}
+		return object(properties)
+	}
+}
+
+func object(properties jsonObject) jsonObject {
+	return jsonObject{"type": "object", "properties": properties}
+}
+
+var (
+	loginRequest struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	tokenPair struct {
+		AccessToken  string `json:"accessToken"`
+		RefreshToken string `json:"refreshToken"`
+	}
+	refreshRequest struct {
+		RefreshToken string
+	}
+	tokenRequest struct {
+		Token string `doc:"Book load token issued by take-book"`
+	}
+	bookTokenRequest struct {
+		BookId   int64
+		ReaderId int64 `doc:"Reader the token is bound to"`
+	}
+	bookDraft struct {
+		Format      string   `json:"format"`
+		Name        string   `json:"name"`
+		Authors     []string `json:"authors"`
+		Language    string   `json:"language"`
+		PageCount   int      `json:"page_count"`
+		ReleaseDate string   `json:"release_date" doc:"YYYY-MM-DD"`
+		AuthorId    int64    `json:"author_id" doc:"Set when the first author is already in the catalogue"`
+		Cover       string   `json:"cover" doc:"Cover preview as a data URI"`
+	}
+	rentalPage struct {
+		Result []namedRental `json:"result"`
+		Total  int32         `json:"total" doc:"Rentals matching the filters"`
+	}
+	finePage struct {
+		Result []fine `json:"result"`
+		Total  int64  `json:"total" doc:"Sum of the fines"`
+	}
+	holdRequest struct {
+		BookId int64
+	}
+	marcReport struct {
+		Message string       `json:"message"`
+		Records []marcResult `json:"records"`
+	}
+	watermarkReport struct {
+		Watermark watermark     `json:"watermark"`
+		Rental    RentalHistory `json:"rental" doc:"Present when the rental matches the watermark"`
+	}
+)
+
+var bookListQuery = []param{
+	{"offset", "integer", "Number of books to skip, pages hold 20 books"},
+	{"status", "string", "rented or free"},
+	{"order", "string", "genre or author, defaults to the id"},
+	{"author", "string", "Author name"},
+}
+
+var (
+	exportFormatParam = param{"format", "string", "csv, xlsx or jsonl, overrides Accept"}
+	exportProduces    = []string{csvType, xlsxType, jsonlType}
+)
+
+var rentalFilterQuery = []param{
+	{"RentalDateFrom", "string", "RFC 3339, open when omitted"},
+	{"RentalDateTo", "string", "RFC 3339, open when omitted"},
+	{"ReturnDateFrom", "string", "RFC 3339, open when omitted"},
+	{"ReturnDateTo", "string", "RFC 3339, open when omitted"},
+	{"BookId", "integer", ""},
+	{"ReaderId", "integer", ""},
+	{"Status", "string", "open for books not returned yet, closed for returned ones"},
+}
+
+var rentalListQuery = append(rentalFilterQuery[:len(rentalFilterQuery):len(rentalFilterQuery)],
+	param{"Sort", "string", "rental_date, return_date, book or reader, a leading minus sorts descending, defaults to -rental_date"},
+	param{"Offset", "integer", "Number of rentals to skip"},
+	param{"Limit", "integer", "Page size up to 100, defaults to 20"},
+)
+
+// myHistoryQuery is rentalListQuery without ReaderId, which /me/history
+// takes from the login.
+var myHistoryQuery = func() []param {
+	var query []param
+	for _, p := range rentalListQuery {
+		if p.Name != "ReaderId" {
+			query = append(query, p)
+		}
+	}
+	return query
+}()
+
+var bookForm = []formField{
+	{Name: "book", File: true, Multiple: true, Description: "PDF, EPUB or FB2 file, one per format"},
+	{Name: "image", File: true, Description: "Cover image, taken from the book when omitted"},
+	{Name: "Name", Description: "Taken from the book metadata when omitted"},
+	{Name: "AuthorId"},
+	{Name: "GenreId"},
+	{Name: "ReleaseDate"},
+	{Name: "Language"},
+}
+
+var bookFileProduces = []string{"application/pdf", "application/epub+zip", "application/x-fictionbook+xml"}
+
+func (res resource) viewOf() interface{} {
+	if res.view != nil {
+		return res.view
+	}
+	return res.model
+}
+
+// operations documents the routes added by register. list and create
+// override the generic handlers the same way.
+func (res resource) operations(list, create *operation) []operation {
+	base := apiV1 + "/" + res.path
+	name := strings.TrimSuffix(res.path, "s")
+	ops := []operation{
+		{Method: "GET", Path: base, Summary: "List " + res.path, Response: listOf(res.viewOf())},
+		{Method: "POST", Path: base, Summary: "Create a " + name, Request: writableOf(res), Status: http.StatusCreated, Response: resultOf(res.viewOf())},
+		{Method: "GET", Path: base + "/:id", Summary: "Get a " + name, Response: jsonOf(res.viewOf()), Versioned: true},
+		{Method: "PUT", Path: base + "/:id", Summary: "Replace a " + name, Request: writableOf(res), Response: resultOf(res.viewOf()), Versioned: true},
+		{Method: "PATCH", Path: base + "/:id", Summary: "Change some fields of a " + name, Request: writableOf(res), Response: resultOf(res.viewOf()), Versioned: true},
+		{Method: "DELETE", Path: base + "/:id", Summary: "Delete a " + name, Status: http.StatusNoContent, Versioned: true},
+	}
+	if list != nil {
+		list.Method, list.Path = "GET", base
+		ops[0] = *list
+	}
+	if create != nil {
+		create.Method, create.Path = "POST", base
+		ops[1] = *create
+	}
+	for i := range ops {
+		ops[i].Librarian = res.private || ops[i].Method != "GET"
+	}
+	return ops
+}
+
+func apiOperations() []operation {
+	ops := []operation{
+		{Method: "POST", Path: apiV1 + "/login", Summary: "Log in", Public: true, Request: jsonOf(loginRequest), Response: jsonOf(tokenPair)},
+		{Method: "POST", Path: apiV1 + "/refresh", Summary: "Get a new access token", Public: true, Request: jsonOf(refreshRequest), Response: jsonOf(tokenPair)},
+		{Method: "POST", Path: apiV1 + "/signup", Summary: "Sign up as a reader, the account works once the emailed link is opened", Public: true, Request: jsonOf(registration{}), Status: http.StatusCreated, Response: resultOf(publicUser{})},
+		{Method: "POST", Path: apiV1 + "/signup/resend", Summary: "Email a new verification link", Public: true, Request: jsonOf(resendRequest{}), Status: http.StatusAccepted, Response: messageOf(nil)},
+		{Method: "GET", Path: apiV1 + "/verify-email", Summary: "Activate the account of a verification link", Public: true, Query: []param{{"token", "string", "From the emailed link"}}, Response: messageOf(nil)},
+		{Method: "POST", Path: apiV1 + "/password-reset", Summary: "Email a password reset link", Public: true, Request: jsonOf(resetRequest{}), Status: http.StatusAccepted, Response: messageOf(nil)},
+		{Method: "POST", Path: apiV1 + "/password-reset/confirm", Summary: "Set a new password with the emailed token, ends every session", Public: true, Request: jsonOf(resetConfirmation{}), Response: messageOf(nil)},
+		{Method: "POST", Path: apiV1 + "/change-password", Summary: "Change the password of the logged in user", Request: jsonOf(passwordChange{}), Response: messageOf(nil)},
+		{Method: "GET", Path: apiV1 + "/openapi.json", Summary: "This document", Public: true, Response: jsonOf(jsonObject{})},
+		{Method: "GET", Path: "/openapi.json", Summary: "This document", Public: true, Response: jsonOf(jsonObject{})},
+		{Method: "GET", Path: apiV1 + "/logout", Summary: "End the session", Response: resultOf(int64(0))},
+		{Method: "POST", Path: apiV1 + "/return-book", Summary: "Return a book", Request: jsonOf(RentalHistory{}), Response: listOf(RentalHistory{})},
+		{Method: "POST", Path: apiV1 + "/rental-history", Summary: "Rentals within the given dates", Request: jsonOf(TimeIntervalsForHistory{}), Response: listOf(RentalHistory{})},
+		{Method: "GET", Path: apiV1 + "/rental-history", Summary: "Search the rental history", Librarian: true, Query: rentalListQuery, Response: jsonOf(rentalPage)},
+		{Method: "POST", Path: apiV1 + "/save", Summary: "Upload a file", Form: []formField{{Name: "username"}, {Name: "password"}, {Name: "file", File: true}}, Response: messageOf(map[string]string{"json": "string"})},
+		{Method: "POST", Path: apiV1 + "/take-book", Summary: "Issue a single-use load token", Librarian: true, Request: jsonOf(bookTokenRequest), Response: messageOf(map[string]string{"token": "string", "expires_at": "string"})},
+		{Method: "POST", Path: apiV1 + "/load-book", Summary: "Download a book with a load token", Request: jsonOf(tokenRequest), Query: []param{{"format", "string", "pdf, epub or fb2, overrides Accept"}}, Produces: bookFileProduces},
+		{Method: "GET", Path: apiV1 + "/load-book/:token", Summary: "Download a book with a load token", Query: []param{{"format", "string", "pdf, epub or fb2, overrides Accept"}}, Produces: bookFileProduces},
+		{Method: "POST", Path: apiV1 + "/revoke-book-token", Summary: "Revoke a load token", Librarian: true, Request: jsonOf(tokenRequest), Response: messageOf(map[string]string{"revoked_at": "string"})},
+		{Method: "POST", Path: apiV1 + "/verify-watermark", Summary: "Find who a PDF copy was issued to", Librarian: true, Form: []formField{{Name: "book", File: true}}, Response: jsonOf(watermarkReport)},
+
+		{Method: "POST", Path: apiV1 + "/books/:id/files", Summary: "Add a format to a book", Librarian: true, Form: []formField{{Name: "book", File: true}}, Status: http.StatusCreated, Response: jsonOf(BookFile{})},
+		{Method: "GET", Path: apiV1 + "/books/export", Summary: "Export the catalogue", Librarian: true, Query: append([]param{exportFormatParam}, bookListQuery[1:]...), Produces: exportProduces},
+		{Method: "GET", Path: apiV1 + "/readers/export", Summary: "Export the readers", Librarian: true, Query: []param{exportFormatParam}, Produces: exportProduces},
+		{Method: "GET", Path: apiV1 + "/rental-history/export", Summary: "Export the rental history", Librarian: true, Query: append([]param{exportFormatParam}, rentalFilterQuery...), Produces: exportProduces},
+		{Method: "POST", Path: apiV1 + "/books/marc", Summary: "Import MARC21 or MARCXML records as books", Librarian: true, Consumes: []string{marcType, marcXMLType}, Response: jsonOf(marcReport)},
+		{Method: "GET", Path: apiV1 + "/books/marc", Summary: "Export books as MARC21 or MARCXML", Librarian: true, Query: []param{{"ids", "string", "Comma separated book ids"}, {"format", "string", "marc or marcxml, overrides Accept"}}, Produces: []string{marcType, marcXMLType}},
+		{Method: "PUT", Path: apiV1 + "/users/:id/reader", Summary: "Link a reader to a user", Librarian: true, Request: jsonOf(readerLink{}), Response: resultOf(publicUser{})},
+		{Method: "PUT", Path: apiV1 + "/users/:id/password", Summary: "Set the password of a user, ends their sessions", Librarian: true, Request: jsonOf(passwordChange{}), Response: messageOf(nil)},
+		{Method: "DELETE", Path: apiV1 + "/users/:id/reader", Summary: "Unlink the reader of a user", Librarian: true, Response: resultOf(publicUser{})},
+		{Method: "GET", Path: apiV1 + "/me/loans", Summary: "Books the reader has now", Response: listOf(loan{})},
+		{Method: "GET", Path: apiV1 + "/me/loans/:id/download", Summary: "Redirect to a new load token for the book of an open loan", Query: []param{{"format", "string", "pdf, epub or fb2, passed on to load-book"}}, Status: http.StatusSeeOther},
+		{Method: "GET", Path: apiV1 + "/me/history", Summary: "Rental history of the reader", Query: myHistoryQuery, Response: jsonOf(rentalPage)},
+		{Method: "GET", Path: apiV1 + "/me/holds", Summary: "Books the reader is waiting for", Response: listOf(readerHold{})},
+		{Method: "POST", Path: apiV1 + "/me/holds", Summary: "Place a hold on a book", Request: jsonOf(holdRequest), Status: http.StatusCreated, Response: resultOf(Hold{})},
+		{Method: "DELETE", Path: apiV1 + "/me/holds/:id", Summary: "Cancel a hold", Status: http.StatusNoContent},
+		{Method: "GET", Path: apiV1 + "/me/fines", Summary: "Fines for overdue books", Response: jsonOf(finePage)},
+		{Method: "POST", Path: apiV1 + "/book-drafts", Summary: "Read the metadata of a book file", Librarian: true, Form: []formField{{Name: "book", File: true}}, Response: jsonOf(bookDraft)},
+		{Method: "POST", Path: apiV1 + "/graphql", Summary: "Run a GraphQL query, see graphql/schema.graphql", Request: jsonOf(graphqlRequest{}), Response: jsonOf(jsonObject{})},
+	}
+	ops = append(ops, opdsOperations()...)
+	ops = append(ops, oaiOperations()...)
+	ops = append(ops, authorResource.operations(nil, nil)...)
+	ops = append(ops, genreResource.operations(nil, nil)...)
+	ops = append(ops, readerResource.operations(nil, nil)...)
+	ops = append(ops, userResource.operations(nil,
+		&operation{Summary: "Register a user and the reader they borrow as", Request: jsonOf(registration{}), Status: http.StatusCreated, Response: resultOf(publicUser{})})...)
+	ops = append(ops, roleResource.operations(nil, nil)...)
+	ops = append(ops, bookResource.operations(
+		&operation{Summary: "Search books", Librarian: true, Query: bookListQuery, Response: listOf(bookSearch{})},
+		&operation{Summary: "Upload a book", Form: bookForm, Status: http.StatusCreated, Response: resultOf(Book{})},
+	)...)
+	for _, op := range legacyOperations() {
+		op.Deprecated = true
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// legacyOperations documents the unversioned routes kept by registerLegacy.
+func legacyOperations() []operation {
+	return []operation{
+		{Method: "POST", Path: "/login", Summary: "Log in", Public: true, Request: jsonOf(loginRequest), Response: jsonOf(tokenPair)},
+		{Method: "POST", Path: "/refresh", Summary: "Get a new access token", Public: true, Request: jsonOf(refreshRequest), Response: jsonOf(tokenPair)},
+		{Method: "GET", Path: "/logout", Summary: "End the session", Response: resultOf(int64(0))},
+
+		{Method: "GET", Path: "/api/authors", Summary: "List authors", Response: listOf(Author{})},
+		{Method: "POST", Path: "/api/authors", Summary: "Create an author", Request: jsonOf(Author{}), Response: resultOf(Author{})},
+		{Method: "PUT", Path: "/api/authors", Summary: "Rename an author", Request: jsonOf(Author{}), Response: resultOf(Author{}), Versioned: true},
+		{Method: "DELETE", Path: "/api/authors", Summary: "Delete an author", Request: jsonOf(Author{}), Response: resultOf(Author{}), Versioned: true},
+
+		{Method: "GET", Path: "/api/genres", Summary: "List genres", Response: listOf(Genre{})},
+		{Method: "POST", Path: "/api/genres", Summary: "Create a genre", Request: jsonOf(Genre{}), Response: resultOf(Genre{})},
+		{Method: "PUT", Path: "/api/genres", Summary: "Rename a genre", Request: jsonOf(Genre{}), Response: resultOf(Genre{}), Versioned: true},
+		{Method: "DELETE", Path: "/api/genres", Summary: "Delete a genre", Request: jsonOf(Genre{}), Response: resultOf(Genre{}), Versioned: true},
+
+		{Method: "GET", Path: "/api/readers", Summary: "List readers", Response: listOf(Reader{})},
+		{Method: "POST", Path: "/api/readers", Summary: "Create a reader", Request: jsonOf(Reader{}), Response: resultOf(Reader{})},
+		{Method: "PUT", Path: "/api/readers", Summary: "Rename a reader", Request: jsonOf(Reader{}), Response: resultOf(Reader{}), Versioned: true},
+		{Method: "DELETE", Path: "/api/readers", Summary: "Delete a reader", Request: jsonOf(Reader{}), Response: resultOf(Reader{}), Versioned: true},
+
+		{Method: "GET", Path: "/api/books", Summary: "Search books", Librarian: true, Query: bookListQuery, Response: listOf(bookSearch{})},
+		{Method: "POST", Path: "/api/books", Summary: "Upload a book", Form: bookForm, Status: http.StatusCreated, Response: resultOf(Book{})},
+		{Method: "PUT", Path: "/api/books", Summary: "Rename a book", Request: jsonOf(Book{}), Response: resultOf(Book{}), Versioned: true},
+		{Method: "DELETE", Path: "/api/books", Summary: "Delete a book", Request: jsonOf(Book{}), Response: resultOf(Book{}), Versioned: true},
+		{Method: "POST", Path: "/api/books/files", Summary: "Add a format to a book", Librarian: true, Form: []formField{{Name: "BookId"}, {Name: "book", File: true}}, Status: http.StatusCreated, Response: jsonOf(BookFile{})},
+		{Method: "POST", Path: "/api/books/draft", Summary: "Read the metadata of a book file", Librarian: true, Form: []formField{{Name: "book", File: true}}, Response: jsonOf(bookDraft)},
+
+		{Method: "GET", Path: "/api/users/*id", Summary: "List users or get one, readers see only themselves", Response: jsonOf([]publicUser{})},
+		{Method: "POST", Path: "/api/users", Summary: "Create a user", Request: jsonOf(registration{}), Response: resultOf("")},
+		{Method: "PUT", Path: "/api/users", Summary: "Change a password", Librarian: true, Request: jsonOf(Users{}), Response: resultOf(""), Versioned: true},
+		{Method: "DELETE", Path: "/api/users", Summary: "Delete a user", Request: jsonOf(Users{}), Response: resultOf(""), Versioned: true},
+
+		{Method: "GET", Path: "/api/roles/*id", Summary: "List roles or get one", Response: jsonOf([]Roles{})},
+		{Method: "POST", Path: "/api/roles", Summary: "Create a role", Request: jsonOf(Roles{}), Response: resultOf(Roles{})},
+		{Method: "PUT", Path: "/api/roles", Summary: "Rename a role", Request: jsonOf(Roles{}), Response: resultOf(Roles{}), Versioned: true},
+		{Method: "DELETE", Path: "/api/roles", Summary: "Delete a role", Request: jsonOf(Roles{}), Response: resultOf(Roles{}), Versioned: true},
+
+		{Method: "POST", Path: "/api/returnbook", Summary: "Return a book", Request: jsonOf(RentalHistory{}), Response: listOf(RentalHistory{})},
+		{Method: "POST", Path: "/api/rentalhistory", Summary: "Rentals within the given dates", Request: jsonOf(TimeIntervalsForHistory{}), Response: listOf(RentalHistory{})},
+		{Method: "POST", Path: "/save", Summary: "Upload a file", Form: []formField{{Name: "username"}, {Name: "password"}, {Name: "file", File: true}}, Response: messageOf(map[string]string{"json": "string"})},
+
+		{Method: "POST", Path: "/take-book", Summary: "Issue a single-use load token", Librarian: true, Request: jsonOf(bookTokenRequest), Response: messageOf(map[string]string{"token": "string", "expires_at": "string"})},
+		{Method: "POST", Path: "/load-book", Summary: "Download a book with a load token", Request: jsonOf(tokenRequest), Query: []param{{"format", "string", "pdf, epub or fb2, overrides Accept"}}, Produces: bookFileProduces},
+		{Method: "GET", Path: "/load-book/:token", Summary: "Download a book with a load token", Query: []param{{"format", "string", "pdf, epub or fb2, overrides Accept"}}, Produces: bookFileProduces},
+		{Method: "POST", Path: "/revoke-book-token", Summary: "Revoke a load token", Librarian: true, Request: jsonOf(tokenRequest), Response: messageOf(map[string]string{"revoked_at": "string"})},
+		{Method: "POST", Path: "/verify-watermark", Summary: "Find who a PDF copy was issued to", Librarian: true, Form: []formField{{Name: "book", File: true}}, Response: jsonOf(watermarkReport)},
+	}
+}
+
+// specPath converts a Gin path to OpenAPI syntax, both :id and *id become
+// {id}.
+func specPath(ginPath string) string {
+	segments := strings.Split(ginPath, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func pathParams(ginPath string) []string {
+	var names []string
+	for _, s := range strings.Split(ginPath, "/") {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			names = append(names, s[1:])
+		}
+	}
+	return names
+}
+
+type specBuilder struct {
+	components jsonObject
+}
+
+func (g *specBuilder) schema(t reflect.Type) jsonObject {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return jsonObject{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(jsonObject{}):
+		return jsonObject{"type": "object"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := g.components[t.Name()]; !ok {
+			g.components[t.Name()] = nil // breaks cycles
+			g.components[t.Name()] = g.inline(t)
+		}
+		return jsonObject{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return g.inline(t)
+}
+
+func (g *specBuilder) inline(t reflect.Type) jsonObject {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return jsonObject{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return jsonObject{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonObject{"type": "string", "format": "byte"}
+		}
+		return jsonObject{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		properties := jsonObject{}
+		g.fields(t, properties)
+		return object(properties)
+	}
+	return jsonObject{}
+}
+
+// fields adds the properties of struct t following the naming rules of
+// encoding/json, fields of embedded structs are promoted.
+func (g *specBuilder) fields(t reflect.Type, properties jsonObject) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts := f.Name, ""
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			name, opts = strings.Split(tag, ",")[0], tag
+			if name == "" {
+				name = f.Name
+			}
+		}
+		if f.Anonymous && opts == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(ft, properties)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		s := g.schema(f.Type)
+		if rules := f.Tag.Get("binding"); rules != "" {
+			constrain(s, rules, f.Type.Kind())
+		}
+		if doc := f.Tag.Get("doc"); doc != "" {
+			if _, isRef := s["$ref"]; isRef {
+				// siblings of $ref are ignored in OpenAPI 3.0
+				s = jsonObject{"allOf": []interface{}{s}, "description": doc}
+			} else {
+				s["description"] = doc
+			}
+		}
+		properties[name] = s
+	}
+}
+
+// constrain adds the binding rules of a field that JSON Schema can express.
+func constrain(s jsonObject, rules string, kind reflect.Kind) {
+	for _, rule := range strings.Split(rules, ",") {
+		name, param := rule, ""
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+		n, _ := strconv.Atoi(param)
+		switch {
+		case name == "max" && kind == reflect.String:
+			s["maxLength"] = n
+		case name == "min" && kind == reflect.String:
+			s["minLength"] = n
+		case name == "max":
+			s["maximum"] = n
+		case name == "min":
+			s["minimum"] = n
+		case name == "role":
+			s["pattern"] = roleName.String()
+		}
+	}
+}
+
+func (g *specBuilder) operation(op operation) jsonObject {
+	o := jsonObject{
+		"summary":     op.Summary,
+		"operationId": strings.ToLower(op.Method) + strings.Replace(strings.Title(strings.NewReplacer("/", " ", "-", " ", ":", "by ", "*", "by ", ".", " ").Replace(op.Path)), " ", "", -1),
+		"tags":        []string{tagOf(op.Path)},
+	}
+	if op.Librarian {
+		o["description"] = "Librarian only."
+		o["x-required-role"] = "librarian"
+	}
+	if op.Public {
+		o["security"] = []interface{}{}
+	}
+	if op.Deprecated {
+		o["deprecated"] = true
+	}
+
+	var params []interface{}
+	for _, name := range pathParams(op.Path) {
+		typ := "string"
+		if name == "id" {
+			typ = "integer"
+		}
+		params = append(params, jsonObject{"name": name, "in": "path", "required": true, "schema": jsonObject{"type": typ}})
+	}
+	for _, p := range op.Query {
+		params = append(params, jsonObject{"name": p.Name, "in": "query", "description": p.Description, "schema": jsonObject{"type": p.Type}})
+	}
+	params = append(params, jsonObject{"name": "Accept-Language", "in": "header", "description": "ru or en", "schema": jsonObject{"type": "string"}})
+	if op.Versioned && op.Method != "GET" {
+		params = append(params, jsonObject{"name": "If-Match", "in": "header", "required": true,
+			"description": "ETag of the version being changed, 412 when it is no longer current; * matches any version", "schema": jsonObject{"type": "string"}})
+	}
+	o["parameters"] = params
+
+	switch {
+	case op.Request != nil:
+		o["requestBody"] = jsonObject{"required": true, "content": jsonObject{
+			"application/json": jsonObject{"schema": op.Request(g)},
+		}}
+	case len(op.Form) > 0:
+		properties := jsonObject{}
+		for _, f := range op.Form {
+			s := jsonObject{"type": "string"}
+			if f.File {
+				s["format"] = "binary"
+			}
+			if f.Multiple {
+				s = jsonObject{"type": "array", "items": s}
+			}
+			if f.Description != "" {
+				s["description"] = f.Description
+			}
+			properties[f.Name] = s
+		}
+		o["requestBody"] = jsonObject{"required": true, "content": jsonObject{
+			"multipart/form-data": jsonObject{"schema": object(properties)},
+		}}
+	case len(op.Consumes) > 0:
+		content := jsonObject{}
+		for _, contentType := range op.Consumes {
+			content[contentType] = jsonObject{"schema": jsonObject{"type": "string", "format": "binary"}}
+		}
+		o["requestBody"] = jsonObject{"required": true, "content": content}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := jsonObject{"description": http.StatusText(status)}
+	if op.Versioned && status != http.StatusNoContent {
+		response["headers"] = jsonObject{"ETag": jsonObject{"description": "Version of the row", "schema": jsonObject{"type": "string"}}}
+	}
+	switch {
+	case len(op.Produces) > 0:
+		content := jsonObject{}
+		for _, contentType := range op.Produces {
+			content[contentType] = jsonObject{"schema": jsonObject{"type": "string", "format": "binary"}}
+		}
+		response["content"] = content
+	case op.Response != nil:
+		response["content"] = jsonObject{"application/json": jsonObject{"schema": op.Response(g)}}
+	}
+	o["responses"] = jsonObject{
+		fmt.Sprint(status): response,
+		"default": jsonObject{"description": "Error", "content": jsonObject{
+			"application/json": jsonObject{"schema": object(jsonObject{"error": g.schema(reflect.TypeOf(apiError{}))})},
+		}},
+	}
+	return o
+}
+
+// tagOf groups operations by the first path segment after the API prefix.
+func tagOf(path string) string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, apiV1), "/api")
+	segment := strings.Split(strings.TrimPrefix(path, "/"), "/")[0]
+	switch segment {
+	case "login", "refresh", "logout", "signup", "verify-email", "password-reset", "change-password":
+		return "auth"
+	case "take-book", "load-book", "revoke-book-token", "verify-watermark", "returnbook", "rentalhistory", "return-book", "rental-history":
+		return "lending"
+	case "book-drafts":
+		return "books"
+	case "opds2":
+		return "opds"
+	}
+	return segment
+}
+
+func buildOpenAPI() jsonObject {
+	g := &specBuilder{components: jsonObject{}}
+	paths := jsonObject{}
+	for _, op := range apiOperations() {
+		p := specPath(op.Path)
+		item, ok := paths[p].(jsonObject)
+		if !ok {
+			item = jsonObject{}
+			paths[p] = item
+		}
+		item[strings.ToLower(op.Method)] = g.operation(op)
+	}
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":   "Library API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": jsonObject{
+			"schemas": g.components,
+			"securitySchemes": jsonObject{
+				"bearerAuth": jsonObject{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		"security": []interface{}{jsonObject{"bearerAuth": []string{}}},
+	}
+}
+
+// openAPIDocument is built on the first request, once.
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument []byte
+	openAPIErr      error
+)
+
+func serveOpenAPI(c *gin.Context) {
+	openAPIOnce.Do(func() {
+		openAPIDocument, openAPIErr = json.Marshal(buildOpenAPI())
+	})
+	if openAPIErr != nil {
+		respondError(c, openAPIErr)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", openAPIDocument)
+}
+
+// undocumentedRoutes are left out of the document on purpose.
+var undocumentedRoutes = map[string]bool{
+	"GET /docs/*file":              true,
+	"GET " + apiV1 + "/docs/*file": true,
+}
+
+// checkSpecCoverage reports routes missing from the document and
+// documented operations that are not routed.
+func checkSpecCoverage(routes gin.RoutesInfo) error {
+	documented := map[string]bool{}
+	for _, op := range apiOperations() {
+		documented[op.Method+" "+specPath(op.Path)] = true
+	}
+	var missing []string
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		if undocumentedRoutes[key] {
+			continue
+		}
+		key = route.Method + " " + specPath(route.Path)
+		if !documented[key] {
+			missing = append(missing, key)
+		}
+		delete(documented, key)
+	}
+	var stale []string
+	for key := range documented {
+		stale = append(stale, key)
+	}
+	if len(missing) == 0 && len(stale) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	return fmt.Errorf("openapi: routes missing from the document: %v, documented but not routed: %v", missing, stale)
+}
+
+//go:embed swagger/index.html
+var swaggerIndex []byte
+
+// serveSwaggerUI serves the Swagger UI pointed at /openapi.json, the
+// assets are compiled in from swaggo/files.
+func serveSwaggerUI(c *gin.Context) {
+	file := c.Param("file")
+	if file == "/" || file == "/index.html" {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", swaggerIndex)
+		return
+	}
+	c.FileFromFS(file, swaggerFiles.HTTP)
+}
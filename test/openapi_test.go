@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestSpecCoverage fails when a route is added without documenting it in
+// apiOperations, or an operation is documented that is not routed.
+func TestSpecCoverage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if err := checkSpecCoverage(setupRouter().Routes()); err != nil {
+		t.Fatal(err)
+	}
+}
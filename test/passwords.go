@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Passwords are stored as bcrypt hashes. Forgotten ones are reset with a
+// single-use token emailed to the user, only its SHA-256 is stored.
+
+// resetLinkTTL is how long a reset token works.
+var resetLinkTTL = time.Duration(envInt64("RESET_LINK_MINUTES", 30)) * time.Minute
+
+// resetPagePath is the page of the client app under PUBLIC_URL that asks
+// for the new password and posts it with the token to
+// /password-reset/confirm.
+var resetPagePath = envString("RESET_PAGE_PATH", "/reset-password")
+
+var (
+	errResetLinkInvalid = newAPIError(http.StatusBadRequest, codeBadRequest, msgResetLinkInvalid)
+	errWrongPassword    = newAPIError(http.StatusForbidden, codeForbidden, msgWrongPassword)
+)
+
+type resetRequest struct {
+	Email string `binding:"omitempty,email,max=254"`
+}
+
+type resetConfirmation struct {
+	Token    string
+	Password string `binding:"omitempty,min=4,max=72"`
+}
+
+// passwordChange is the body of /change-password, librarians setting the
+// password of a user send only NewPassword.
+type passwordChange struct {
+	CurrentPassword string `doc:"Not needed by librarians setting the password of a user"`
+	NewPassword     string `binding:"omitempty,min=4,max=72"`
+}
+
+type PasswordReset struct {
+	Id        int64     `pg:"id"`
+	UserId    int64     `pg:"user_id"`
+	TokenHash string    `pg:"token_hash"`
+	CreatedAt time.Time `pg:"created_at"`
+	ExpiresAt time.Time `pg:"expires_at"`
+	UsedAt    time.Time `pg:"used_at"`
+}
+
+// hashPassword returns the hash stored in users.password.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func resetTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// setPassword stores the hash of password for the user and ends their
+// sessions when revoke is set. Access tokens already issued keep working
+// until they expire.
+func setPassword(tx *pg.Tx, userId int64, password string, revoke bool) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(`UPDATE users SET password = ?, version = version + 1 WHERE id = ?`, hash, userId)
+	if err == nil && result.RowsAffected() == 0 {
+		err = errNotFound
+	}
+	if err == nil && revoke {
+		_, err = tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userId)
+	}
+	return err
+}
+
+// requestPasswordReset emails a reset token to the verified account with
+// the address. Unknown addresses get the same answer, so it can't tell
+// which emails have accounts.
+func requestPasswordReset(c *gin.Context) {
+	req := new(resetRequest)
+	if err := bindValid(c, req, "Email"); err != nil {
+		respondError(c, err)
+		return
+	}
+	user := new(Users)
+	_, err := db.QueryOne(user, `SELECT * FROM users WHERE email = ? AND active`, req.Email)
+	if err == nil {
+		err = db.RunInTransaction(func(tx *pg.Tx) error {
+			return sendPasswordReset(c, tx, user)
+		})
+	} else if err == pg.ErrNoRows {
+		err = nil
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": tr(c, msgResetSent)})
+}
+
+func sendPasswordReset(c *gin.Context, tx *pg.Tx, user *Users) error {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	reset := &PasswordReset{UserId: user.Id, TokenHash: resetTokenHash(token), ExpiresAt: time.Now().Add(resetLinkTTL)}
+	_, err := tx.QueryOne(reset, `INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES (?user_id, ?token_hash, ?expires_at) RETURNING *`, reset)
+	if err != nil {
+		return err
+	}
+	link, err := mailURL(resetPagePath + "?token=" + url.QueryEscape(token))
+	if err != nil {
+		return err
+	}
+	return sendMail(user.Email, tr(c, msgResetSubject),
+		tr(c, msgResetBody, user.Name, link, reset.ExpiresAt.Format("2006-01-02 15:04 MST")))
+}
+
+// confirmPasswordReset sets the new password of a reset token. The token,
+// and every other token of the user, can't be used again, and the user is
+// logged out everywhere.
+func confirmPasswordReset(c *gin.Context) {
+	req := new(resetConfirmation)
+	if err := bindValid(c, req, "Token", "Password"); err != nil {
+		respondError(c, err)
+		return
+	}
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		var userId int64
+		_, err := tx.QueryOne(pg.Scan(&userId), `UPDATE password_resets SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = ? AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP RETURNING user_id`, resetTokenHash(req.Token))
+		if err == pg.ErrNoRows {
+			return errResetLinkInvalid
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND used_at IS NULL`, userId)
+		if err != nil {
+			return err
+		}
+		return setPassword(tx, userId, req.Password, true)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": tr(c, msgPasswordChanged)})
+}
+
+// changeOwnPassword lets the user of the request change their password
+// knowing the current one.
+func changeOwnPassword(c *gin.Context) {
+	req := new(passwordChange)
+	if err := bindValid(c, req, "CurrentPassword", "NewPassword"); err != nil {
+		respondError(c, err)
+		return
+	}
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		var hash string
+		_, err := tx.QueryOne(pg.Scan(&hash), `SELECT password FROM users WHERE id = ? FOR UPDATE`, c.GetInt64("id"))
+		if err != nil {
+			return err
+		}
+		if !checkPassword(hash, req.CurrentPassword) {
+			return errWrongPassword
+		}
+		return setPassword(tx, c.GetInt64("id"), req.NewPassword, false)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": tr(c, msgPasswordChanged)})
+}
+
+// setUserPassword lets a librarian set the password of any user, who is
+// logged out everywhere.
+func setUserPassword(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	id, err := pathId(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	req := new(passwordChange)
+	if err := bindValid(c, req, "NewPassword"); err != nil {
+		respondError(c, err)
+		return
+	}
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		return setPassword(tx, id, req.NewPassword, true)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": tr(c, msgPasswordChanged)})
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func init() {
+	// pdfcpu would otherwise create a configuration directory in $HOME
+	api.DisableConfigDir()
+}
+
+func pdfConfig() *pdfcpu.Configuration {
+	conf := pdfcpu.NewDefaultConfiguration()
+	conf.ValidationMode = pdfcpu.ValidationRelaxed
+	return conf
+}
+
+// parsePDF reads the document information dictionary and the XMP packet of
+// a PDF. XMP wins where both are present since it is the one kept up to
+// date by current authoring tools. The cover is rendered from the first
+// page when poppler's pdftoppm is installed, otherwise the largest image
+// on that page is used. Only a file that can't be read at all is an
+// error, the parts that fail validation are left out.
+func parsePDF(rs io.ReadSeeker) (*ebookMeta, error) {
+	ctx, err := api.ReadContext(rs, pdfConfig())
+	if err != nil {
+		return nil, err
+	}
+	if err := api.ValidateContext(ctx); err != nil {
+		log.Printf("pdf: %v", err)
+	}
+	if err := ctx.EnsurePageCount(); err != nil {
+		log.Printf("pdf: page count: %v", err)
+	}
+
+	meta := &ebookMeta{
+		Title:       strings.TrimSpace(ctx.Title),
+		ReleaseDate: parsePDFDate(ctx.CreationDate),
+		PageCount:   ctx.PageCount,
+	}
+	if author := strings.TrimSpace(ctx.Author); author != "" {
+		meta.Authors = splitAuthors(author)
+	}
+	if xmp := readXMP(ctx); xmp != nil {
+		if xmp.Title != "" {
+			meta.Title = xmp.Title
+		}
+		if len(xmp.Authors) > 0 {
+			meta.Authors = xmp.Authors
+		}
+		if !xmp.ReleaseDate.IsZero() {
+			meta.ReleaseDate = xmp.ReleaseDate
+		}
+		meta.Language = xmp.Language
+	}
+
+	if _, err := rs.Seek(0, io.SeekStart); err == nil {
+		meta.Cover = renderFirstPage(rs)
+	}
+	if meta.Cover == nil && ctx.PageCount > 0 {
+		meta.Cover = largestPageImage(ctx, 1)
+	}
+	return meta, nil
+}
+
+// splitAuthors splits the free-form Author entry ("A; B", "A, B and C").
+func splitAuthors(s string) []string {
+	var authors []string
+	for _, part := range strings.FieldsFunc(strings.Replace(s, " and ", ";", -1), func(r rune) bool {
+		return r == ';' || r == ','
+	}) {
+		if part = strings.TrimSpace(part); part != "" {
+			authors = append(authors, part)
+		}
+	}
+	return authors
+}
+
+// parsePDFDate parses the date format of PDF 32000 7.9.4
+// (D:YYYYMMDDHHmmSSOHH'mm), of which only the date part is needed.
+func parsePDFDate(s string) time.Time {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	for _, layout := range []string{"20060102", "200601", "2006"} {
+		if len(s) >= len(layout) {
+			if t, err := time.Parse(layout, s[:len(layout)]); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func readXMP(ctx *pdfcpu.Context) *ebookMeta {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return nil
+	}
+	o, found := catalog.Find("Metadata")
+	if !found || o == nil {
+		return nil
+	}
+	sd, _, err := ctx.DereferenceStreamDict(o)
+	if err != nil || sd == nil {
+		return nil
+	}
+	if err := sd.Decode(); err != nil {
+		return nil
+	}
+	return parseXMP(sd.Content)
+}
+
+func parseXMP(data []byte) *ebookMeta {
+	var packet struct {
+		Descriptions []struct {
+			Titles     []string `xml:"title>Alt>li"`
+			Creators   []string `xml:"creator>Seq>li"`
+			Languages  []string `xml:"language>Bag>li"`
+			CreateDate string   `xml:"CreateDate"`
+			CreateAttr string   `xml:"CreateDate,attr"`
+			Dates      []string `xml:"date>Seq>li"`
+		} `xml:"RDF>Description"`
+	}
+	if err := xml.Unmarshal(data, &packet); err != nil {
+		return nil
+	}
+	meta := &ebookMeta{}
+	for _, d := range packet.Descriptions {
+		if len(d.Titles) > 0 && meta.Title == "" {
+			meta.Title = strings.TrimSpace(d.Titles[0])
+		}
+		for _, creator := range d.Creators {
+			if creator = strings.TrimSpace(creator); creator != "" {
+				meta.Authors = append(meta.Authors, creator)
+			}
+		}
+		if len(d.Languages) > 0 && meta.Language == "" {
+			meta.Language = strings.TrimSpace(d.Languages[0])
+		}
+		if len(d.Dates) > 0 && meta.ReleaseDate.IsZero() {
+			meta.ReleaseDate = parseLooseDate(d.Dates[0])
+		}
+		if d.CreateDate == "" {
+			d.CreateDate = d.CreateAttr
+		}
+		if d.CreateDate != "" && meta.ReleaseDate.IsZero() {
+			meta.ReleaseDate = parseLooseDate(d.CreateDate)
+		}
+	}
+	return meta
+}
+
+func renderFirstPage(r io.Reader) image.Image {
+	pdftoppm, err := exec.LookPath("pdftoppm")
+	if err != nil {
+		return nil
+	}
+	dir, err := ioutil.TempDir("", "cover")
+	if err != nil {
+		return nil
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "book.pdf")
+	f, err := os.Create(in)
+	if err != nil {
+		return nil
+	}
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		return nil
+	}
+	out := filepath.Join(dir, "cover")
+	cmd := exec.Command(pdftoppm, "-f", "1", "-l", "1", "-singlefile", "-jpeg", "-scale-to", "1200", in, out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		log.Println("pdftoppm:", err, string(output))
+		return nil
+	}
+	data, err := ioutil.ReadFile(out + ".jpg")
+	if err != nil {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// largestPageImage decodes the largest image of the page within
+// maxImagePixels, the others are only measured.
+func largestPageImage(ctx *pdfcpu.Context, pageNr int) image.Image {
+	if err := api.OptimizeContext(ctx); err != nil {
+		return nil
+	}
+	images, err := ctx.ExtractPageImages(pageNr, false)
+	if err != nil {
+		return nil
+	}
+	var best []byte
+	var bestArea int64
+	for _, pi := range images {
+		data, err := ioutil.ReadAll(pi)
+		if err != nil {
+			continue
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || checkImageSize(config) != nil {
+			continue
+		}
+		if area := int64(config.Width) * int64(config.Height); best == nil || area > bestArea {
+			best, bestArea = data, area
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(best))
+	if err != nil {
+		return nil
+	}
+	return img
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rentalQuery filters the rental history. Every filter is optional, date
+// bounds that are not given are open.
+type rentalQuery struct {
+	TimeIntervalsForHistory
+	BookId   int64
+	ReaderId int64
+	Status   string `binding:"omitempty,oneof=open closed" doc:"open for books not returned yet, closed for returned ones"`
+	Sort     string `binding:"omitempty,oneof=rental_date -rental_date return_date -return_date book -book reader -reader" doc:"Defaults to -rental_date, a minus sorts descending"`
+	Offset   int32  `binding:"omitempty,min=0"`
+	Limit    int32  `binding:"omitempty,min=1,max=100" doc:"Defaults to 20"`
+}
+
+// namedRental is a rental with the names of the book and the reader.
+type namedRental struct {
+	RentalHistory
+	Book   string
+	Reader string
+}
+
+// rentalsFrom joins the names of namedRental.
+const rentalsFrom = `rental_history r JOIN book b ON b.book_id = r.book_id JOIN reader rd ON rd.reader_id = r.reader_id`
+
+var rentalSorts = map[string]string{
+	"rental_date":  "r.rental_date, r.rental_id",
+	"-rental_date": "r.rental_date DESC, r.rental_id DESC",
+	"return_date":  "r.return_date, r.rental_id",
+	"-return_date": "r.return_date DESC, r.rental_id DESC",
+	"book":         "b.name, r.rental_id",
+	"-book":        "b.name DESC, r.rental_id DESC",
+	"reader":       "rd.name, r.rental_id",
+	"-reader":      "rd.name DESC, r.rental_id DESC",
+}
+
+// where returns the conditions on rental_history r and their parameters.
+func (q *rentalQuery) where() ([]string, []interface{}) {
+	var where []string
+	var params []interface{}
+	for _, bound := range []struct {
+		condition string
+		value     time.Time
+	}{
+		{"r.rental_date >= ?", q.RentalDateFrom},
+		{"r.rental_date <= ?", q.RentalDateTo},
+		{"r.return_date >= ?", q.ReturnDateFrom},
+		{"r.return_date <= ?", q.ReturnDateTo},
+	} {
+		if !bound.value.IsZero() {
+			where = append(where, bound.condition)
+			params = append(params, bound.value)
+		}
+	}
+	if q.BookId != 0 {
+		where = append(where, "r.book_id = ?")
+		params = append(params, q.BookId)
+	}
+	if q.ReaderId != 0 {
+		where = append(where, "r.reader_id = ?")
+		params = append(params, q.ReaderId)
+	}
+	switch q.Status {
+	case "open":
+		where = append(where, "r.return_date IS NULL")
+	case "closed":
+		where = append(where, "r.return_date IS NOT NULL")
+	}
+	return where, params
+}
+
+// listRentals returns a page of the rental history with the names of the
+// books and readers, and how many rentals match in total.
+func listRentals(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	var q rentalQuery
+	if err := bindValid(c, &q); err != nil {
+		respondError(c, err)
+		return
+	}
+	if q.Limit == 0 {
+		q.Limit = 20
+	}
+	order, ok := rentalSorts[q.Sort]
+	if !ok {
+		order = rentalSorts["-rental_date"]
+	}
+	where, params := q.where()
+	rentals := []namedRental{}
+	total, err := queryPage(&rentals, "r.*, b.name AS book, rd.name AS reader", rentalsFrom, where, params, order, q.Limit, q.Offset)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": rentals, "total": total})
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+)
+
+const apiV1 = "/api/v1"
+
+// resource describes a table exposed under /api/v1/<path>/:id. Handlers
+// for the common cases are shared, books add their own create and list.
+type resource struct {
+	path     string
+	table    string
+	idColumn string
+	// columns are selected and returned by every query
+	columns string
+	// writable maps the JSON fields accepted by POST, PUT and PATCH to
+	// their columns
+	writable map[string]string
+	// deleteGuard is a condition the row has to meet to be deleted, rows
+	// that fail it are still in use
+	deleteGuard string
+	// required fields have to be set by POST and PUT
+	required []string
+
+	// model is the zero value of the row struct
+	model interface{}
+	idOf  func(model interface{}) int64
+	// present converts a model to the response of type view, both
+	// default to the model
+	present func(model interface{}) interface{}
+	view    interface{}
+
+	msgCreated, msgUpdated string
+	// private resources can be read by librarians only, writes always
+	// need a librarian
+	private bool
+}
+
+var (
+	authorResource = resource{
+		path: "authors", table: "author", idColumn: "author_id", columns: "*",
+		writable:   map[string]string{"AuthorName": "author_name"},
+		required:   []string{"AuthorName"},
+		model:      Author{},
+		idOf:       func(m interface{}) int64 { return m.(*Author).AuthorId },
+		msgCreated: msgAuthorCreated, msgUpdated: msgAuthorUpdated,
+	}
+	genreResource = resource{
+		path: "genres", table: "genre", idColumn: "genre_id", columns: "*",
+		writable:   map[string]string{"Genre": "genre"},
+		required:   []string{"Genre"},
+		model:      Genre{},
+		idOf:       func(m interface{}) int64 { return m.(*Genre).GenreId },
+		msgCreated: msgGenreCreated, msgUpdated: msgGenreUpdated,
+	}
+	readerResource = resource{
+		path: "readers", table: "reader", idColumn: "reader_id", columns: "*",
+		writable:    map[string]string{"Name": "name", "BirthDate": "birth_date"},
+		required:    []string{"Name", "BirthDate"},
+		deleteGuard: "NOT EXISTS (SELECT 1 FROM book b WHERE b.current_reader = reader.reader_id)",
+		model:       Reader{},
+		idOf:        func(m interface{}) int64 { return m.(*Reader).ReaderId },
+		msgCreated:  msgReaderCreated, msgUpdated: msgReaderUpdated,
+		private: true,
+	}
+	bookResource = resource{
+		path: "books", table: "book", idColumn: "book_id", columns: "*",
+		writable: map[string]string{
+			"Name": "name", "AuthorId": "author_id", "GenreId": "genre_id",
+			"ReleaseDate": "release_date", "Language": "language", "PageCount": "page_count",
+			"Isbn": "isbn",
+		},
+		required:    []string{"Name", "AuthorId", "ReleaseDate"},
+		deleteGuard: "current_reader IS NULL",
+		model:       Book{},
+		idOf:        func(m interface{}) int64 { return m.(*Book).BookId },
+		present:     presentBook,
+		view:        bookDetails{},
+		msgCreated:  msgBookCreated, msgUpdated: msgBookUpdated,
+		private: true,
+	}
+	userResource = resource{
+		path: "users", table: "users", idColumn: "id", columns: "id, name, reader_id, version",
+		writable:   map[string]string{"Name": "name"},
+		required:   []string{"Name"},
+		private:    true,
+		model:      Users{},
+		idOf:       func(m interface{}) int64 { return m.(*Users).Id },
+		present:    presentUser,
+		view:       publicUser{},
+		msgCreated: msgUserCreated, msgUpdated: msgUserUpdated,
+	}
+	roleResource = resource{
+		path: "roles", table: "roles", idColumn: "id", columns: "*",
+		writable:   map[string]string{"Role": "role"},
+		required:   []string{"Role"},
+		private:    true,
+		model:      Roles{},
+		idOf:       func(m interface{}) int64 { return m.(*Roles).Id },
+		msgCreated: msgRoleCreated, msgUpdated: msgRoleChanged,
+	}
+)
+
+// register adds the routes of the resource to the group. list and create
+// default to the generic handlers when nil. Only librarians may write, and
+// read private resources.
+func (res resource) register(g *gin.RouterGroup, list, create gin.HandlerFunc) *gin.RouterGroup {
+	if list == nil {
+		list = res.list
+	}
+	if create == nil {
+		create = res.create
+	}
+	rg := g.Group(res.path)
+	if res.private {
+		rg.Use(librarianOnly)
+	}
+	rg.GET("", list)
+	rg.POST("", librarianOnly, create)
+	rg.GET(":id", res.get)
+	rg.PUT(":id", librarianOnly, res.replace)
+	rg.PATCH(":id", librarianOnly, res.patch)
+	rg.DELETE(":id", librarianOnly, res.remove)
+	return rg
+}
+
+// librarianOnly stops requests of users who are not librarians.
+func librarianOnly(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	c.Next()
+}
+
+func (res resource) newModel() interface{} {
+	return reflect.New(reflect.TypeOf(res.model)).Interface()
+}
+
+func (res resource) location(id int64) string {
+	return fmt.Sprintf("%s/%s/%d", apiV1, res.path, id)
+}
+
+func (res resource) render(model interface{}) interface{} {
+	if res.present != nil {
+		return res.present(model)
+	}
+	return model
+}
+
+func (res resource) find(id int64) (interface{}, error) {
+	model := res.newModel()
+	_, err := db.QueryOne(model, fmt.Sprintf(`SELECT %s FROM %s WHERE %s = ?`, res.columns, res.table, res.idColumn), id)
+	return model, err
+}
+
+// all returns a pointer to a slice of every row.
+func (res resource) all() (interface{}, error) {
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(res.model))).Interface()
+	_, err := db.Query(rows, fmt.Sprintf(`SELECT %s FROM %s ORDER BY %s`, res.columns, res.table, res.idColumn))
+	return rows, err
+}
+
+// insert adds model, writing the given fields.
+func (res resource) insert(model interface{}, fields []string) error {
+	columns := res.columnsOf(fields)
+	if len(columns) == 0 {
+		return newAPIError(http.StatusBadRequest, codeBadRequest, msgEmptyBody)
+	}
+	if err := validate(model, res.required...); err != nil {
+		return err
+	}
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = "?" + column
+	}
+	_, err := db.QueryOne(model, fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s) RETURNING %s`,
+		res.table, strings.Join(columns, ", "), strings.Join(values, ", "), res.columns), model)
+	return err
+}
+
+// save writes the given fields of model to row id if it is still at
+// version. Without fields the row is replaced: every writable column is
+// written and the required fields have to be set.
+func (res resource) save(id, version int64, model interface{}, fields []string) error {
+	var required []string
+	if fields == nil {
+		required = res.required
+		fields = res.writableFields()
+	}
+	if err := validate(model, required...); err != nil {
+		return err
+	}
+	columns := res.columnsOf(fields)
+	set := make([]string, len(columns))
+	for i, column := range columns {
+		set[i] = column + " = ?" + column
+	}
+	_, err := db.QueryOne(model, fmt.Sprintf(`UPDATE %s SET %s, version = version + 1 WHERE %s = ?0 AND version = COALESCE(NULLIF(?1, 0), version) RETURNING %s`,
+		res.table, strings.Join(set, ", "), res.idColumn, res.columns), id, version, model)
+	if err == pg.ErrNoRows {
+		err = missedRow(res.table, res.idColumn, id, version, errVersionChanged)
+	}
+	return err
+}
+
+// delete removes row id if it is still at version and passes deleteGuard.
+func (res resource) delete(id, version int64) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE %s = ?0 AND version = COALESCE(NULLIF(?1, 0), version)`, res.table, res.idColumn)
+	if res.deleteGuard != "" {
+		query += " AND " + res.deleteGuard
+	}
+	result, err := db.Exec(query, id, version)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		// tell a missing or changed row from one the guard kept
+		return missedRow(res.table, res.idColumn, id, version,
+			newAPIError(http.StatusConflict, codeConflict, msgStillInUse))
+	}
+	return nil
+}
+
+func (res resource) list(c *gin.Context) {
+	all, err := res.all()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	rows := reflect.ValueOf(all).Elem()
+	result := make([]interface{}, rows.Len())
+	for i := range result {
+		result[i] = res.render(rows.Index(i).Addr().Interface())
+	}
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
+
+func (res resource) get(c *gin.Context) {
+	id, err := pathId(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	model, err := res.find(id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if notModified(c, versionOf(model)) {
+		return
+	}
+	setETag(c, versionOf(model))
+	c.JSON(http.StatusOK, res.render(model))
+}
+
+func (res resource) create(c *gin.Context) {
+	model := res.newModel()
+	fields, err := res.decode(c, model)
+	if err == nil {
+		err = res.insert(model, fields)
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	setETag(c, versionOf(model))
+	respondCreated(c, res.location(res.idOf(model)), res.msgCreated, res.render(model))
+}
+
+// replace sets every writable column, the ones missing from the body are
+// reset to their zero value.
+func (res resource) replace(c *gin.Context) {
+	res.update(c, false)
+}
+
+// patch changes only the fields present in the body.
+func (res resource) patch(c *gin.Context) {
+	res.update(c, true)
+}
+
+func (res resource) update(c *gin.Context, partial bool) {
+	id, err := pathId(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	version, err := ifMatch(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	model := res.newModel()
+	if partial {
+		// decoding onto the current row leaves absent fields unchanged
+		if model, err = res.find(id); err != nil {
+			respondError(c, err)
+			return
+		}
+		if version != anyVersion && versionOf(model) != version {
+			respondError(c, errVersionChanged)
+			return
+		}
+	}
+	fields, err := res.decode(c, model)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if !partial {
+		fields = nil
+	} else if len(fields) == 0 {
+		setETag(c, versionOf(model))
+		c.JSON(http.StatusOK, gin.H{"message": tr(c, res.msgUpdated), "result": res.render(model)})
+		return
+	}
+	if err := res.save(id, version, model, fields); err != nil {
+		respondError(c, err)
+		return
+	}
+	setETag(c, versionOf(model))
+	c.JSON(http.StatusOK, gin.H{"message": tr(c, res.msgUpdated), "result": res.render(model)})
+}
+
+func (res resource) remove(c *gin.Context) {
+	id, err := pathId(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	version, err := ifMatch(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if err := res.delete(id, version); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// decode unmarshals the JSON body onto model and returns the fields it
+// contained. Fields that are not writable are rejected.
+func (res resource) decode(c *gin.Context, model interface{}) ([]string, error) {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(body, &present); err != nil {
+		return nil, err
+	}
+	var fields, readOnly []string
+	for key := range present {
+		field, ok := res.writableField(key)
+		if !ok {
+			readOnly = append(readOnly, key)
+			continue
+		}
+		fields = append(fields, field)
+	}
+	if len(readOnly) > 0 {
+		sort.Strings(readOnly)
+		return nil, newAPIError(http.StatusUnprocessableEntity, codeValidationFailed, msgReadOnlyFields).withDetails(readOnly)
+	}
+	return fields, json.Unmarshal(body, model)
+}
+
+// writableField matches key the way encoding/json matches field names.
+func (res resource) writableField(key string) (string, bool) {
+	for field := range res.writable {
+		if strings.EqualFold(field, key) {
+			return field, true
+		}
+	}
+	return "", false
+}
+
+func (res resource) writableFields() []string {
+	fields := make([]string, 0, len(res.writable))
+	for field := range res.writable {
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// fieldOf returns the writable field stored in column.
+func (res resource) fieldOf(column string) (string, bool) {
+	for field, c := range res.writable {
+		if c == column {
+			return field, true
+		}
+	}
+	return "", false
+}
+
+func (res resource) columnsOf(fields []string) []string {
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		columns = append(columns, res.writable[field])
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// pathId parses the :id route parameter.
+func pathId(c *gin.Context) (int64, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, newAPIError(http.StatusBadRequest, codeBadRequest, msgInvalidId).withDetails(c.Param("id"))
+	}
+	return id, nil
+}
+
+func respondCreated(c *gin.Context, location string, key string, result interface{}) {
+	c.Header("Location", location)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": tr(c, key),
+		"result":  result,
+	})
+}
+
+// publicUser is a user without the password.
+type publicUser struct {
+	Id       int64
+	Name     string
+	ReaderId int64 `doc:"Reader the user borrows as, 0 when not linked"`
+	Version  int64
+}
+
+func presentUser(m interface{}) interface{} {
+	user := m.(*Users)
+	return publicUser{user.Id, user.Name, user.ReaderId, user.Version}
+}
+
+// bookDetails is a book with its files and cover thumbnails.
+type bookDetails struct {
+	*Book
+	Files         []BookFile        `doc:"Downloadable formats of the book"`
+	ImageVariants map[string]string `doc:"Cover thumbnails by size name"`
+}
+
+func presentBook(m interface{}) interface{} {
+	book := m.(*Book)
+	var files []BookFile
+	if _, err := db.Query(&files, `SELECT * FROM book_files WHERE book_id = ? ORDER BY format`, book.BookId); err != nil {
+		files = nil
+	}
+	return bookDetails{book, files, coverVariants(book.ImageFilepath)}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/golang-jwt/jwt"
+)
+
+// Readers sign up themselves: the account stays inactive until they open
+// the link emailed to them.
+
+// verifyLinkTTL is how long a verification link works.
+var verifyLinkTTL = time.Duration(envInt64("VERIFY_LINK_HOURS", 24)) * time.Hour
+
+// verifyAudience marks verification links, validateToken refuses tokens
+// with an audience as access tokens.
+const verifyAudience = "verify-email"
+
+type jwtMailClaims struct {
+	Id    int64
+	Email string
+	jwt.StandardClaims
+}
+
+var errVerifyLinkInvalid = newAPIError(http.StatusBadRequest, codeBadRequest, msgVerifyLinkInvalid)
+
+type resendRequest struct {
+	Email string `binding:"omitempty,email,max=254"`
+}
+
+func signup(c *gin.Context) {
+	r := new(registration)
+	if err := bindValid(c, r, "Name", "Password", "BirthDate", "Email"); err != nil {
+		respondError(c, err)
+		return
+	}
+	var user *Users
+	err := db.RunInTransaction(func(tx *pg.Tx) (err error) {
+		if user, err = registerUser(tx, r, false); err != nil {
+			return err
+		}
+		// an email that can't be sent rolls the user back, the reader can
+		// sign up again with the same name
+		return sendVerification(c, user)
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondCreated(c, userResource.location(user.Id), msgSignupVerify, presentUser(user))
+}
+
+// sendVerification emails the link that activates user. The link names the
+// email, so it stops working when the email changes.
+func sendVerification(c *gin.Context, user *Users) error {
+	expires := time.Now().Add(verifyLinkTTL)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwtMailClaims{
+		Id:    user.Id,
+		Email: user.Email,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  verifyAudience,
+			ExpiresAt: expires.Unix(),
+		},
+	}).SignedString(jwtKey)
+	if err != nil {
+		return err
+	}
+	link, err := mailURL(apiV1 + "/verify-email?token=" + url.QueryEscape(token))
+	if err != nil {
+		return err
+	}
+	return sendMail(user.Email, tr(c, msgVerifySubject),
+		tr(c, msgVerifyBody, user.Name, link, expires.Format("2006-01-02 15:04 MST")))
+}
+
+// verifyEmail activates the user of a verification link. Opening the link
+// again is fine.
+func verifyEmail(c *gin.Context) {
+	var claims jwtMailClaims
+	_, err := jwt.ParseWithClaims(c.Query("token"), &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return jwtKey, nil
+	})
+	if err != nil || !claims.VerifyAudience(verifyAudience, true) {
+		respondError(c, errVerifyLinkInvalid)
+		return
+	}
+	result, err := db.Exec(`UPDATE users SET active = TRUE, verified_at = CURRENT_TIMESTAMP, version = version + 1
+WHERE id = ? AND email = ? AND verified_at IS NULL`, claims.Id, claims.Email)
+	if err == nil && result.RowsAffected() == 0 {
+		var verified bool
+		_, err = db.QueryOne(pg.Scan(&verified), `SELECT EXISTS (SELECT 1 FROM users WHERE id = ? AND email = ? AND verified_at IS NOT NULL)`,
+			claims.Id, claims.Email)
+		if err == nil && !verified {
+			err = errVerifyLinkInvalid
+		}
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": tr(c, msgEmailVerified)})
+}
+
+// resendVerification sends a new link to an account that is not verified
+// yet. Unknown addresses get the same answer, so it can't tell which
+// emails have accounts.
+func resendVerification(c *gin.Context) {
+	req := new(resendRequest)
+	if err := bindValid(c, req, "Email"); err != nil {
+		respondError(c, err)
+		return
+	}
+	user := new(Users)
+	_, err := db.QueryOne(user, `SELECT * FROM users WHERE email = ? AND verified_at IS NULL AND NOT active`, req.Email)
+	if err == nil {
+		err = sendVerification(c, user)
+	} else if err == pg.ErrNoRows {
+		err = nil
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": tr(c, msgVerifyResent)})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	booksDir  = "nginx-1.21.1/resources/books/"
+	imagesDir = "nginx-1.21.1/resources/images/"
+)
+
+// Upload limits in bytes, overridable with MAX_BOOK_SIZE and MAX_IMAGE_SIZE.
+var (
+	maxBookSize  = envInt64("MAX_BOOK_SIZE", 100<<20)
+	maxImageSize = envInt64("MAX_IMAGE_SIZE", 10<<20)
+	// maxImagePixels caps width times height of a cover, a small file can
+	// decode to an image that doesn't fit in memory.
+	maxImagePixels = envInt64("MAX_IMAGE_PIXELS", 40<<20)
+)
+
+// coverSizes are the thumbnail widths generated for every cover. A
+// thumbnail is stored next to the cover as <name>_<size>.jpg.
+var coverSizes = []struct {
+	Name  string
+	Width int
+}{
+	{"small", 150},
+	{"medium", 300},
+}
+
+var coverTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt64(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// sniffFile detects the content type of an uploaded file from its first
+// bytes, ignoring the name and Content-Type sent by the client.
+func sniffFile(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := f.Read(buf)
+	if err != nil && n == 0 {
+		return "", err
+	}
+	contentType := http.DetectContentType(buf[:n])
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return contentType, nil
+}
+
+func checkUpload(fh *multipart.FileHeader, maxSize int64, allowed map[string]bool) (string, error) {
+	if fh.Size > maxSize {
+		return "", newAPIError(http.StatusRequestEntityTooLarge, codePayloadTooLarge, msgFileTooLarge, fh.Filename, maxSize)
+	}
+	contentType, err := sniffFile(fh)
+	if err != nil {
+		return "", err
+	}
+	if !allowed[contentType] {
+		return "", newAPIError(http.StatusUnsupportedMediaType, codeUnsupportedMedia, msgUnsupportedImage, fh.Filename, contentType)
+	}
+	return contentType, nil
+}
+
+// saveCover decodes the uploaded cover and writes it re-encoded as JPEG,
+// which drops EXIF and any other metadata, together with its thumbnails.
+// It returns the path of the full size cover.
+func saveCover(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	img, err := decodeImage(f)
+	if err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(filepath.Base(fh.Filename), filepath.Ext(fh.Filename))
+	return writeCover(img, base)
+}
+
+// decodeImage decodes an untrusted image. The dimensions are checked
+// against maxImagePixels before the pixels are decoded.
+func decodeImage(rs io.ReadSeeker) (image.Image, error) {
+	config, _, err := image.DecodeConfig(rs)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkImageSize(config); err != nil {
+		return nil, err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(rs)
+	return img, err
+}
+
+func checkImageSize(config image.Config) error {
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return fmt.Errorf("%dx%d pixels, at most %d allowed", config.Width, config.Height, maxImagePixels)
+	}
+	return nil
+}
+
+func writeCover(img image.Image, base string) (string, error) {
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		return "", err
+	}
+	name, err := uniqueName(base + ".jpg")
+	if err != nil {
+		return "", err
+	}
+	path := imagesDir + name
+	if err := writeJPEG(path, img); err != nil {
+		return "", err
+	}
+	for _, size := range coverSizes {
+		if err := writeJPEG(coverVariantPath(path, size.Name), resizeToWidth(img, size.Width)); err != nil {
+			removeCover(path)
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// uniqueName adds a random suffix to the base name of an uploaded file, so
+// uploads with the same name don't overwrite each other.
+func uniqueName(filename string) (string, error) {
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	base := filepath.Base(filename)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "_" + hex.EncodeToString(random) + ext, nil
+}
+
+func writeJPEG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 90}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func resizeToWidth(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width {
+		return img
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+func coverVariantPath(path, size string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_" + size + ext
+}
+
+// removeFiles deletes the files written for a request that failed.
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("remove %s: %v", path, err)
+		}
+	}
+}
+
+// removeCover deletes a cover and its thumbnails.
+func removeCover(path string) {
+	if path == "" {
+		return
+	}
+	paths := []string{path}
+	for _, size := range coverSizes {
+		paths = append(paths, coverVariantPath(path, size.Name))
+	}
+	removeFiles(paths)
+}
+
+// coverVariants lists the cover and its thumbnails by size name.
+func coverVariants(path string) map[string]string {
+	if path == "" {
+		return nil
+	}
+	variants := map[string]string{"original": path}
+	for _, size := range coverSizes {
+		// covers uploaded before thumbnails existed have none
+		variant := coverVariantPath(path, size.Name)
+		if _, err := os.Stat(variant); err == nil {
+			variants[size.Name] = variant
+		}
+	}
+	return variants
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+)
+
+// registration is the body of POST /users: the login and the reader the
+// user borrows as.
+type registration struct {
+	Name      string    `binding:"omitempty,max=20"`
+	Password  string    `binding:"omitempty,min=4,max=72"`
+	FullName  string    `binding:"omitempty,max=50" doc:"Name of the reader, defaults to Name"`
+	BirthDate time.Time `binding:"omitempty,past" doc:"Birth date of the reader"`
+	Email     string    `binding:"omitempty,email,max=254" doc:"Required by sign-up, where it gets the verification link"`
+}
+
+// registerUser adds the user with the reader role and a reader of its own.
+// Inactive users can't log in until they verify the email.
+func registerUser(tx *pg.Tx, r *registration, active bool) (*Users, error) {
+	reader := &Reader{Name: r.FullName, BirthDate: r.BirthDate}
+	if reader.Name == "" {
+		reader.Name = r.Name
+	}
+	_, err := tx.QueryOne(reader, `INSERT INTO reader (name, birth_date) VALUES (?name, ?birth_date) RETURNING *`, reader)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(r.Password)
+	if err != nil {
+		return nil, err
+	}
+	user := &Users{Name: r.Name, Password: hash, ReaderId: reader.ReaderId, Email: r.Email}
+	_, err = tx.QueryOne(user, `INSERT INTO users (name, password, reader_id, email, active) VALUES (?name, ?password, ?reader_id, ?email, ?0) RETURNING *`,
+		active, user)
+	if err != nil {
+		return nil, err
+	}
+	user.Role = "reader"
+	_, err = tx.Exec(`INSERT INTO user_roles (user_id, role_id) SELECT ?, id FROM roles WHERE role = ?`, user.Id, user.Role)
+	return user, err
+}
+
+// bindRegistration reads a registration and adds the user.
+func bindRegistration(c *gin.Context) (*Users, error) {
+	r := new(registration)
+	if err := bindValid(c, r, "Name", "Password", "BirthDate"); err != nil {
+		return nil, err
+	}
+	var user *Users
+	err := db.RunInTransaction(func(tx *pg.Tx) (err error) {
+		user, err = registerUser(tx, r, true)
+		return err
+	})
+	return user, err
+}
+
+func createUser(c *gin.Context) {
+	user, err := bindRegistration(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	setETag(c, user.Version)
+	respondCreated(c, userResource.location(user.Id), msgUserCreated, presentUser(user))
+}
+
+// readerLink is the body of PUT /users/:id/reader.
+type readerLink struct {
+	ReaderId int64
+}
+
+// linkReader lets a librarian link an existing reader to an account, a
+// reader belongs to one user at most.
+func linkReader(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	id, err := pathId(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	link := new(readerLink)
+	if err := bindValid(c, link, "ReaderId"); err != nil {
+		respondError(c, err)
+		return
+	}
+	user := new(Users)
+	_, err = db.QueryOne(user, `UPDATE users SET reader_id = r.reader_id, version = users.version + 1 FROM reader r
+WHERE users.id = ? AND r.reader_id = ? RETURNING users.id, users.name, users.reader_id, users.version`, id, link.ReaderId)
+	respondLink(c, user, err, msgReaderLinked)
+}
+
+func unlinkReader(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	id, err := pathId(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	user := new(Users)
+	_, err = db.QueryOne(user, `UPDATE users SET reader_id = NULL, version = version + 1 WHERE id = ? RETURNING id, name, reader_id, version`, id)
+	respondLink(c, user, err, msgReaderUnlinked)
+}
+
+func respondLink(c *gin.Context, user *Users, err error, key string) {
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	setETag(c, user.Version)
+	c.JSON(http.StatusOK, gin.H{"message": tr(c, key), "result": presentUser(user)})
+}
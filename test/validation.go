@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/text/message"
+)
+
+// Struct tags only describe the format of a field and let zero values
+// through (omitempty), since the same types are used to create, update and
+// delete. Which fields a handler needs is given to bindValid.
+
+func init() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	v.RegisterValidation("past", validPast)
+	v.RegisterValidation("isbn", validISBN)
+	v.RegisterValidation("role", validRole)
+}
+
+func validPast(fl validator.FieldLevel) bool {
+	t, ok := fl.Field().Interface().(time.Time)
+	return ok && t.Before(time.Now())
+}
+
+// validISBN accepts ISBN-10 and ISBN-13 with optional hyphens or spaces.
+// Unlike the built-in isbn rule an ISBN-13 has to carry the 978 or 979
+// Bookland prefix.
+func validISBN(fl validator.FieldLevel) bool {
+	s := strings.NewReplacer("-", "", " ", "").Replace(fl.Field().String())
+	switch len(s) {
+	case 10:
+		sum := 0
+		for i, r := range s {
+			var d int
+			switch {
+			case r >= '0' && r <= '9':
+				d = int(r - '0')
+			case (r == 'X' || r == 'x') && i == 9:
+				d = 10
+			default:
+				return false
+			}
+			sum += d * (10 - i)
+		}
+		return sum%11 == 0
+	case 13:
+		if !strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979") {
+			return false
+		}
+		sum := 0
+		for i, r := range s {
+			if r < '0' || r > '9' {
+				return false
+			}
+			d := int(r - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+var roleName = regexp.MustCompile(`^[a-z][a-z_]{2,19}$`)
+
+// validRole checks the syntax of a role name, lower case letters and
+// underscores that fit roles.role.
+func validRole(fl validator.FieldLevel) bool {
+	return roleName.MatchString(fl.Field().String())
+}
+
+// fieldError is one entry of the details of a validation_failed error.
+type fieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+	kind    reflect.Kind
+}
+
+// validationError reports errs as a field list.
+func validationError(errs []fieldError) *apiError {
+	return newAPIError(http.StatusUnprocessableEntity, codeValidationFailed, msgValidationFailed).withDetails(errs)
+}
+
+func fieldErrors(errs validator.ValidationErrors) []fieldError {
+	list := make([]fieldError, len(errs))
+	for i, e := range errs {
+		list[i] = fieldError{Field: e.Field(), Rule: e.Tag(), Param: e.Param(), kind: e.Kind()}
+	}
+	return list
+}
+
+// bindValid binds the request like c.ShouldBind and additionally requires
+// the named fields to be set. All problems are reported together.
+func bindValid(c *gin.Context, obj interface{}, required ...string) error {
+	var errs []fieldError
+	err := c.ShouldBind(obj)
+	if verrs, ok := err.(validator.ValidationErrors); ok {
+		errs = fieldErrors(verrs)
+	} else if err != nil {
+		return err
+	}
+	errs = append(errs, missingFields(obj, required)...)
+	if len(errs) > 0 {
+		return validationError(errs)
+	}
+	return nil
+}
+
+// validate checks obj against its tags and the required fields.
+func validate(obj interface{}, required ...string) error {
+	var errs []fieldError
+	err := binding.Validator.ValidateStruct(obj)
+	if verrs, ok := err.(validator.ValidationErrors); ok {
+		errs = fieldErrors(verrs)
+	} else if err != nil {
+		return err
+	}
+	errs = append(errs, missingFields(obj, required)...)
+	if len(errs) > 0 {
+		return validationError(errs)
+	}
+	return nil
+}
+
+func missingFields(obj interface{}, required []string) []fieldError {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	var errs []fieldError
+	for _, name := range required {
+		if f := v.FieldByName(name); !f.IsValid() || f.IsZero() {
+			errs = append(errs, fieldError{Field: name, Rule: "required"})
+		}
+	}
+	return errs
+}
+
+var ruleMessages = map[string]string{
+	"required": msgFieldRequired,
+	"past":     msgFieldPast,
+	"isbn":     msgFieldISBN,
+	"role":     msgFieldRole,
+	"email":    msgFieldEmail,
+	"min":      msgFieldMin,
+	"max":      msgFieldMax,
+	"gtefield": msgFieldNotBefore,
+	"unique":   msgFieldUnique,
+	"exists":   msgFieldExists,
+}
+
+var lengthMessages = map[string]string{
+	"min": msgFieldMinLength,
+	"max": msgFieldMaxLength,
+}
+
+// localizeFieldErrors fills in the messages of a field list.
+func localizeFieldErrors(p *message.Printer, errs []fieldError) []fieldError {
+	out := make([]fieldError, len(errs))
+	for i, e := range errs {
+		if key, ok := lengthMessages[e.Rule]; ok && e.kind == reflect.String {
+			n, _ := strconv.Atoi(e.Param)
+			e.Message = p.Sprintf(key, n)
+		} else if key, ok := ruleMessages[e.Rule]; ok {
+			e.Message = p.Sprintf(key, e.Param)
+		} else {
+			e.Message = p.Sprintf(msgInvalidValue)
+		}
+		out[i] = e
+	}
+	return out
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func TestValidISBN(t *testing.T) {
+	v := binding.Validator.Engine().(*validator.Validate)
+	tests := []struct {
+		isbn string
+		ok   bool
+	}{
+		{"0-306-40615-2", true},
+		{"0306406152", true},
+		{"0 306 40615 2", true},
+		{"080442957X", true},
+		{"080442957x", true},
+		{"0306406153", false},
+		{"X306406152", false},
+		{"978-3-16-148410-0", true},
+		{"9783161484100", true},
+		{"9791090636071", true},
+		{"9783161484101", false},
+		{"9773161484100", false},
+		{"978316148410X", false},
+		{"12345", false},
+	}
+	for _, tt := range tests {
+		if err := v.Var(tt.isbn, "isbn"); (err == nil) != tt.ok {
+			t.Errorf("isbn %q: got %v, want ok %v", tt.isbn, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiVersions are served side by side. A new version adds an entry with its
+// own register function and handlers, the routes of the older versions are
+// left as they are.
+var apiVersions = []struct {
+	prefix   string
+	register func(api *gin.RouterGroup)
+}{
+	{apiV1, registerV1},
+}
+
+func registerV1(api *gin.RouterGroup) {
+	api.POST("refresh", validateRefreshToken)
+	api.POST("login", login)
+	api.POST("signup", signup)
+	api.POST("signup/resend", resendVerification)
+	api.GET("verify-email", verifyEmail)
+	api.POST("password-reset", requestPasswordReset)
+	api.POST("password-reset/confirm", confirmPasswordReset)
+	api.GET("openapi.json", serveOpenAPI)
+	api.GET("docs/*file", serveSwaggerUI)
+	api.GET("oai", serveOAI)
+	api.POST("oai", serveOAI)
+	registerOPDS(api)
+
+	auth := api.Group("", verifyAccessToken)
+	authorResource.register(auth, nil, nil)
+	genreResource.register(auth, nil, nil)
+	readerResource.register(auth, nil, nil).GET("export", exportReaders)
+	users := userResource.register(auth, nil, createUser)
+	users.PUT(":id/reader", linkReader)
+	users.DELETE(":id/reader", unlinkReader)
+	users.PUT(":id/password", setUserPassword)
+	roleResource.register(auth, nil, nil)
+	books := bookResource.register(auth, showBooks, createBook)
+	books.POST(":id/files", addBookFile)
+	books.POST("marc", importMARC)
+	books.GET("marc", exportMARC)
+	books.GET("export", exportBooks)
+	auth.POST("book-drafts", draftBook)
+	auth.POST("graphql", serveGraphQL)
+
+	auth.GET("logout", logout)
+	auth.POST("change-password", changeOwnPassword)
+	auth.POST("return-book", returnBook)
+	auth.POST("rental-history", showHistory)
+	auth.GET("rental-history", listRentals)
+	auth.GET("rental-history/export", exportRentals)
+	registerMe(auth.Group("me"))
+	auth.POST("save", saveFile)
+	auth.POST("take-book", takeBook)
+	auth.POST("load-book", loadBook)
+	auth.GET("load-book/:token", loadBook)
+	auth.POST("revoke-book-token", revokeBookToken)
+	auth.POST("verify-watermark", verifyWatermark)
+}
+
+// The unversioned routes of the first releases keep working until
+// legacySunset. Their responses carry Deprecation and Sunset headers and a
+// Link to the route that replaced them.
+var (
+	legacyDeprecated = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	legacySunset     = time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// legacySuccessors maps a legacy route to its replacement. Path parameters
+// of the replacement are filled in from the request.
+var legacySuccessors = map[string]string{
+	"/refresh":           apiV1 + "/refresh",
+	"/login":             apiV1 + "/login",
+	"/api/authors":       apiV1 + "/authors",
+	"/api/genres":        apiV1 + "/genres",
+	"/api/readers":       apiV1 + "/readers",
+	"/api/books":         apiV1 + "/books",
+	"/api/books/files":   apiV1 + "/books",
+	"/api/books/draft":   apiV1 + "/book-drafts",
+	"/api/users/*id":     apiV1 + "/users",
+	"/api/users":         apiV1 + "/users",
+	"/api/roles/*id":     apiV1 + "/roles",
+	"/api/roles":         apiV1 + "/roles",
+	"/api/returnbook":    apiV1 + "/return-book",
+	"/api/rentalhistory": apiV1 + "/rental-history",
+	"/save":              apiV1 + "/save",
+	"/logout":            apiV1 + "/logout",
+	"/take-book":         apiV1 + "/take-book",
+	"/load-book":         apiV1 + "/load-book",
+	"/load-book/:token":  apiV1 + "/load-book/:token",
+	"/revoke-book-token": apiV1 + "/revoke-book-token",
+	"/verify-watermark":  apiV1 + "/verify-watermark",
+}
+
+// registerLegacy adds the legacy routes with the access rules of v1: only
+// librarians write, and read readers, books, users and roles.
+func registerLegacy(r *gin.Engine) {
+	legacy := r.Group("", deprecated)
+	legacy.POST("refresh", validateRefreshToken)
+	legacy.POST("login", login)
+
+	legacy.Use(verifyAccessToken)
+
+	authorsApi := legacy.Group("api/authors")
+	authorsApi.GET("", allAuthors)
+	authorsApi.POST("", librarianOnly, createAuthor)
+	authorsApi.DELETE("", librarianOnly, deleteAuthor)
+	authorsApi.PUT("", librarianOnly, updateAuthor)
+
+	genreApi := legacy.Group("api/genres")
+	genreApi.GET("", allGenres)
+	genreApi.POST("", librarianOnly, createGenre)
+	genreApi.DELETE("", librarianOnly, deleteGenre)
+	genreApi.PUT("", librarianOnly, updateGenre)
+
+	readerApi := legacy.Group("api/readers", librarianOnly)
+	readerApi.GET("", allReaders)
+	readerApi.POST("", createReader)
+	readerApi.DELETE("", deleteReader)
+	readerApi.PUT("", updateReader)
+
+	bookApi := legacy.Group("api/books", librarianOnly)
+	bookApi.GET("", showBooks)
+	bookApi.POST("", createBook)
+	bookApi.DELETE("", deleteBook)
+	bookApi.PUT("", updateBook)
+	bookApi.POST("files", addBookFile)
+	bookApi.POST("draft", draftBook)
+
+	userApi := legacy.Group("api/users", librarianOnly)
+	userApi.GET("*id", getUser)
+	userApi.POST("", createUsers)
+	userApi.DELETE("", deleteUser)
+	userApi.PUT("", changePassword)
+
+	roleApi := legacy.Group("api/roles", librarianOnly)
+	roleApi.GET("*id", getRoles)
+	roleApi.POST("", createRole)
+	roleApi.DELETE("", deleteRole)
+	roleApi.PUT("", changeRole)
+
+	legacy.POST("/api/returnbook", returnBook)
+	legacy.POST("/api/rentalhistory", showHistory)
+	legacy.POST("/save", saveFile)
+	legacy.GET("logout", logout)
+	legacy.POST("take-book", takeBook)
+	legacy.POST("load-book", loadBook)
+	legacy.GET("load-book/:token", loadBook)
+	legacy.POST("revoke-book-token", revokeBookToken)
+	legacy.POST("verify-watermark", verifyWatermark)
+}
+
+// deprecated is the adapter in front of every legacy route.
+func deprecated(c *gin.Context) {
+	h := c.Writer.Header()
+	h.Set("Deprecation", "@"+strconv.FormatInt(legacyDeprecated.Unix(), 10))
+	h.Set("Sunset", legacySunset.Format(http.TimeFormat))
+	if successor, ok := legacySuccessors[c.FullPath()]; ok {
+		for _, p := range c.Params {
+			successor = strings.Replace(successor, ":"+p.Key, p.Value, 1)
+		}
+		h.Add("Link", "<"+successor+`>; rel="successor-version"`)
+	}
+	c.Next()
+}
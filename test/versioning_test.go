@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestLegacyLibrarianOnly checks that the legacy routes refuse readers
+// where v1 does.
+func TestLegacyLibrarianOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := setupRouter()
+	token, err := generateAccessToken(Users{Id: 1, Name: "reader", Role: "reader"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := []struct{ method, path string }{
+		{"POST", "/api/authors"},
+		{"PUT", "/api/authors"},
+		{"DELETE", "/api/authors"},
+		{"POST", "/api/genres"},
+		{"PUT", "/api/genres"},
+		{"DELETE", "/api/genres"},
+		{"GET", "/api/readers"},
+		{"POST", "/api/readers"},
+		{"PUT", "/api/readers"},
+		{"DELETE", "/api/readers"},
+		{"GET", "/api/books"},
+		{"POST", "/api/books"},
+		{"PUT", "/api/books"},
+		{"DELETE", "/api/books"},
+		{"POST", "/api/books/files"},
+		{"POST", "/api/books/draft"},
+		{"GET", "/api/users/"},
+		{"GET", "/api/users/1"},
+		{"POST", "/api/users"},
+		{"PUT", "/api/users"},
+		{"DELETE", "/api/users"},
+		{"GET", "/api/roles/"},
+		{"GET", "/api/roles/1"},
+		{"POST", "/api/roles"},
+		{"PUT", "/api/roles"},
+		{"DELETE", "/api/roles"},
+	}
+	for _, route := range routes {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(route.method, route.path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: got %d, want %d", route.method, route.path, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+// TestDocsNotDeprecated checks that the document and its UI, which cover
+// every version, are served at the root without the legacy headers.
+func TestDocsNotDeprecated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := setupRouter()
+	for _, path := range []string{"/openapi.json", "/docs/", apiV1 + "/openapi.json", apiV1 + "/docs/"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: got %d, want %d", path, w.Code, http.StatusOK)
+		}
+		if w.Header().Get("Deprecation") != "" || w.Header().Get("Sunset") != "" {
+			t.Errorf("GET %s is marked deprecated", path)
+		}
+	}
+}
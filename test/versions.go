@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+)
+
+// Rows of the tables edited through the API carry a version that every
+// update increments. GET returns it as the ETag and PUT, PATCH and DELETE
+// have to send it back in If-Match, so a change based on a stale copy fails
+// with 412 instead of overwriting what someone else saved in between.
+
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// versionOf reads the Version field of a row struct.
+func versionOf(model interface{}) int64 {
+	return reflect.Indirect(reflect.ValueOf(model)).FieldByName("Version").Int()
+}
+
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", etag(version))
+}
+
+// anyVersion is what ifMatch returns for "If-Match: *", which matches
+// whatever version the row is at. Writes compare versions with
+// version = COALESCE(NULLIF(?, 0), version), so it only needs the row to exist.
+const anyVersion int64 = 0
+
+// ifMatch returns the version named by the If-Match header. Weak tags are
+// accepted, a value that names no version never matches.
+func ifMatch(c *gin.Context) (int64, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		return 0, errIfMatchRequired
+	}
+	if header == "*" {
+		return anyVersion, nil
+	}
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(header, "W/"), `"`), 10, 64)
+	if err != nil || version <= 0 {
+		return 0, errVersionChanged.withDetails(header)
+	}
+	return version, nil
+}
+
+// notModified answers a GET whose If-None-Match names the current version.
+func notModified(c *gin.Context, version int64) bool {
+	for _, tag := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(version) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// missedRow explains why a write limited to version matched no row: the
+// row is gone, it has moved on to another version, or it failed the
+// handler's own condition and otherwise is returned.
+func missedRow(table, idColumn string, id, version int64, otherwise error) error {
+	var current int64
+	_, err := db.QueryOne(pg.Scan(&current), fmt.Sprintf(`SELECT version FROM %s WHERE %s = ?`, table, idColumn), id)
+	if err != nil {
+		return err
+	}
+	if version != anyVersion && current != version {
+		return errVersionChanged
+	}
+	return otherwise
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int64
+		err     error
+	}{
+		{header: `"3"`, version: 3},
+		{header: `W/"3"`, version: 3},
+		{header: "*", version: anyVersion},
+		{header: "", err: errIfMatchRequired},
+		{header: `"0"`, err: errVersionChanged},
+		{header: `"abc"`, err: errVersionChanged},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("PUT", "/", nil)
+		if tt.header != "" {
+			c.Request.Header.Set("If-Match", tt.header)
+		}
+		version, err := ifMatch(c)
+		if tt.err != nil {
+			if e, ok := err.(*apiError); !ok || e.Code != tt.err.(*apiError).Code {
+				t.Errorf("ifMatch(%q) = %v, want %v", tt.header, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || version != tt.version {
+			t.Errorf("ifMatch(%q) = %d, %v, want %d", tt.header, version, err, tt.version)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// Every page of a rented PDF gets a visible line naming the reader and the
+// rental, and the signed mark as a transparent text layer at the bottom
+// left. The mark is also kept in the document information entry
+// watermarkProperty. Both survive copying the file and most re-saving, but
+// anyone editing the PDF can strip them, and printing, rasterizing or
+// retyping the book loses them. A mark found proves which rental a copy
+// came from, a missing one proves nothing.
+const watermarkProperty = "LibraryCopy"
+
+// watermarkPattern finds an encoded mark in the page content.
+var watermarkPattern = regexp.MustCompile(`v1\.\d+\.\d+\.\d+\.[0-9a-f]{32}`)
+
+// watermarkCacheDir keeps the stamped copy of every rental for as long as
+// its load token may be used to resume the download, so each Range
+// request is served from the same bytes.
+var watermarkCacheDir = envString("WATERMARK_CACHE_DIR", filepath.Join(os.TempDir(), "library-watermarks"))
+
+var errBadWatermark = newAPIError(http.StatusUnprocessableEntity, codeValidationFailed, msgBadWatermark)
+
+type watermark struct {
+	ReaderId int64     `json:"reader_id"`
+	RentalId int64     `json:"rental_id"`
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+// encode renders the mark as v1.<reader>.<rental>.<unix time>.<hmac>, the
+// signature keeps anyone from planting a mark that blames another reader.
+func (w watermark) encode() string {
+	payload := fmt.Sprintf("v1.%d.%d.%d", w.ReaderId, w.RentalId, w.IssuedAt.Unix())
+	return payload + "." + watermarkSignature(payload)
+}
+
+func watermarkSignature(payload string) string {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func decodeWatermark(s string) (watermark, error) {
+	i := strings.LastIndexByte(s, '.')
+	if i < 0 || !hmac.Equal([]byte(s[i+1:]), []byte(watermarkSignature(s[:i]))) {
+		return watermark{}, errBadWatermark
+	}
+	parts := strings.Split(s[:i], ".")
+	if len(parts) != 4 || parts[0] != "v1" {
+		return watermark{}, errBadWatermark
+	}
+	var nums [3]int64
+	for j := range nums {
+		n, err := strconv.ParseInt(parts[j+1], 10, 64)
+		if err != nil {
+			return watermark{}, errBadWatermark
+		}
+		nums[j] = n
+	}
+	return watermark{ReaderId: nums[0], RentalId: nums[1], IssuedAt: time.Unix(nums[2], 0).UTC()}, nil
+}
+
+// watermarkedCopy returns the path of the copy of the PDF at src stamped
+// for the given rental, creating it on first use. src is never modified.
+func watermarkedCopy(src string, rental *RentalHistory) (string, error) {
+	dst := filepath.Join(watermarkCacheDir, fmt.Sprintf("%d.pdf", rental.RentalId))
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	}
+	if err := os.MkdirAll(watermarkCacheDir, 0700); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(watermarkCacheDir, "tmp-*.pdf")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	wm := watermark{ReaderId: rental.ReaderId, RentalId: rental.RentalId, IssuedAt: rental.RentalDate.UTC()}
+	err = stampPDF(src, tmp, wm)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return dst, os.Rename(tmp.Name(), dst)
+}
+
+func stampPDF(src string, w *os.File, wm watermark) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ctx, err := api.ReadContext(f, pdfConfig())
+	if err != nil {
+		return err
+	}
+	if err := api.ValidateContext(ctx); err != nil {
+		return err
+	}
+	if err := api.OptimizeContext(ctx); err != nil {
+		return err
+	}
+	if err := ctx.EnsurePageCount(); err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Reader %d / Rental %d / %s", wm.ReaderId, wm.RentalId, wm.IssuedAt.Format("2006-01-02 15:04 MST"))
+	stamp, err := api.TextWatermark(text, "fontname:Helvetica, points:8, position:bc, offset:0 12, scalefactor:1 abs, rotation:0, opacity:0.6, color:0.4 0.4 0.4", true, false, pdfcpu.POINTS)
+	if err != nil {
+		return err
+	}
+	pages, err := api.PagesForPageSelection(ctx.PageCount, nil, true)
+	if err != nil {
+		return err
+	}
+	if err := ctx.AddWatermarks(pages, stamp); err != nil {
+		return err
+	}
+	mark, err := api.TextWatermark(wm.encode(), "fontname:Helvetica, points:2, position:bl, offset:0 0, scalefactor:1 abs, rotation:0, opacity:0", true, false, pdfcpu.POINTS)
+	if err != nil {
+		return err
+	}
+	if err := ctx.AddWatermarks(pages, mark); err != nil {
+		return err
+	}
+
+	if ctx.Info == nil {
+		ctx.Info, err = ctx.IndRefForNewObject(pdfcpu.NewDict())
+		if err != nil {
+			return err
+		}
+	}
+	if err := pdfcpu.PropertiesAdd(ctx.XRefTable, map[string]string{watermarkProperty: wm.encode()}); err != nil {
+		return err
+	}
+	return api.WriteContext(ctx, w)
+}
+
+// purgeWatermarkCache removes stamped copies older than maxAge, by then
+// their load tokens have expired.
+func purgeWatermarkCache(maxAge time.Duration) {
+	files, err := ioutil.ReadDir(watermarkCacheDir)
+	if err != nil {
+		return
+	}
+	for _, info := range files {
+		if time.Since(info.ModTime()) > maxAge {
+			if err := os.Remove(filepath.Join(watermarkCacheDir, info.Name())); err != nil {
+				log.Println("purge watermark cache:", err)
+			}
+		}
+	}
+}
+
+// findWatermark returns the mark of the PDF from the document information,
+// or from the page content when that entry was removed or altered.
+func findWatermark(ctx *pdfcpu.Context) (watermark, error) {
+	if wm, err := decodeWatermark(ctx.Properties[watermarkProperty]); err == nil {
+		return wm, nil
+	}
+	for _, entry := range ctx.Table {
+		if entry == nil || entry.Free {
+			continue
+		}
+		sd, ok := entry.Object.(pdfcpu.StreamDict)
+		if !ok || sd.Decode() != nil {
+			continue
+		}
+		for _, s := range watermarkPattern.FindAll(sd.Content, -1) {
+			if wm, err := decodeWatermark(string(s)); err == nil {
+				return wm, nil
+			}
+		}
+	}
+	return watermark{}, errBadWatermark
+}
+
+func currentRental(readerId int64, bookId int64) (*RentalHistory, error) {
+	var rental RentalHistory
+	_, err := db.QueryOne(&rental, `SELECT * FROM rental_history WHERE reader_id = ? AND book_id = ? AND return_date IS NULL
+ORDER BY rental_date DESC LIMIT 1`, readerId, bookId)
+	if err != nil {
+		return nil, err
+	}
+	return &rental, nil
+}
+
+// verifyWatermark decodes the invisible mark of a submitted PDF and
+// reports who the copy was issued to.
+func verifyWatermark(c *gin.Context) {
+	if !isLibrarian(c) {
+		respondError(c, errForbidden)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBookSize+1<<20)
+	fh, err := c.FormFile("book")
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	defer f.Close()
+	ctx, err := api.ReadContext(f, pdfConfig())
+	if err == nil {
+		// validation fills in the document properties
+		err = api.ValidateContext(ctx)
+	}
+	if err != nil {
+		respondError(c, invalidFile(msgInvalidBookFile, err))
+		return
+	}
+	wm, err := findWatermark(ctx)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	var rental struct {
+		RentalHistory
+		Book   string `pg:"book"`
+		Reader string `pg:"reader"`
+	}
+	_, err = db.QueryOne(&rental, `SELECT rh.*, b.name AS book, r.name AS reader FROM rental_history rh
+INNER JOIN book b ON b.book_id = rh.book_id INNER JOIN reader r ON r.reader_id = rh.reader_id WHERE rh.rental_id = ?`, wm.RentalId)
+	if err != nil && err != pg.ErrNoRows {
+		respondError(c, err)
+		return
+	}
+	result := gin.H{"watermark": wm}
+	if err == nil && rental.ReaderId == wm.ReaderId {
+		result["rental"] = rental
+	}
+	c.JSON(http.StatusOK, result)
+}
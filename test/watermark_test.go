@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func TestDecodeWatermark(t *testing.T) {
+	wm := watermark{ReaderId: 7, RentalId: 42, IssuedAt: time.Unix(1700000000, 0).UTC()}
+	mark := wm.encode()
+	got, err := decodeWatermark(mark)
+	if err != nil || got != wm {
+		t.Fatalf("decodeWatermark(%q) = %v, %v, want %v", mark, got, err, wm)
+	}
+	for _, s := range []string{
+		"",
+		"v1.7.42.1700000000",
+		"v1.8.42.1700000000." + watermarkSignature("v1.7.42.1700000000"),
+		mark[:len(mark)-1] + "0",
+		"v2.7.42.1700000000." + watermarkSignature("v2.7.42.1700000000"),
+		"v1.7.x.1700000000." + watermarkSignature("v1.7.x.1700000000"),
+	} {
+		if _, err := decodeWatermark(s); err != errBadWatermark {
+			t.Errorf("decodeWatermark(%q) = %v, want errBadWatermark", s, err)
+		}
+	}
+}
+
+// TestFindWatermark stamps a one page PDF and finds the mark both in the
+// document information and, once that is gone, in the page content.
+func TestFindWatermark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watermark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	xRefTable, err := pdfcpu.CreateDemoXRef(pdfcpu.NewPage(pdfcpu.RectForFormat("A4")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "book.pdf")
+	if err := api.CreatePDFFile(xRefTable, src, pdfConfig()); err != nil {
+		t.Fatal(err)
+	}
+	stamped, err := os.Create(filepath.Join(dir, "stamped.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wm := watermark{ReaderId: 7, RentalId: 42, IssuedAt: time.Unix(1700000000, 0).UTC()}
+	err = stampPDF(src, stamped, wm)
+	stamped.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stripInfo := range []bool{false, true} {
+		f, err := os.Open(stamped.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, err := api.ReadContext(f, pdfConfig())
+		if err == nil {
+			err = api.ValidateContext(ctx)
+		}
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stripInfo {
+			delete(ctx.Properties, watermarkProperty)
+		}
+		if got, err := findWatermark(ctx); err != nil || got != wm {
+			t.Errorf("findWatermark (info removed: %v) = %v, %v, want %v", stripInfo, got, err, wm)
+		}
+	}
+}